@@ -0,0 +1,494 @@
+package DistributedCache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+AOF(append-only file)记录本节点上显式的写入，弥补快照之后的写入在重启时丢失的问题：
+	header : "GEEAOF" 版本号(1字节)
+	record : uvarint(len(body)) body crc32c(body)
+body的第一个字节是操作类型，Set/CompareAndSet/Incr等对key的写入都记录为写入之后key的状态
+（set或者delete），重放时不依赖当时的时间和getter；按标签、前缀失效和Flush记录为对应的操作，
+Flush同时记录进入的代，重放时已经加载了不早于这个代的快照则跳过，只删除之前重放的写入
+写入在持有日志的锁时执行并追加，日志的顺序与缓存中的写入顺序一致
+从数据源加载的值和淘汰都不写入日志，重放时仍然由lru按cacheBytes淘汰
+日志超过一定大小之后在后台重写(compaction)，只保留缓存中仍然是日志中最后一次写入的key
+*/
+
+// AOFSync 日志的fsync策略
+type AOFSync int
+
+const (
+	// SyncEverySecond 每秒fsync一次，最多丢失一秒的写入，默认策略
+	SyncEverySecond AOFSync = iota
+	// SyncAlways 每次写入都fsync之后才返回
+	SyncAlways
+	// SyncNever 不主动fsync，由操作系统决定何时落盘
+	SyncNever
+)
+
+func (s AOFSync) String() string {
+	switch s {
+	case SyncEverySecond:
+		return "everysec"
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrAOFOpen Group已经打开了日志
+	ErrAOFOpen = errors.New("geecache: aof already open")
+	// ErrAOFFormat 不是AOF日志，或者是不支持的版本
+	ErrAOFFormat = errors.New("geecache: unsupported aof format")
+	// ErrAOFWrite 日志写入或fsync失败，这次写入已经在内存中生效但可能没有落盘，
+	// 之后的写入都会被拒绝，直到重新打开日志
+	ErrAOFWrite = errors.New("geecache: aof write failed")
+)
+
+const (
+	aofMagic   = "GEEAOF"
+	aofVersion = 1
+	//  默认在日志达到64MB并且是上次重写之后的两倍时重写
+	defaultAOFRewriteSize = 64 << 20
+)
+
+// 日志中的操作类型
+const (
+	aofSet byte = iota + 1
+	aofDelete
+	aofInvalidateTag
+	aofInvalidatePrefix
+	aofFlush
+)
+
+// AOFOption 日志的可选项
+type AOFOption func(*aofLog)
+
+// WithAOFSync 设置fsync策略
+func WithAOFSync(policy AOFSync) AOFOption {
+	return func(a *aofLog) {
+		a.policy = policy
+	}
+}
+
+// WithAOFRewrite 日志不小于minSize并且是上次重写之后的两倍时在后台重写，为0时不自动重写
+func WithAOFRewrite(minSize int64) AOFOption {
+	return func(a *aofLog) {
+		a.rewriteSize = minSize
+	}
+}
+
+// OpenAOF 重放path中的日志，之后本节点上显式的写入都追加到path，返回重放的记录数量
+// 应当在节点加入集群之前调用；日志末尾不完整或者损坏的记录会被截断
+func (g *Group) OpenAOF(path string, opts ...AOFOption) (int, error) {
+	if g.aof.Load() != nil {
+		return 0, ErrAOFOpen
+	}
+	a := &aofLog{path: path, rewriteSize: defaultAOFRewriteSize}
+	for _, opt := range opts {
+		opt(a)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, size, keys, err := g.replayAOF(f)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	a.f, a.size, a.base, a.keys = f, size, size, keys
+	if size == 0 {
+		if err := a.writeHeader(f); err != nil {
+			f.Close()
+			return n, err
+		}
+		a.size, a.base = int64(len(aofMagic)+1), int64(len(aofMagic)+1)
+	}
+	a.stop, a.done = make(chan struct{}), make(chan struct{})
+	if !g.aof.CompareAndSwap(nil, a) {
+		f.Close()
+		return n, ErrAOFOpen
+	}
+	go a.run(g.mainCache.snapshot)
+	return n, nil
+}
+
+// CloseAOF 停止写入日志，fsync之后关闭文件
+func (g *Group) CloseAOF() error {
+	a := g.aof.Swap(nil)
+	if a == nil {
+		return nil
+	}
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.f.Sync()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RewriteAOF 用缓存中显式写入的key重写日志，重写期间的写入不受影响
+func (g *Group) RewriteAOF() error {
+	a := g.aof.Load()
+	if a == nil {
+		return fmt.Errorf("aof not open")
+	}
+	return a.rewrite(g.mainCache.snapshot)
+}
+
+// logKey 在持有日志的锁时执行修改key的fn，fn返回true时把key之后的状态追加到日志
+// 日志没有打开时直接执行fn
+func (g *Group) logKey(key string, fn func() bool) error {
+	a := g.aof.Load()
+	if a == nil {
+		fn()
+		return nil
+	}
+	return a.append(func() []byte {
+		if !fn() {
+			return nil
+		}
+		//  值太大被立即淘汰时记录为删除，与重放之后的结果相同
+		if e, ok := g.mainCache.entry(key); ok {
+			a.keys[key] = e.it.Value.(ByteView).Version()
+			return append([]byte{aofSet}, encodeSnapshotEntry(e)...)
+		}
+		delete(a.keys, key)
+		return append([]byte{aofDelete}, key...)
+	})
+}
+
+// logFlush 在持有日志的锁时执行Flush，fn返回进入的代，与Flush一起记录
+func (g *Group) logFlush(fn func() uint64) error {
+	a := g.aof.Load()
+	if a == nil {
+		fn()
+		return nil
+	}
+	return a.append(func() []byte {
+		gen := fn()
+		a.keys = make(map[string]uint64)
+		return binary.AppendUvarint([]byte{aofFlush}, gen)
+	})
+}
+
+// logOp 在持有日志的锁时执行fn，并把op追加到日志
+func (g *Group) logOp(op byte, arg string, fn func()) error {
+	a := g.aof.Load()
+	if a == nil {
+		fn()
+		return nil
+	}
+	return a.append(func() []byte {
+		fn()
+		return append([]byte{op}, arg...)
+	})
+}
+
+// replayAOF 把日志重放到mainCache，返回重放的记录数量、有效内容的长度，
+// 以及重放之后缓存中的值来自日志的key和它们的版本号
+func (g *Group) replayAOF(f *os.File) (int, int64, map[string]uint64, error) {
+	keys := make(map[string]uint64)
+	r := bufio.NewReader(f)
+	header := make([]byte, len(aofMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return 0, 0, keys, nil
+		}
+		return 0, 0, nil, fmt.Errorf("%s: %w", f.Name(), ErrAOFFormat)
+	}
+	if string(header[:len(aofMagic)]) != aofMagic || header[len(aofMagic)] != aofVersion {
+		return 0, 0, nil, fmt.Errorf("%s: %w", f.Name(), ErrAOFFormat)
+	}
+	offset, n := int64(len(header)), 0
+	for {
+		body, size, err := readAOFRecord(r)
+		if err == io.EOF {
+			return n, offset, keys, nil
+		}
+		if err == nil {
+			err = g.applyAOF(body, keys)
+		}
+		if err != nil {
+			//  进程在写入时退出会留下不完整的记录，丢弃之后的内容继续启动
+			log.Printf("[geecache] group %s: truncating aof %s at offset %d: %v", g.name, f.Name(), offset, err)
+			if err := f.Truncate(offset); err != nil {
+				return n, offset, nil, err
+			}
+			return n, offset, keys, nil
+		}
+		offset += size
+		n++
+	}
+}
+
+// applyAOF 重放一条记录，keys记录之前重放的写入
+func (g *Group) applyAOF(body []byte, keys map[string]uint64) error {
+	op, arg := body[0], body[1:]
+	switch op {
+	case aofSet:
+		e, err := decodeSnapshotEntry(arg)
+		if err != nil {
+			return err
+		}
+		if hardExpired(e.it, time.Now()) {
+			g.mainCache.remove(e.key)
+			delete(keys, e.key)
+			return nil
+		}
+		keys[e.key] = g.mainCache.addItem(e.key, e.it, e.tags).Version()
+	case aofDelete:
+		g.mainCache.remove(string(arg))
+		delete(keys, string(arg))
+	case aofInvalidateTag:
+		g.mainCache.removeTag(string(arg), nil)
+	case aofInvalidatePrefix:
+		g.mainCache.removePrefix(string(arg))
+	case aofFlush:
+		gen, n := binary.Uvarint(arg)
+		if n <= 0 {
+			return fmt.Errorf("invalid flush record")
+		}
+		if gen <= g.mainCache.generation() {
+			//  加载的快照晚于这次Flush，快照中的条目保留，只删除Flush之前的写入
+			for key := range keys {
+				g.mainCache.remove(key)
+			}
+		} else {
+			g.mainCache.flush(gen)
+		}
+		for key := range keys {
+			delete(keys, key)
+		}
+	default:
+		return fmt.Errorf("unknown aof op %d", op)
+	}
+	return nil
+}
+
+// aofLog 是打开的日志文件
+type aofLog struct {
+	path        string
+	policy      AOFSync
+	rewriteSize int64
+
+	mu        sync.Mutex
+	f         *os.File
+	size      int64    //  文件的长度
+	base      int64    //  上次重写之后文件的长度
+	dirty     bool     //  有写入还没有fsync
+	err       error    //  写入失败之后不再接受写入
+	rewriting bool     //  正在重写，期间的记录同时保存到pending
+	pending   [][]byte //  重写期间追加的记录
+	//  缓存中的值来自日志的key和日志中记录的版本号，重写时只保留版本号一致的key，
+	//  之后被淘汰、失效或者从数据源重新加载的key都不再写入
+	keys map[string]uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (a *aofLog) writeHeader(w io.Writer) error {
+	_, err := w.Write(append([]byte(aofMagic), aofVersion))
+	return err
+}
+
+// append 在持有锁时调用fn执行写入，并把fn返回的记录追加到日志，fn返回nil时不追加
+func (a *aofLog) append(fn func() []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	body := fn()
+	if body == nil {
+		return nil
+	}
+	rec := appendAOFRecord(nil, body)
+	if _, err := a.f.Write(rec); err != nil {
+		a.err = fmt.Errorf("%w: %v", ErrAOFWrite, err)
+		return a.err
+	}
+	a.size += int64(len(rec))
+	if a.rewriting {
+		a.pending = append(a.pending, rec)
+	}
+	if a.policy == SyncAlways {
+		if err := a.f.Sync(); err != nil {
+			a.err = fmt.Errorf("%w: %v", ErrAOFWrite, err)
+			return a.err
+		}
+		return nil
+	}
+	a.dirty = true
+	return nil
+}
+
+// run 在后台每秒fsync，并在日志变大之后重写
+func (a *aofLog) run(snapshot func() ([]snapshotEntry, uint64)) {
+	defer close(a.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		if a.policy == SyncEverySecond && a.dirty && a.err == nil {
+			if err := a.f.Sync(); err != nil {
+				a.err = fmt.Errorf("%w: %v", ErrAOFWrite, err)
+			}
+			a.dirty = false
+		}
+		grow := a.rewriteSize > 0 && a.size >= a.rewriteSize && a.size >= 2*a.base
+		a.mu.Unlock()
+		if grow {
+			if err := a.rewrite(snapshot); err != nil {
+				log.Printf("[geecache] rewrite aof %s: %v", a.path, err)
+			}
+		}
+	}
+}
+
+// rewrite 把缓存中显式写入的条目写入新的日志，加上重写期间追加的记录之后替换原来的日志
+func (a *aofLog) rewrite(snapshot func() ([]snapshotEntry, uint64)) error {
+	a.mu.Lock()
+	if a.rewriting || a.err != nil {
+		err := a.err
+		a.mu.Unlock()
+		return err
+	}
+	//  在持有锁时取快照，之前的写入都在快照中，之后的写入都在pending中
+	all, gen := snapshot()
+	entries, keys := all[:0], make(map[string]uint64)
+	for _, e := range all {
+		if ver := e.it.Value.(ByteView).Version(); a.keys[e.key] == ver {
+			entries = append(entries, e)
+			keys[e.key] = ver
+		}
+	}
+	a.keys = keys
+	a.rewriting, a.pending = true, nil
+	a.mu.Unlock()
+
+	f, size, err := a.writeEntries(entries, gen)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	pending := a.pending
+	a.pending = nil
+	if err == nil {
+		for _, rec := range pending {
+			if _, err = f.Write(rec); err != nil {
+				break
+			}
+			size += int64(len(rec))
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), a.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	syncDir(filepath.Dir(a.path))
+	a.f.Close()
+	a.f, a.size, a.base, a.dirty = f, size, size, false
+	return nil
+}
+
+// writeEntries 把条目写入临时文件，条目按从新到旧排列，写入时从旧到新，重放之后恢复LRU顺序
+// 条目之前先记录进入gen的Flush，重放之后恢复当前的代
+func (a *aofLog) writeEntries(entries []snapshotEntry, gen uint64) (*os.File, int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".rewrite*")
+	if err != nil {
+		return nil, 0, err
+	}
+	w := bufio.NewWriter(f)
+	size := int64(len(aofMagic) + 1)
+	a.writeHeader(w)
+	if gen > 0 {
+		rec := appendAOFRecord(nil, binary.AppendUvarint([]byte{aofFlush}, gen))
+		w.Write(rec)
+		size += int64(len(rec))
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		rec := appendAOFRecord(nil, append([]byte{aofSet}, encodeSnapshotEntry(entries[i])...))
+		w.Write(rec)
+		size += int64(len(rec))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// syncDir fsync目录，确保重命名落盘
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// appendAOFRecord 把body编码为一条记录
+func appendAOFRecord(b, body []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(body)))
+	b = append(b, body...)
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(body, snapshotTable))
+}
+
+// readAOFRecord 读取一条记录，返回body和记录占用的字节数，没有更多记录时返回io.EOF
+func readAOFRecord(r *bufio.Reader) ([]byte, int64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if n == 0 || n > maxSnapshotRecord {
+		return nil, 0, fmt.Errorf("invalid record length %d", n)
+	}
+	b := make([]byte, n+4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	body := b[:n]
+	if crc32.Checksum(body, snapshotTable) != binary.BigEndian.Uint32(b[n:]) {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+	return body, int64(uvarintLen(n)) + int64(n) + 4, nil
+}
+
+func uvarintLen(n uint64) int {
+	return len(binary.AppendUvarint(nil, n))
+}
@@ -0,0 +1,249 @@
+package DistributedCache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newAOFGroup(t *testing.T, name string, cacheBytes int64, path string) *Group {
+	t.Helper()
+	g := NewGroup(name, cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	if _, err := g.OpenAOF(path, WithAOFSync(SyncAlways)); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// 测试显式的写入在重启之后重放，从数据源加载的值不写入日志
+func TestAOFReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	g := newAOFGroup(t, "aof-replay", 2<<10, path)
+	ctx := context.Background()
+	g.Set(ctx, "a", []byte("1"), time.Time{}, "t1")
+	g.Set(ctx, "b", []byte("2"), time.Time{}, "t1")
+	g.Set(ctx, "user:1", []byte("u"), time.Time{})
+	g.Set(ctx, "deleted", []byte("x"), time.Time{})
+	g.Delete(ctx, "deleted")
+	g.Incr(ctx, "counter", 5, time.Hour)
+	g.Incr(ctx, "counter", 2, 0)
+	_, ver, _ := g.GetWithVersion(ctx, "a")
+	g.CompareAndSet(ctx, "a", []byte("cas"), ver, 0)
+	g.CompareAndSet(ctx, "a", []byte("lost"), ver, 0) //  版本号不一致，不写入日志
+	g.InvalidateTag(ctx, "t1")
+	g.InvalidatePrefix(ctx, "user:")
+	g.Set(ctx, "c", []byte("3"), time.Time{}, "t1")
+	g.Get("loaded", time.Time{})
+	if err := g.CloseAOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewGroup("aof-replay-restored", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	n, err := restored.OpenAOF(path)
+	if err != nil || n != 11 {
+		t.Fatalf("OpenAOF = %d, %v", n, err)
+	}
+	defer restored.CloseAOF()
+	//  CompareAndSet没有带标签，a不受InvalidateTag影响
+	if keys := restored.Keys(); !reflect.DeepEqual(keys, []string{"c", "a", "counter"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if v, ok := restored.mainCache.get("counter"); !ok || v.String() != "7" || v.Expire().IsZero() {
+		t.Fatalf("counter not restored with its ttl: %q %v", v.String(), v.Expire())
+	}
+	if n, _ := restored.InvalidateTag(ctx, "t1"); n != 1 {
+		t.Fatalf("tags not restored, removed %d", n)
+	}
+}
+
+// 测试淘汰不写入日志，重放时仍然按cacheBytes淘汰；Flush之前的写入重放之后不可见
+func TestAOFEvictionAndFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	cacheBytes := int64(len("key0value") * 2)
+	g := newAOFGroup(t, "aof-evict", cacheBytes, path)
+	ctx := context.Background()
+	g.Set(ctx, "old", []byte("value"), time.Time{})
+	g.Flush(ctx)
+	for i := 0; i < 5; i++ {
+		g.Set(ctx, fmt.Sprintf("key%d", i), []byte("value"), time.Time{})
+	}
+	g.CloseAOF()
+
+	restored := NewGroup("aof-evict-restored", cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if n, err := restored.OpenAOF(path); err != nil || n != 7 {
+		t.Fatalf("OpenAOF = %d, %v", n, err)
+	}
+	defer restored.CloseAOF()
+	if keys := restored.Keys(); !reflect.DeepEqual(keys, []string{"key4", "key3"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+// 测试日志末尾不完整的记录在重放时被截断，之后的写入可以继续重放
+func TestAOFTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	g := newAOFGroup(t, "aof-torn", 2<<10, path)
+	ctx := context.Background()
+	g.Set(ctx, "a", []byte("1"), time.Time{})
+	g.Set(ctx, "b", []byte("2"), time.Time{})
+	g.CloseAOF()
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+
+	g2 := newAOFGroup(t, "aof-torn-2", 2<<10, path)
+	if keys := g2.Keys(); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	g2.Set(ctx, "c", []byte("3"), time.Time{})
+	g2.CloseAOF()
+
+	g3 := NewGroup("aof-torn-3", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if n, err := g3.OpenAOF(path); err != nil || n != 2 {
+		t.Fatalf("OpenAOF = %d, %v", n, err)
+	}
+	g3.CloseAOF()
+
+	os.WriteFile(path, []byte("not an aof"), 0644)
+	g4 := NewGroup("aof-torn-4", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if _, err := g4.OpenAOF(path); !errors.Is(err, ErrAOFFormat) {
+		t.Fatalf("expected ErrAOFFormat, got %v", err)
+	}
+}
+
+// 测试重写之后日志变小，重放的结果不变
+func TestAOFRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	g := newAOFGroup(t, "aof-rewrite", 2<<10, path)
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		g.Set(ctx, "a", []byte(fmt.Sprint(i)), time.Time{})
+		g.Set(ctx, "b", []byte(fmt.Sprint(i)), time.Time{}, "tag")
+	}
+	//  从数据源加载的值，以及显式写入之后又从数据源重新加载的key都不写入重写的日志
+	g.Get("loaded", time.Time{})
+	g.Set(ctx, "reloaded", []byte("x"), time.Time{})
+	g.mainCache.remove("reloaded")
+	g.Get("reloaded", time.Time{})
+	before, _ := os.Stat(path)
+	if err := g.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/10 {
+		t.Fatalf("rewrite did not compact: %d -> %d bytes", before.Size(), after.Size())
+	}
+	g.Set(ctx, "c", []byte("after"), time.Time{})
+	g.Get("a", time.Time{})
+	g.CloseAOF()
+	if matches, _ := filepath.Glob(path + ".rewrite*"); len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+
+	restored := NewGroup("aof-rewrite-restored", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	if n, err := restored.OpenAOF(path); err != nil || n != 3 {
+		t.Fatalf("OpenAOF = %d, %v", n, err)
+	}
+	defer restored.CloseAOF()
+	if keys := restored.Keys(); !reflect.DeepEqual(keys, []string{"c", "b", "a"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if v, _ := restored.mainCache.get("b"); v.String() != "99" {
+		t.Fatalf("unexpected b %q", v.String())
+	}
+}
+
+// 测试先加载快照再重放日志时，早于快照的Flush只删除之前重放的写入，不清空快照中的条目
+func TestAOFFlushBeforeSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	g := newAOFGroup(t, "aof-flush-snapshot", 2<<10, path)
+	ctx := context.Background()
+	g.Set(ctx, "old", []byte("1"), time.Time{})
+	gen, _ := g.Flush(ctx)
+	g.Set(ctx, "new", []byte("2"), time.Time{})
+	g.Get("loaded", time.Time{})
+	var snap bytes.Buffer
+	if _, err := g.SaveSnapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+	g.CloseAOF()
+
+	restored := newSnapshotGroup("aof-flush-snapshot-restored", 2<<10)
+	if _, err := restored.LoadSnapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.OpenAOF(path); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.CloseAOF()
+	if keys := restored.Keys(); !reflect.DeepEqual(keys, []string{"new", "loaded"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if restored.Generation() != gen {
+		t.Fatalf("generation = %d, want %d", restored.Generation(), gen)
+	}
+
+	//  没有快照时Flush照常重放，重写之后的日志同样恢复代
+	if err := restored.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	restored.CloseAOF()
+	again := newSnapshotGroup("aof-flush-snapshot-again", 2<<10)
+	if _, err := again.OpenAOF(path); err != nil {
+		t.Fatal(err)
+	}
+	defer again.CloseAOF()
+	if keys := again.Keys(); !reflect.DeepEqual(keys, []string{"new"}) || again.Generation() != gen {
+		t.Fatalf("unexpected keys %v at generation %d", keys, again.Generation())
+	}
+}
+
+// 测试日志写入失败之后拒绝写入，缓存保持不变
+func TestAOFWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.aof")
+	g := newAOFGroup(t, "aof-error", 2<<10, path)
+	ctx := context.Background()
+	g.Set(ctx, "a", []byte("1"), time.Time{})
+	g.aof.Load().f.Close()
+
+	//  失败的这次写入已经生效，但是没有记录到日志
+	if err := g.Set(ctx, "a", []byte("2"), time.Time{}); !errors.Is(err, ErrAOFWrite) {
+		t.Fatalf("Set = %v, want ErrAOFWrite", err)
+	}
+	if err := g.Set(ctx, "a", []byte("3"), time.Time{}); !errors.Is(err, ErrAOFWrite) {
+		t.Fatalf("Set = %v, want ErrAOFWrite", err)
+	}
+	if _, err := g.Incr(ctx, "n", 1, 0); !errors.Is(err, ErrAOFWrite) {
+		t.Fatalf("Incr = %v, want ErrAOFWrite", err)
+	}
+	if _, ok := g.mainCache.get("n"); ok {
+		t.Fatalf("Incr accepted after aof failure")
+	}
+	if v, _ := g.mainCache.get("a"); v.String() != "2" {
+		t.Fatalf("write accepted after aof failure: %q", v.String())
+	}
+	if _, err := g.OpenAOF(path); !errors.Is(err, ErrAOFOpen) {
+		t.Fatalf("second OpenAOF = %v", err)
+	}
+	g.CloseAOF()
+	if err := g.Set(ctx, "a", []byte("4"), time.Time{}); err != nil {
+		t.Fatalf("Set after CloseAOF: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	geecache "DistributedCache"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/**
+backend 模块提供几种开箱即用的数据源，实现了 Getter 接口的 Get(key) 方法，
+cache未命中时由它们去取回源数据，这样不写Go代码也能让节点提供真实数据。
+- HTTP: 从HTTP源站获取，key作为URL路径的一部分
+- Dir:  从本地目录读取，key作为相对文件路径
+*/
+
+// ErrNotFound 数据源中不存在该key，与geecache.ErrNotFound相同，
+// 这样HTTP前端可以返回404，远程节点也能识别出"不存在"
+var ErrNotFound = geecache.ErrNotFound
+
+// HTTP 从HTTP源站获取数据，请求地址为 BaseURL + url.PathEscape(key)
+type HTTP struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTP 创建HTTP数据源，timeout为0表示不设超时
+func NewHTTP(baseURL string, timeout time.Duration) *HTTP {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &HTTP{
+		BaseURL: baseURL,
+		Client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Get 实现Getter接口
+func (h *HTTP) Get(key string) ([]byte, error) {
+	res, err := h.Client.Get(h.BaseURL + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("origin returned: %v", res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// Dir 从本地目录读取数据，key对应Root下的相对路径
+type Dir struct {
+	Root string
+}
+
+// NewDir 创建目录数据源
+func NewDir(root string) *Dir {
+	return &Dir{Root: root}
+}
+
+// Get 实现Getter接口，key不允许跳出Root目录
+func (d *Dir) Get(key string) ([]byte, error) {
+	name := filepath.Clean("/" + filepath.FromSlash(key))
+	b, err := os.ReadFile(filepath.Join(d.Root, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return b, err
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/Tom" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("630"))
+	}))
+	defer origin.Close()
+
+	h := NewHTTP(origin.URL+"/data", 0)
+	if v, err := h.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("expected 630, got %q, %v", v, err)
+	}
+	if _, err := h.Get("Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "Tom"), []byte("630"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDir(root)
+	if v, err := d.Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("expected 630, got %q, %v", v, err)
+	}
+	if _, err := d.Get("Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	//  不允许通过 ../ 访问Root之外的文件
+	if _, err := d.Get("../" + filepath.Base(root) + "/Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("path traversal should be rejected, got %v", err)
+	}
+}
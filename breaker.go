@@ -0,0 +1,300 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+每个远程节点的client各有一个熔断器，统计访问这个节点的所有请求
+时间窗口内失败或慢请求的比例达到阈值时打开，之后的请求直接返回ErrBreakerOpen，
+读取时PickReadPeer跳过这个节点，顺着一致性哈希环选择下一个可用的节点，相当于暂时把它移出集群；
+选中的节点不是所属节点，之后的写入不会通知到它，因此以对冲请求的方式读取，加载的值不写入缓存；
+选中的是本节点时按所属节点不可用处理，只有FallbackLocalLoad会在本地加载，同样不写入缓存；
+写入、租约、CompareAndSet和Incr只能由所属节点完成，PickPeer仍然选择所属节点，请求直接失败
+打开一段时间之后进入半开状态，只放过一个探测请求，成功则关闭，失败则重新打开
+*/
+
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerOpenTimeout = 5 * time.Second
+)
+
+// ErrBreakerOpen 远程节点的熔断器处于打开状态，请求没有发出
+var ErrBreakerOpen = errors.New("geecache: peer circuit breaker open")
+
+// ReadPeerPicker 是可以为读取绕开熔断器打开的节点的PeerPicker
+type ReadPeerPicker interface {
+	// PickReadPeer 返回读取key时访问的节点，它是本节点时ok为false
+	// 所属节点的熔断器打开、选中的不是所属节点时diverted为true
+	PickReadPeer(key string) (peer Fetcher, ok bool, diverted bool)
+}
+
+// pickReadPeer 为读取选择节点，PeerPicker没有实现ReadPeerPicker时使用所属节点
+func (g *Group) pickReadPeer(key string) (Fetcher, bool, bool) {
+	if rp, ok := g.peers.(ReadPeerPicker); ok {
+		return rp.PickReadPeer(key)
+	}
+	peer, ok := g.peers.PickPeer(key)
+	return peer, ok, false
+}
+
+// loadDiverted 所属节点的熔断器打开时加载key，remote为false表示读取交给了本节点
+// 读取交给的节点不是所属节点，值只返回给调用方，不写入任何节点的缓存
+func (g *Group) loadDiverted(ctx context.Context, deadline time.Time, peer Fetcher, remote bool, key string) (interface{}, error) {
+	if !remote {
+		if g.ownerFallback != FallbackLocalLoad {
+			return g.ownerUnavailable(key, ErrBreakerOpen)
+		}
+		return g.loadUncached(ctx, key)
+	}
+	var value ByteView
+	err := g.withRetry(ctx, deadline, func() (err error) {
+		if rf, ok := peer.(replicaFetcher); ok {
+			value, err = rf.fetchReplica(ctx, g.name, key)
+		} else {
+			value, err = peer.Fetch(ctx, g.name, key)
+		}
+		return err
+	})
+	if err == nil {
+		g.Stats.PeerLoads.Add(1)
+		return value, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	g.Stats.PeerErrors.Add(1)
+	log.Printf("fail to get *%s* from peer, %s.\n", key, err.Error())
+	if g.ownerFallback != FallbackLocalLoad {
+		return g.ownerUnavailable(key, err)
+	}
+	return g.loadUncached(ctx, key)
+}
+
+// BreakerState 熔断器的状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常访问
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 不再访问，请求直接失败
+	BreakerOpen
+	// BreakerHalfOpen 放过一个探测请求，根据结果关闭或重新打开
+	BreakerHalfOpen
+)
+
+// String 返回状态的名字
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// BreakerConfig 熔断器的阈值，零值的时长和数量使用默认值
+type BreakerConfig struct {
+	Window      time.Duration //  统计失败比例的时间窗口，默认10s
+	MinRequests int           //  窗口内的请求少于这个数量时不打开，默认20
+	ErrorRate   float64       //  失败比例达到该值时打开，为0表示不按失败比例打开
+	SlowCall    time.Duration //  耗时超过该值的请求算作慢请求
+	SlowRate    float64       //  慢请求比例达到该值时打开，为0表示不按耗时打开
+	OpenTimeout time.Duration //  打开之后经过这段时间进入半开，默认5s
+}
+
+// WithCircuitBreaker 为访问每个远程节点的client开启熔断
+func WithCircuitBreaker(cfg BreakerConfig) ServerOption {
+	return func(h *server) {
+		if cfg.Window <= 0 {
+			cfg.Window = defaultBreakerWindow
+		}
+		if cfg.MinRequests <= 0 {
+			cfg.MinRequests = defaultBreakerMinRequests
+		}
+		if cfg.OpenTimeout <= 0 {
+			cfg.OpenTimeout = defaultBreakerOpenTimeout
+		}
+		h.breakerConfig = &cfg
+	}
+}
+
+// PeerBreaker 一个远程节点的熔断器状态
+type PeerBreaker struct {
+	Peer  string
+	State BreakerState
+}
+
+// breaker 是一个节点的熔断器，为nil时不熔断
+type breaker struct {
+	cfg BreakerConfig
+	now func() time.Time
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	total       int
+	failures    int
+	slow        int
+	openedAt    time.Time
+	probing     bool //  半开状态下探测请求已经发出
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	return &breaker{cfg: cfg, now: time.Now}
+}
+
+// ready 返回是否可以选择这个节点，PickPeer使用，不占用半开状态的探测机会
+func (b *breaker) ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceLocked()
+	return b.state == BreakerClosed || b.state == BreakerHalfOpen && !b.probing
+}
+
+// allow 在发出请求之前调用，返回nil时请求结束后必须调用done
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceLocked()
+	switch b.state {
+	case BreakerOpen:
+		return ErrBreakerOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrBreakerOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// done 记录一次请求的结果，latency为请求的耗时
+func (b *breaker) done(err error, latency time.Duration) {
+	if b == nil {
+		return
+	}
+	failed, counted := breakerFailure(err)
+	slow := b.cfg.SlowCall > 0 && latency > b.cfg.SlowCall
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		if !counted {
+			return
+		}
+		if failed || slow {
+			b.openLocked(now)
+		} else {
+			b.state = BreakerClosed
+			b.resetLocked(now)
+		}
+	case BreakerClosed:
+		if !counted {
+			return
+		}
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.resetLocked(now)
+		}
+		b.total++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slow++
+		}
+		if b.total < b.cfg.MinRequests {
+			return
+		}
+		total := float64(b.total)
+		if b.cfg.ErrorRate > 0 && float64(b.failures)/total >= b.cfg.ErrorRate ||
+			b.cfg.SlowRate > 0 && float64(b.slow)/total >= b.cfg.SlowRate {
+			b.openLocked(now)
+		}
+	}
+	//  打开之前发出的请求不影响打开状态
+}
+
+// State 返回熔断器当前的状态
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advanceLocked()
+	return b.state
+}
+
+// advanceLocked 打开的时间超过OpenTimeout后进入半开
+func (b *breaker) advanceLocked() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probing = false
+	}
+}
+
+func (b *breaker) openLocked(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.resetLocked(now)
+}
+
+func (b *breaker) resetLocked(now time.Time) {
+	b.windowStart = now
+	b.total, b.failures, b.slow = 0, 0, 0
+}
+
+// breakerFailure 判断请求的结果，返回是否失败以及是否计入统计
+// 节点不可达、超时和内部错误算作失败；key不存在等由节点正常给出的错误算作成功
+// 调用方取消的请求（例如对冲请求的另一方已经返回）不计入
+func breakerFailure(err error) (failed bool, counted bool) {
+	if err == nil {
+		return false, true
+	}
+	if errors.Is(err, context.Canceled) {
+		return false, false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true, true
+	}
+	switch status.Code(err) {
+	case codes.Canceled:
+		return false, false
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.ResourceExhausted:
+		return true, true
+	}
+	return false, true
+}
+
+// unaryInterceptor 让client的所有普通RPC经过熔断器
+func (b *breaker) unaryInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.done(err, time.Since(start))
+	return err
+}
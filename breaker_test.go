@@ -0,0 +1,203 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var errUnavailable = status.Error(codes.Unavailable, "connection refused")
+
+// 测试失败比例达到阈值时打开，经过OpenTimeout后半开，探测请求的结果决定关闭或重新打开
+func TestBreakerStates(t *testing.T) {
+	b := newBreaker(BreakerConfig{Window: time.Minute, MinRequests: 4, ErrorRate: 0.5, OpenTimeout: time.Second})
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.done(nil, 0)
+	b.done(status.Error(codes.NotFound, "missing"), 0) //  节点正常给出的错误不算失败
+	b.done(errUnavailable, 0)
+	b.done(context.Canceled, 0) //  取消的请求不计入
+	if b.State() != BreakerClosed {
+		t.Fatalf("breaker should stay closed below MinRequests")
+	}
+	b.done(errUnavailable, 0)
+	if b.State() != BreakerOpen || b.ready() || !errors.Is(b.allow(), ErrBreakerOpen) {
+		t.Fatalf("breaker should open at 50%% errors, state %v", b.State())
+	}
+
+	now = now.Add(time.Second)
+	if b.State() != BreakerHalfOpen || !b.ready() {
+		t.Fatalf("breaker should be half-open after OpenTimeout, state %v", b.State())
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe should be allowed: %v", err)
+	}
+	if b.ready() || !errors.Is(b.allow(), ErrBreakerOpen) {
+		t.Fatalf("only one probe is allowed")
+	}
+	b.done(errUnavailable, 0)
+	if b.State() != BreakerOpen {
+		t.Fatalf("failed probe should reopen, state %v", b.State())
+	}
+
+	now = now.Add(time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.done(nil, 0)
+	if b.State() != BreakerClosed || !b.ready() {
+		t.Fatalf("successful probe should close, state %v", b.State())
+	}
+}
+
+// 测试慢请求比例达到阈值时打开
+func TestBreakerSlowCalls(t *testing.T) {
+	b := newBreaker(BreakerConfig{Window: time.Minute, MinRequests: 4, SlowCall: 10 * time.Millisecond, SlowRate: 0.75, OpenTimeout: time.Second})
+	b.done(nil, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		b.done(nil, 20*time.Millisecond)
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("breaker should open on slow calls, state %v", b.State())
+	}
+}
+
+// 测试所属节点的熔断器打开时PickReadPeer顺着哈希环选择下一个节点，PickPeer仍然选择所属节点
+func TestPickReadPeerSkipsOpenBreaker(t *testing.T) {
+	self, a, b := "127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"
+	svr, err := NewServer(self, WithoutRegistry(), WithCircuitBreaker(BreakerConfig{MinRequests: 1, ErrorRate: 0.5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Set(self, a, b)
+	defer svr.closeClients()
+
+	//  找一个所属节点和下一个节点都不是本节点的key
+	var key string
+	var ring []string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key%d", i)
+		ring = svr.peers.GetN(key, 3)
+		if ring[0] != self && ring[1] != self {
+			break
+		}
+	}
+	if peer, ok, diverted := svr.PickReadPeer(key); !ok || diverted || peer != svr.clients[ring[0]] {
+		t.Fatalf("closed breaker should pick the owner")
+	}
+
+	svr.clients[ring[0]].breaker.done(errUnavailable, 0)
+	if peer, ok, diverted := svr.PickReadPeer(key); !ok || !diverted || peer != svr.clients[ring[1]] {
+		t.Fatalf("open breaker should read from the next replica %s", ring[1])
+	}
+	//  写入不能交给其它节点，仍然发给所属节点并由熔断器直接拒绝
+	peer, ok := svr.PickPeer(key)
+	if !ok || peer != svr.clients[ring[0]] {
+		t.Fatalf("writes should still pick the owner")
+	}
+	if err := peer.Set(context.Background(), "g", key, []byte("v"), time.Time{}); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("Set to an open owner = %v, want ErrBreakerOpen", err)
+	}
+	//  对冲请求发给再下一个节点，它是本节点时不对冲
+	if _, ok := svr.PickReplica(key); ok != (ring[2] != self) {
+		t.Fatalf("unexpected replica for ring %v", ring)
+	}
+	states := map[string]BreakerState{}
+	for _, br := range svr.Breakers() {
+		states[br.Peer] = br.State
+	}
+	if len(states) != 2 || states[ring[0]] != BreakerOpen {
+		t.Fatalf("unexpected breaker states %v", states)
+	}
+
+	//  下一个节点也打开时继续向后选择，轮到本节点时由调用方按回退方式处理
+	svr.clients[ring[1]].breaker.done(errUnavailable, 0)
+	if peer, ok, diverted := svr.PickReadPeer(key); !diverted || ring[2] == self && ok || ring[2] != self && peer != svr.clients[ring[2]] {
+		t.Fatalf("should pick %s when both replicas are open", ring[2])
+	}
+}
+
+// divertPeers 模拟所属节点的熔断器打开，读取交给了peer，remote为false表示交给了本节点
+type divertPeers struct {
+	fakePeers
+	remote bool
+}
+
+func (p divertPeers) PickReadPeer(key string) (Fetcher, bool, bool) {
+	return p.fetcher, p.remote, true
+}
+
+// 测试读取绕开所属节点时以对冲请求的方式读取，交给本节点时按回退方式处理，加载的值都不写入缓存
+func TestDivertedRead(t *testing.T) {
+	var loads AtomicInt
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("local-" + key), nil
+	})
+	var hedges AtomicInt
+	replica := replicaStub{hedges: &hedges}
+
+	g := NewGroup("divert-remote", 2<<10, getter, WithOwnerLoad(FallbackFail, 0))
+	g.RegisterPeers(divertPeers{fakePeers: fakePeers{fetcher: replica}, remote: true})
+	if v, err := g.Get("k", time.Time{}); err != nil || v.String() != "replica-k" || hedges.Get() != 1 {
+		t.Fatalf("diverted remote read = %q, %v, %d replica requests", v.String(), err, hedges.Get())
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatalf("diverted read should not be cached")
+	}
+
+	g = NewGroup("divert-fail", 2<<10, getter, WithOwnerLoad(FallbackFail, 0))
+	g.RegisterPeers(divertPeers{fakePeers: fakePeers{fetcher: replica}})
+	if _, err := g.Get("k", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) || loads.Get() != 0 {
+		t.Fatalf("diverted to self with FallbackFail = %v, %d loads", err, loads.Get())
+	}
+
+	g = NewGroup("divert-local", 2<<10, getter)
+	g.RegisterPeers(divertPeers{fakePeers: fakePeers{fetcher: replica}})
+	if v, err := g.Get("k", time.Time{}); err != nil || v.String() != "local-k" || loads.Get() != 1 {
+		t.Fatalf("diverted to self with FallbackLocalLoad = %q, %v", v.String(), err)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatalf("local load for another owner should not be cached")
+	}
+}
+
+// 测试节点停止之后熔断器打开，之后的请求不再访问网络
+func TestBreakerOpensOnUnavailablePeer(t *testing.T) {
+	NewGroup("breaker-peer", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+		return []byte("v-" + key), nil
+	}))
+	svr, _ := startServer(t)
+	c := newPeerClient(svr.addr, nil, nil)
+	c.breaker = newBreaker(BreakerConfig{Window: time.Minute, MinRequests: 4, ErrorRate: 0.5, OpenTimeout: time.Minute})
+	defer c.Close()
+
+	if _, err := c.Fetch(context.Background(), "breaker-peer", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch(context.Background(), "breaker-peer", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Fetch missing = %v", err)
+	}
+	svr.Stop()
+	for i := 0; i < 10 && c.breaker.State() == BreakerClosed; i++ {
+		c.Fetch(context.Background(), "breaker-peer", "k")
+	}
+	if c.breaker.State() != BreakerOpen {
+		t.Fatalf("breaker should open after the peer stopped")
+	}
+	start := time.Now()
+	if _, err := c.Fetch(context.Background(), "breaker-peer", "k"); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("Fetch with open breaker = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("open breaker should fail fast, took %v", elapsed)
+	}
+}
@@ -13,8 +13,12 @@ byteview是对实际缓存的⼀层封装，因为实际的缓存值是⼀个byt
 // ByteView 只读数据结构，使用ByteSlice返回一个拷贝
 // 防止缓存值被外部程序修改
 type ByteView struct {
-	b []byte //  b会存储真实的缓存值
-	t time.Time
+	b   []byte //  b会存储真实的缓存值
+	t   time.Time
+	ver uint64 //  版本号，每次写入所属节点的缓存时递增，0表示没有版本
+	gen uint64 //  写入时Group的代，与当前的代不同时视为不存在
+	//  所属节点要求请求方保存副本的时长，只出现在Fetch的结果中
+	replicate time.Duration
 	// expire time.Time//  过期时间
 	// 支持多种数据结构的数据类型的存储，比如字符串、图片等
 }
@@ -24,6 +28,11 @@ func (v ByteView) Expire() time.Time {
 	return v.t
 }
 
+// Version 返回写入缓存时分配的版本号，用于CompareAndSet
+func (v ByteView) Version() uint64 {
+	return v.ver
+}
+
 // Len return the view‘s length
 func (v ByteView) Len() int {
 	return len(v.b)
@@ -1,7 +1,10 @@
 package DistributedCache
 
 import (
-	"GeeCache/lru"
+	"DistributedCache/lru"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,17 +17,274 @@ type cache struct {
 	mu         sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64
+	version    uint64 //  最近一次写入的版本号
+	gen        uint64 //  当前的代，Flush时递增，之前的代写入的条目视为不存在
+
+	//  标签索引，与lru中的条目保持一致，条目被淘汰或删除时通过onEvicted清理
+	tags    map[string]map[string]struct{} //  tag -> keys
+	keyTags map[string][]string            //  key -> tags
+
+	//  条目被移除时调用，之前的代的条目除外，调用时持有mu，可以为nil
+	notify func(key string, reason lru.EvictReason)
 }
 
 // 新增缓存，加锁支持并发安全
 func (c *cache) add(key string, value ByteView, expir time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	// 延迟加载
+	c.addLocked(key, lru.Item{Value: value, Expire: expir}, nil)
+}
+
+// cacheItem 是mainCache中的条目及其元数据
+type cacheItem struct {
+	value ByteView
+	stale bool          //  已经软过期
+	age   time.Duration //  软过期之后经过的时间，与stale使用同一个时钟
+	left  time.Duration //  距离软过期的时间，没有过期时间或已经软过期时为0
+	cost  time.Duration //  加载耗时
+}
+
+// 新增缓存，可以指定硬过期时间、加载耗时和标签，返回带有新版本号的值
+func (c *cache) addItem(key string, it lru.Item, tags []string) ByteView {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addLocked(key, it, tags)
+}
+
+// addItemIf 在持有锁的情况下调用cond，返回true时才写入
+// 用于加载的值：检查填充租约与写入不会和之后的写入交错
+func (c *cache) addItemIf(key string, it lru.Item, tags []string, cond func() bool) (ByteView, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !cond() {
+		return ByteView{}, false
+	}
+	return c.addLocked(key, it, tags), true
+}
+
+// compareAndAdd 只有key当前的版本号等于expected时才写入，不存在的key版本号为0
+// 返回写入后的值，失败时返回当前的版本号；written不为nil时在写入成功后、释放锁之前调用
+func (c *cache) compareAndAdd(key string, expected uint64, it lru.Item, tags []string, written func()) (ByteView, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var current uint64
+	if old, ok := c.lookupLocked(key); ok {
+		current = old.Value.(ByteView).Version()
+	}
+	if current != expected {
+		return ByteView{}, current, false
+	}
+	v := c.addLocked(key, it, tags)
+	if written != nil {
+		written()
+	}
+	return v, current, true
+}
+
+// incr 把key的整数值加上delta，保留原条目的过期时间
+// key不存在时以initial为初始条目，initial为nil时found为false
+// written不为nil时在写入成功后、释放锁之前调用
+func (c *cache) incr(key string, delta int64, initial *lru.Item, written func()) (n int64, found bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//  已过期或者属于之前的代的计数器重新开始计数
+	it, found := c.lookupLocked(key)
+	found = found && !it.Stale(c.lru.Now())
+	tags := c.keyTags[key]
+	if !found {
+		if initial == nil {
+			return 0, false, nil
+		}
+		it, tags = *initial, nil
+	}
+	v := it.Value.(ByteView)
+	if n, err = addInt64(v.b, delta); err != nil {
+		return 0, true, err
+	}
+	v.b = strconv.AppendInt(nil, n, 10)
+	it.Value = v
+	c.addLocked(key, it, tags)
+	if written != nil {
+		written()
+	}
+	return n, true, nil
+}
+
+// addInt64 把十进制整数b加上delta
+func addInt64(b []byte, delta int64) (int64, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+		return 0, ErrIncrOverflow
+	}
+	return n + delta, nil
+}
+
+// addLocked 为值分配新的版本号并写入，tags替换key原有的标签，调用方持有c.mu
+func (c *cache) addLocked(key string, it lru.Item, tags []string) ByteView {
+	c.initLocked()
+	c.version++
+	v := it.Value.(ByteView)
+	v.ver = c.version
+	v.gen = c.gen
+	it.Value = v
+	//  先更新索引，条目太大被立即淘汰时onEvicted会把它清理掉
+	c.untag(key)
+	c.tag(key, tags)
+	c.lru.AddItem(key, it)
+	return v
+}
+
+// initLocked 延迟创建lru，调用方持有c.mu
+func (c *cache) initLocked() {
+	if c.lru == nil {
+		c.lru = lru.NewWithReason(c.cacheBytes, c.evicted)
+		//  以当前时间为起点，节点重启之后版本号仍然递增
+		c.version = uint64(time.Now().UnixNano())
+	}
+}
+
+// evicted 是lru的onEvicted回调，调用时已经持有c.mu
+func (c *cache) evicted(key string, value lru.Value, reason lru.EvictReason) {
+	c.untag(key)
+	//  之前的代的条目在Flush时已经失效，清理它们不是新的事件
+	if c.notify != nil && value.(ByteView).gen == c.gen {
+		c.notify(key, reason)
+	}
+}
+
+// tag 把key加入标签索引
+func (c *cache) tag(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+		c.keyTags = make(map[string][]string)
+	}
+	c.keyTags[key] = append([]string(nil), tags...)
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untag 把key从标签索引中移除
+func (c *cache) untag(key string) {
+	for _, tag := range c.keyTags[key] {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+// removeTag 删除带有tag标签的所有key，返回删除的key
+// removed不为nil时在持有缓存锁的情况下对每个删除的key调用
+func (c *cache) removeTag(tag string, removed func(key string)) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		c.lru.Remove(key)
+		if removed != nil {
+			removed(key)
+		}
+	}
+	return keys
+}
+
+// removePrefix 删除以prefix开头的所有key，返回删除的数量
+func (c *cache) removePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	n := 0
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.lru.Remove(key)
+			n++
+		}
+	}
+	return n
+}
+
+// lookupLocked 读取key对应的条目，之前的代写入的条目被删除并视为不存在，调用方持有c.mu
+func (c *cache) lookupLocked(key string) (lru.Item, bool) {
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+		return lru.Item{}, false
+	}
+	it, ok := c.lru.GetItem(key)
+	if ok && it.Value.(ByteView).gen != c.gen {
+		c.lru.Remove(key)
+		return lru.Item{}, false
 	}
-	c.lru.Add(key, value, expir)
+	return it, ok
+}
+
+// flush 进入新的代，之前的条目立即失效，之后随读取或淘汰逐渐清理
+// gen不小于当前的代时使用gen，否则在当前的代上加一，返回新的代
+func (c *cache) flush(gen uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen <= c.gen {
+		gen = c.gen + 1
+	}
+	c.gen = gen
+	return gen
+}
+
+// advance 进入快照记录的代gen，gen不大于当前的代时不变
+// 之前的代中的条目早于快照之前的Flush，随之变为不存在
+func (c *cache) advance(gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen > c.gen {
+		c.gen = gen
+	}
+}
+
+// generation 返回当前的代
+func (c *cache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// 获取缓存，包括已经软过期的陈旧条目
+func (c *cache) getItem(key string) (item cacheItem, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it, ok := c.lookupLocked(key); ok {
+		now := c.lru.Now()
+		item = cacheItem{value: withExpire(it), stale: it.Stale(now), cost: it.Cost}
+		if item.stale {
+			item.age = now.Sub(it.Expire)
+		} else if !it.Expire.IsZero() {
+			item.left = it.Expire.Sub(now)
+		}
+		return item, true
+	}
+	return
+}
+
+// withExpire 滑动过期会修改条目的过期时间，返回的ByteView以条目为准
+func withExpire(it lru.Item) ByteView {
+	v := it.Value.(ByteView)
+	v.t = it.Expire
+	return v
 }
 
 // 获取缓存
@@ -32,12 +292,121 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if it, ok := c.lookupLocked(key); ok && !it.Stale(c.lru.Now()) {
+		return withExpire(it), ok
+	}
+	return
+}
+
+// 删除缓存
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.lru == nil {
 		return
 	}
+	c.lru.Remove(key)
+}
+
+// 列出所有缓存的key
+func (c *cache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	keys := c.lru.Keys()
+	n := 0
+	for _, key := range keys {
+		//  之前的代的条目不再可见
+		if it, ok := c.lru.Peek(key); ok && it.Value.(ByteView).gen == c.gen {
+			keys[n] = key
+			n++
+		}
+	}
+	return keys[:n]
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok //  类型断言
+// snapshotEntry 是快照中的一个条目
+type snapshotEntry struct {
+	key  string
+	it   lru.Item
+	tags []string
+}
+
+// snapshot 按从新到旧的顺序返回当前的代中没有硬过期的条目，以及当前的代
+// 值是只读的，这里只复制引用，写入快照时不需要持有锁
+func (c *cache) snapshot() ([]snapshotEntry, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil, c.gen
 	}
-	return
+	now := c.lru.Now()
+	keys := c.lru.Keys()
+	entries := make([]snapshotEntry, 0, len(keys))
+	for _, key := range keys {
+		it, ok := c.lru.Peek(key)
+		if !ok || it.Value.(ByteView).gen != c.gen || hardExpired(it, now) {
+			continue
+		}
+		entries = append(entries, snapshotEntry{key: key, it: it, tags: c.keyTags[key]})
+	}
+	return entries, c.gen
+}
+
+// entry 返回key在当前的代中的条目和标签，不影响LRU顺序
+func (c *cache) entry(key string) (snapshotEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return snapshotEntry{}, false
+	}
+	it, ok := c.lru.Peek(key)
+	if !ok || it.Value.(ByteView).gen != c.gen {
+		return snapshotEntry{}, false
+	}
+	return snapshotEntry{key: key, it: it, tags: c.keyTags[key]}, true
+}
+
+// restore 把快照中的条目插入LRU的队尾，它比缓存中已有的条目都要旧
+// key已经存在时保留现有的值，超过cacheBytes时条目被立即淘汰，这两种情况返回false
+func (c *cache) restore(e snapshotEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.initLocked()
+	if it, ok := c.lru.Peek(e.key); ok {
+		if it.Value.(ByteView).gen == c.gen {
+			return false
+		}
+		c.lru.Remove(e.key)
+	}
+	c.version++
+	v := e.it.Value.(ByteView)
+	v.ver, v.gen = c.version, c.gen
+	e.it.Value = v
+	c.untag(e.key)
+	c.tag(e.key, e.tags)
+	c.lru.AddItemBack(e.key, e.it)
+	_, ok := c.lru.Peek(e.key)
+	return ok
+}
+
+// hardExpired 条目在now时刻是否已经硬过期
+func hardExpired(it lru.Item, now time.Time) bool {
+	hard := it.StaleUntil
+	if hard.IsZero() {
+		hard = it.Expire
+	}
+	return !hard.IsZero() && hard.Before(now)
+}
+
+// 获取缓存的容量信息
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return CacheStats{}
+	}
+	return CacheStats{Bytes: c.lru.Bytes(), Items: int64(c.lru.Len())}
 }
@@ -0,0 +1,121 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+版本号用于乐观并发控制：
+所属节点每次写入缓存都会为值分配一个递增的版本号，读取方先用GetWithVersion拿到值和版本号，
+修改之后用CompareAndSet写回，如果期间有其它写入，版本号不一致，写入失败，读取方重新读取再试
+*/
+
+// ErrVersionConflict 当前的版本号与期望的不一致
+var ErrVersionConflict = errors.New("geecache: version conflict")
+
+// VersionConflictError 是CompareAndSet因版本号不一致失败时返回的错误
+// errors.Is(err, ErrVersionConflict) 为true
+type VersionConflictError struct {
+	Key      string
+	Expected uint64
+	Current  uint64 //  key当前的版本号，0表示key不存在
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("geecache: version conflict on %s: expected %d, current %d", e.Key, e.Expected, e.Current)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// GetWithVersion 与GetContext相同，同时返回值的版本号
+func (g *Group) GetWithVersion(ctx context.Context, key string) (ByteView, uint64, error) {
+	v, err := g.GetContext(ctx, key, time.Time{})
+	if err != nil {
+		return ByteView{}, 0, err
+	}
+	return v, v.Version(), nil
+}
+
+// Peek 只读取key所属节点的缓存，未命中时返回ErrNotFound
+// 与GetContext不同，它不调用Getter加载，也不计入命中统计，用于判断key是否存在
+// 不支持peek的Fetcher退化为Fetch
+func (g *Group) Peek(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			if p, ok := peer.(peekFetcher); ok {
+				return p.peek(ctx, g.name, key)
+			}
+			return peer.Fetch(ctx, g.name, key)
+		}
+	}
+	return g.peekLocally(key)
+}
+
+// peekLocally 读取本节点的缓存，不加载
+func (g *Group) peekLocally(key string) (ByteView, error) {
+	if v, ok := g.mainCache.get(key); ok {
+		return v, nil
+	}
+	return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+}
+
+// peekFetcher 是可以只读取所属节点缓存的Fetcher，client实现了它
+type peekFetcher interface {
+	peek(ctx context.Context, group string, key string) (ByteView, error)
+}
+
+// CompareAndSet 只有key当前的版本号等于expectedVersion时才写入，由key所属的节点完成
+// expectedVersion为0表示只在key不存在时写入，ttl为0表示永不过期
+// 成功时返回写入后的版本号，版本号不一致时返回 *VersionConflictError
+func (g *Group) CompareAndSet(ctx context.Context, key string, value []byte, expectedVersion uint64, ttl time.Duration) (uint64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+	var expir time.Time
+	if ttl > 0 {
+		expir = time.Now().Add(ttl)
+	}
+	g.dropCopies(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return peer.CompareAndSet(ctx, g.name, key, value, expir, expectedVersion)
+		}
+	}
+	return g.compareAndSetLocally(key, value, expir, expectedVersion)
+}
+
+// compareAndSetLocally 在本节点比较版本号并写入
+func (g *Group) compareAndSetLocally(key string, value []byte, expir time.Time, expected uint64) (uint64, error) {
+	it := g.newItem(ByteView{b: cloneBytes(value), t: expir}, expir, 0)
+	var (
+		v       ByteView
+		current uint64
+		ok      bool
+	)
+	err := g.logKey(key, func() bool {
+		//  与setLocally相同，写入之前发起的加载不能再覆盖它
+		//  只在写入成功时作废，版本号不一致的CompareAndSet不影响租约和进行中的加载
+		v, current, ok = g.mainCache.compareAndAdd(key, expected, it, nil, func() {
+			g.leases.invalidate(key)
+		})
+		return ok
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, &VersionConflictError{Key: key, Expected: expected, Current: current}
+	}
+	g.loader.Forget(key)
+	g.publish(EventSet, key, v.Version())
+	g.dropReplicas(key)
+	return v.Version(), nil
+}
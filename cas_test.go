@@ -0,0 +1,111 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	g := NewGroup("cas", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	ctx := context.Background()
+
+	v1, err := g.CompareAndSet(ctx, "key", []byte("a"), 0, 0)
+	if err != nil || v1 == 0 {
+		t.Fatalf("create: %d, %v", v1, err)
+	}
+	//  版本号为0表示只在key不存在时写入
+	_, err = g.CompareAndSet(ctx, "key", []byte("b"), 0, 0)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrVersionConflict) || conflict.Current != v1 {
+		t.Fatalf("expected conflict with current %d, got %v", v1, err)
+	}
+
+	view, ver, err := g.GetWithVersion(ctx, "key")
+	if err != nil || view.String() != "a" || ver != v1 {
+		t.Fatalf("GetWithVersion = %q, %d, %v", view.String(), ver, err)
+	}
+	v2, err := g.CompareAndSet(ctx, "key", []byte("b"), ver, time.Minute)
+	if err != nil || v2 <= v1 {
+		t.Fatalf("update: %d, %v", v2, err)
+	}
+	if _, err := g.CompareAndSet(ctx, "key", []byte("c"), v1, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale version should conflict, got %v", err)
+	}
+	if view, ver, _ := g.GetWithVersion(ctx, "key"); view.String() != "b" || ver != v2 || view.Expire().IsZero() {
+		t.Fatalf("unexpected value %q, version %d, expire %v", view.String(), ver, view.Expire())
+	}
+
+	//  Set和加载的值同样带有递增的版本号
+	g.Set(ctx, "key", []byte("d"), time.Time{})
+	if _, ver, _ := g.GetWithVersion(ctx, "key"); ver <= v2 {
+		t.Fatalf("Set should bump version, got %d", ver)
+	}
+	if _, ver, _ := g.GetWithVersion(ctx, "loaded"); ver == 0 {
+		t.Fatalf("loaded value should have a version")
+	}
+}
+
+// 测试版本号不一致的CompareAndSet不会让租约失效
+func TestCompareAndSetConflictKeepsLease(t *testing.T) {
+	g := NewGroup("cas-lease", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+	l, err := g.LeaseGet(ctx, "key")
+	if err != nil || l.Token == 0 {
+		t.Fatalf("expected lease, got %+v, %v", l, err)
+	}
+	if _, err := g.CompareAndSet(ctx, "key", []byte("cas"), 42, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if err := g.SetWithLease(ctx, "key", []byte("v"), time.Time{}, l.Token); err != nil {
+		t.Fatalf("failed CompareAndSet should not invalidate the lease, got %v", err)
+	}
+
+	//  成功的CompareAndSet是一次写入，租约随之失效
+	l, _ = g.LeaseGet(ctx, "other")
+	if _, err := g.CompareAndSet(ctx, "other", []byte("cas"), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetWithLease(ctx, "other", []byte("v"), time.Time{}, l.Token); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expected ErrLeaseInvalid, got %v", err)
+	}
+}
+
+// 测试版本号和冲突经过gRPC在远程节点上比较
+func TestCompareAndSetRemote(t *testing.T) {
+	NewGroup("cas-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	view, err := c.Fetch(ctx, "cas-remote", "key")
+	if err != nil || view.Version() == 0 {
+		t.Fatalf("fetch should return version: %d, %v", view.Version(), err)
+	}
+	ver, err := c.CompareAndSet(ctx, "cas-remote", "key", []byte("new"), time.Time{}, view.Version())
+	if err != nil || ver <= view.Version() {
+		t.Fatalf("CompareAndSet = %d, %v", ver, err)
+	}
+	_, err = c.CompareAndSet(ctx, "cas-remote", "key", []byte("lost"), time.Time{}, view.Version())
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Current != ver {
+		t.Fatalf("expected conflict with current %d, got %v", ver, err)
+	}
+
+	//  peek只读取所属节点的缓存，不加载
+	if view, err := c.peek(ctx, "cas-remote", "key"); err != nil || view.String() != "new" || view.Version() != ver {
+		t.Fatalf("peek = %q, %d, %v", view.String(), view.Version(), err)
+	}
+	if _, err := c.peek(ctx, "cas-remote", "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("peek of an uncached key should not load, got %v", err)
+	}
+}
@@ -6,6 +6,11 @@ import (
 	"context"
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sync"
 	"time"
 )
 
@@ -13,35 +18,450 @@ import (
 
 type client struct {
 	name string //  服务名称 pcache/ip:addr
+	addr string //  远程节点地址 ip:port，不使用etcd时直连该地址
+
+	etcdConfig *clientv3.Config // 为nil时不经过etcd发现服务
+	dialOpts   []grpc.DialOption
+	breaker    *breaker //  为nil时不熔断
+
+	//  连接在第一次请求时建立，之后复用
+	mu      sync.Mutex
+	etcdCli *clientv3.Client
+	conn    *grpc.ClientConn
 }
 
 // Fetch  从remote peer获取对应缓存值
-func (c *client) Fetch(group string, key string) ([]byte, error) {
-	//  创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
-	if err != nil {
-		return nil, err
-	}
-	defer cli.Close()
+func (c *client) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	return c.fetchWithHits(ctx, group, key, 0)
+}
+
+// fetchWithHits 与Fetch相同，同时报告在本地副本上读取的次数
+func (c *client) fetchWithHits(ctx context.Context, group string, key string, hits int64) (ByteView, error) {
+	return c.get(ctx, "client.Fetch", &pb.Request{Group: group, Key: key, Hits: hits})
+}
+
+// fetchReplica 向作为后备节点的remote peer发送对冲请求，它不再转发给所属节点
+func (c *client) fetchReplica(ctx context.Context, group string, key string) (ByteView, error) {
+	return c.get(ctx, "client.FetchReplica", &pb.Request{Group: group, Key: key, Replica: true})
+}
+
+// peek 只读取remote peer上的缓存，未命中时不加载
+func (c *client) peek(ctx context.Context, group string, key string) (ByteView, error) {
+	return c.get(ctx, "client.Peek", &pb.Request{Group: group, Key: key, Peek: true})
+}
+
+func (c *client) get(ctx context.Context, name string, req *pb.Request) (value ByteView, err error) {
+	ctx, span := startSpan(ctx, name)
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 	//  发现服务  取得与服务的连接
-	conn, err := registry.EtcdDial(cli, c.name)
+	conn, err := c.dial(ctx)
 	if err != nil {
-		return nil, err
+		return ByteView{}, err
 	}
-	defer conn.Close()
 	grpcClient := pb.NewGroupCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	//  通过gRPC metadata把追踪上下文传给远程节点
+	resp, err := grpcClient.Get(injectTrace(ctx), req)
+	group, key := req.GetGroup(), req.GetKey()
+	if status.Code(err) == codes.NotFound {
+		return ByteView{}, fmt.Errorf("%s/%s: %w", group, key, ErrNotFound)
+	}
+	if err != nil {
+		//  保留gRPC状态码，重试时据此判断错误是否是暂时的
+		return ByteView{}, fmt.Errorf("could not get %s/%s from peer %s: %w", group, key, c.name, err)
+	}
+	value = ByteView{b: resp.GetValue(), t: fromUnixNano(resp.GetExpire()), ver: resp.GetVersion()}
+	if resp.GetHot() {
+		value.replicate = time.Duration(resp.GetHotTtl())
+	}
+	return value, nil
+}
+
+// Set 在remote peer上写入缓存
+func (c *client) Set(ctx context.Context, group string, key string, value []byte, expir time.Time, tags ...string) (err error) {
+	ctx, span := startSpan(ctx, "client.Set")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pb.NewGroupCacheClient(conn).Put(injectTrace(ctx), &pb.Request{
+		Group:  group,
+		Key:    key,
+		Value:  value,
+		Expire: toUnixNano(expir),
+		Tags:   tags,
+	})
+	if err != nil {
+		return fmt.Errorf("could not set %s/%s on peer %s: %w", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Delete 在remote peer上删除缓存
+func (c *client) Delete(ctx context.Context, group string, key string) (err error) {
+	ctx, span := startSpan(ctx, "client.Delete")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pb.NewGroupCacheClient(conn).Delete(injectTrace(ctx), &pb.Request{
+		Group: group,
+		Key:   key,
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete %s/%s on peer %s: %w", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Lease 在remote peer上读取缓存或获取租约
+func (c *client) Lease(ctx context.Context, group string, key string) (lease Lease, err error) {
+	ctx, span := startSpan(ctx, "client.Lease")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Lease{}, err
+	}
+	resp, err := pb.NewGroupCacheClient(conn).Lease(injectTrace(ctx), &pb.Request{
+		Group: group,
+		Key:   key,
+	})
+	if status.Code(err) == codes.Aborted {
+		return Lease{}, fmt.Errorf("%s/%s: %w", group, key, ErrLeaseWait)
+	}
+	if err != nil {
+		return Lease{}, fmt.Errorf("could not lease %s/%s from peer %s: %w", group, key, c.name, err)
+	}
+	lease = Lease{Token: resp.GetLease(), Stale: resp.GetStale()}
+	if lease.Token == 0 {
+		lease.Value = ByteView{b: resp.GetValue(), t: fromUnixNano(resp.GetExpire()), ver: resp.GetVersion()}
+		lease.Hit = !lease.Stale
+	}
+	return lease, nil
+}
+
+// SetWithLease 在remote peer上带租约写入缓存
+func (c *client) SetWithLease(ctx context.Context, group string, key string, value []byte, expir time.Time, token uint64) (err error) {
+	ctx, span := startSpan(ctx, "client.SetWithLease")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pb.NewGroupCacheClient(conn).Put(injectTrace(ctx), &pb.Request{
+		Group:  group,
+		Key:    key,
+		Value:  value,
+		Expire: toUnixNano(expir),
+		Lease:  token,
+	})
+	if status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%s/%s: %w", group, key, ErrLeaseInvalid)
+	}
+	if err != nil {
+		return fmt.Errorf("could not set %s/%s on peer %s: %w", group, key, c.name, err)
+	}
+	return nil
+}
+
+// CompareAndSet 在remote peer上比较版本号并写入
+func (c *client) CompareAndSet(ctx context.Context, group string, key string, value []byte, expir time.Time, expected uint64) (version uint64, err error) {
+	ctx, span := startSpan(ctx, "client.CompareAndSet")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := pb.NewGroupCacheClient(conn).CompareAndSet(injectTrace(ctx), &pb.Request{
+		Group:   group,
+		Key:     key,
+		Value:   value,
+		Expire:  toUnixNano(expir),
+		Version: expected,
+	})
+	if st := status.Convert(err); st.Code() == codes.Aborted {
+		conflict := &VersionConflictError{Key: key, Expected: expected}
+		for _, d := range st.Details() {
+			if v, ok := d.(*wrapperspb.UInt64Value); ok {
+				conflict.Current = v.GetValue()
+			}
+		}
+		return 0, conflict
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not compare and set %s/%s on peer %s: %w", group, key, c.name, err)
+	}
+	return resp.GetVersion(), nil
+}
+
+// Incr 在remote peer上累加计数器
+func (c *client) Incr(ctx context.Context, group string, key string, delta int64, expir time.Time) (n int64, err error) {
+	ctx, span := startSpan(ctx, "client.Incr")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := pb.NewGroupCacheClient(conn).Incr(injectTrace(ctx), &pb.Request{
+		Group:  group,
+		Key:    key,
+		Delta:  delta,
+		Expire: toUnixNano(expir),
+	})
+	switch status.Code(err) {
+	case codes.OK:
+		return resp.GetCount(), nil
+	case codes.FailedPrecondition:
+		return 0, fmt.Errorf("%s/%s: %w", group, key, ErrNotInteger)
+	case codes.OutOfRange:
+		return 0, fmt.Errorf("%s/%s: %w", group, key, ErrIncrOverflow)
+	}
+	return 0, fmt.Errorf("could not incr %s/%s on peer %s: %w", group, key, c.name, err)
+}
+
+// Invalidate 在remote peer上按标签或前缀删除缓存
+func (c *client) Invalidate(ctx context.Context, group string, tag string, prefix string) (n int64, err error) {
+	ctx, span := startSpan(ctx, "client.Invalidate")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := pb.NewGroupCacheClient(conn).Invalidate(injectTrace(ctx), &pb.InvalidateRequest{
+		Group:  group,
+		Tag:    tag,
+		Prefix: prefix,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not invalidate %s on peer %s: %w", group, c.name, err)
+	}
+	return resp.GetRemoved(), nil
+}
+
+// DropCopies 删除remote peer上key的热点副本
+func (c *client) DropCopies(ctx context.Context, group string, key string) (err error) {
+	ctx, span := startSpan(ctx, "client.DropCopies")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	resp, err := grpcClient.Get(ctx, &pb.Request{
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = pb.NewGroupCacheClient(conn).DropCopies(injectTrace(ctx), &pb.Request{
 		Group: group,
 		Key:   key,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not get %s/%s from peer %s", group, key, c.name)
+		return fmt.Errorf("could not drop copies of %s/%s on peer %s: %w", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Flush 让remote peer上的Group进入不小于gen的新的代
+func (c *client) Flush(ctx context.Context, group string, gen uint64) (_ uint64, err error) {
+	ctx, span := startSpan(ctx, "client.Flush")
+	span.SetAttribute("peer", c.name)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := pb.NewGroupCacheClient(conn).Flush(injectTrace(ctx), &pb.FlushRequest{
+		Group:      group,
+		Generation: gen,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not flush %s on peer %s: %w", group, c.name, err)
+	}
+	return resp.GetGeneration(), nil
+}
+
+// Watch 订阅remote peer上Group的键空间事件，每收到一个事件调用一次fn，
+// 直到ctx取消、连接出错或者fn返回错误，dropped是这个事件之前被节点丢弃的事件数量
+// fn执行得慢会通过gRPC流控反压到节点，节点的缓冲区满了之后开始丢弃事件
+func (c *client) Watch(ctx context.Context, group string, filter EventFilter, fn func(e Event, dropped int64) error) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	req := &pb.WatchRequest{Group: group, Prefix: filter.Prefix}
+	for _, t := range filter.Types {
+		req.Types = append(req.Types, t.String())
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := pb.NewGroupCacheClient(conn).Watch(injectTrace(ctx), req)
+	if err != nil {
+		return fmt.Errorf("could not watch %s on peer %s: %w", group, c.name, err)
+	}
+	for {
+		in, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("watch %s on peer %s: %w", group, c.name, err)
+		}
+		typ, ok := ParseEventType(in.GetType())
+		if !ok {
+			//  更新的节点可能发送新的事件类型
+			continue
+		}
+		e := Event{
+			Type:    typ,
+			Group:   group,
+			Key:     in.GetKey(),
+			Version: in.GetVersion(),
+			Time:    time.Unix(0, in.GetTime()),
+		}
+		if err := fn(e, in.GetDropped()); err != nil {
+			return err
+		}
+	}
+}
+
+// dial 返回与远程节点的连接，必要时新建
+// 建立连接时不持有c.mu，一个节点连接缓慢时其它请求不会在锁上排队
+func (c *client) dial(ctx context.Context) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	//  熔断器打开时不再等待连接超时
+	if !c.breaker.ready() {
+		return nil, ErrBreakerOpen
+	}
+	//  连接成功之后的RPC经过拦截器统计，连接失败也算一次失败的请求
+	start := time.Now()
+	conn, cli, err := c.connect(ctx)
+	if err != nil {
+		c.breaker.done(err, time.Since(start))
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		//  并发的请求已经建立了连接，使用它并关闭这次建立的连接
+		conn.Close()
+		if cli != nil {
+			cli.Close()
+		}
+		return c.conn, nil
+	}
+	c.etcdCli, c.conn = cli, conn
+	return conn, nil
+}
+
+// connect 新建与远程节点的连接，经过etcd发现服务时同时返回etcd client
+func (c *client) connect(ctx context.Context) (*grpc.ClientConn, *clientv3.Client, error) {
+	dialOpts := c.dialOpts
+	if c.breaker != nil {
+		dialOpts = append(dialOpts[:len(dialOpts):len(dialOpts)], grpc.WithChainUnaryInterceptor(c.breaker.unaryInterceptor))
+	}
+	if c.etcdConfig == nil {
+		opts := append([]grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}, dialOpts...)
+		conn, err := grpc.DialContext(ctx, c.addr, opts...)
+		return conn, nil, err
+	}
+	//  创建一个etcd client
+	cli, err := clientv3.New(*c.etcdConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := registry.EtcdDialContext(ctx, cli, c.name, dialOpts...)
+	if err != nil {
+		cli.Close()
+		return nil, nil, err
+	}
+	return conn, cli, nil
+}
+
+// Close 关闭与远程节点的连接
+func (c *client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.etcdCli != nil {
+		c.etcdCli.Close()
+		c.etcdCli = nil
 	}
-	return resp.GetValue(), nil
 }
 
 func NewClient(service string) *client {
-	return &client{name: service}
+	cfg := defaultEtcdConfig
+	return &client{name: service, etcdConfig: &cfg}
+}
+
+// newPeerClient 创建访问addr节点的client，etcdConfig为nil时直连
+func newPeerClient(addr string, etcdConfig *clientv3.Config, dialOpts []grpc.DialOption) *client {
+	return &client{
+		name:       fmt.Sprintf("geecache/%s", addr),
+		addr:       addr,
+		etcdConfig: etcdConfig,
+		dialOpts:   dialOpts,
+	}
 }
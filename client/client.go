@@ -0,0 +1,426 @@
+// Package client 是geecache集群的客户端，供不运行Group、只读写集群的应用使用
+// 通过etcd发现节点，用与节点相同的一致性哈希把请求直接发给key所属的节点，
+// 并在本地保存一个短有效期的近端缓存，订阅各节点的键空间事件使近端缓存及时失效
+package client
+
+import (
+	"DistributedCache/consistenthash"
+	pb "DistributedCache/geecachepb"
+	"DistributedCache/registry"
+	"DistributedCache/singleflight"
+	"context"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultService  = "geecache"
+	defaultReplicas = 50 //  与节点的一致性哈希参数相同
+	defaultTimeout  = 5 * time.Second
+	defaultRefresh  = 10 * time.Second
+	defaultNearSize = 1 << 20
+	defaultNearTTL  = time.Second
+)
+
+var (
+	// ErrNotFound key在集群中不存在
+	ErrNotFound = errors.New("geecache client: key not found")
+	// ErrNoPeers 没有可用的节点
+	ErrNoPeers = errors.New("geecache client: no peers")
+	// ErrClosed 客户端已经关闭
+	ErrClosed = errors.New("geecache client: closed")
+)
+
+// Option 配置Client
+type Option func(*Client)
+
+// WithPeers 使用固定的节点地址，不经过etcd发现
+func WithPeers(addrs ...string) Option {
+	return func(c *Client) {
+		c.static = append([]string{}, addrs...)
+	}
+}
+
+// WithEtcd 从etcd中service下发现节点，并每隔refresh重新读取
+func WithEtcd(config clientv3.Config, service string) Option {
+	return func(c *Client) {
+		c.etcdConfig = &config
+		c.service = service
+	}
+}
+
+// WithReplicas 一致性哈希中每个节点的虚拟节点数量，必须与节点的配置相同
+func WithReplicas(replicas int) Option {
+	return func(c *Client) {
+		c.replicas = replicas
+	}
+}
+
+// WithNearCache 近端缓存最多保存maxBytes字节，每个值最多保存ttl时长，maxBytes或ttl为0时关闭近端缓存
+func WithNearCache(maxBytes int64, ttl time.Duration) Option {
+	return func(c *Client) {
+		c.nearBytes, c.nearTTL = maxBytes, ttl
+	}
+}
+
+// WithTimeout 每次请求的超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRefreshInterval 从etcd重新读取节点列表的间隔
+func WithRefreshInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.refresh = d
+	}
+}
+
+// WithDialOptions 连接节点时额外使用的gRPC选项，例如TLS
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *Client) {
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// Stats 是近端缓存的统计
+type Stats struct {
+	NearHits      int64 `json:"near_hits"`
+	NearMisses    int64 `json:"near_misses"`
+	Invalidations int64 `json:"invalidations"` //  收到的失效事件数量
+	NearItems     int64 `json:"near_items"`
+}
+
+// Client 访问geecache集群，可以被多个goroutine同时使用
+type Client struct {
+	static     []string
+	etcdConfig *clientv3.Config
+	service    string
+	replicas   int
+	timeout    time.Duration
+	refresh    time.Duration
+	nearBytes  int64
+	nearTTL    time.Duration
+	dialOpts   []grpc.DialOption
+
+	etcdCli *clientv3.Client
+	near    *nearCache //  为nil时关闭近端缓存
+	loader  singleflight.Group
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	peers    []string
+	ring     *consistenthash.Map
+	conns    map[string]*grpc.ClientConn
+	groups   map[string]bool //  已经订阅事件的Group
+	watchers map[watchKey]*watcher
+
+	nearHits, nearMisses, invalidations atomic.Int64
+}
+
+// New 创建Client，未设置WithPeers和WithEtcd时从本机的etcd发现节点
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		service:   defaultService,
+		replicas:  defaultReplicas,
+		timeout:   defaultTimeout,
+		refresh:   defaultRefresh,
+		nearBytes: defaultNearSize,
+		nearTTL:   defaultNearTTL,
+		conns:     make(map[string]*grpc.ClientConn),
+		groups:    make(map[string]bool),
+		watchers:  make(map[watchKey]*watcher),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.static == nil && c.etcdConfig == nil {
+		c.etcdConfig = &clientv3.Config{Endpoints: []string{"localhost:2379"}, DialTimeout: c.timeout}
+	}
+	if c.nearBytes > 0 && c.nearTTL > 0 {
+		c.near = newNearCache(c.nearBytes, c.nearTTL)
+	}
+	c.dialOpts = append([]grpc.DialOption{grpc.WithInsecure()}, c.dialOpts...)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	if c.static != nil {
+		c.setPeers(c.static)
+		return c, nil
+	}
+	cli, err := clientv3.New(*c.etcdConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.etcdCli = cli
+	if err := c.refreshPeers(); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	c.wg.Add(1)
+	go c.refreshLoop()
+	return c, nil
+}
+
+// refreshPeers 从etcd读取节点列表
+func (c *Client) refreshPeers() error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	peers, err := registry.ListEndpoints(ctx, c.etcdCli, c.service)
+	if err != nil {
+		return fmt.Errorf("list peers from etcd: %v", err)
+	}
+	c.setPeers(peers)
+	return nil
+}
+
+func (c *Client) refreshLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.refreshPeers(); err != nil {
+				log.Printf("[geecache client] %v", err)
+			}
+		}
+	}
+}
+
+// setPeers 更新节点列表，节点变化时key的归属也会变化，清空近端缓存
+func (c *Client) setPeers(peers []string) {
+	peers = append([]string(nil), peers...)
+	sort.Strings(peers)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || equalStrings(peers, c.peers) {
+		return
+	}
+	current := make(map[string]bool, len(peers))
+	for _, p := range peers {
+		current[p] = true
+	}
+	for k, w := range c.watchers {
+		if !current[k.peer] {
+			w.cancel()
+			delete(c.watchers, k)
+		}
+	}
+	for p, conn := range c.conns {
+		if !current[p] {
+			conn.Close()
+			delete(c.conns, p)
+		}
+	}
+	old := make(map[string]bool, len(c.peers))
+	for _, p := range c.peers {
+		old[p] = true
+	}
+	for _, p := range peers {
+		if old[p] {
+			continue
+		}
+		for group := range c.groups {
+			c.startWatcherLocked(p, group)
+		}
+	}
+	c.peers = peers
+	c.ring = consistenthash.New(c.replicas, nil)
+	c.ring.Add(peers...)
+	if c.near != nil {
+		c.near.clear()
+	}
+}
+
+// connLocked 返回到peer的连接，第一次使用时建立，调用方持有c.mu
+func (c *Client) connLocked(peer string) *grpc.ClientConn {
+	conn, ok := c.conns[peer]
+	if !ok {
+		var err error
+		//  不阻塞的Dial只会在地址格式错误时失败，错误在请求时返回
+		conn, err = grpc.Dial(peer, c.dialOpts...)
+		if err != nil {
+			log.Printf("[geecache client] dial %s: %v", peer, err)
+			return nil
+		}
+		c.conns[peer] = conn
+	}
+	return conn
+}
+
+// pick 返回key所属的节点及其连接
+func (c *Client) pick(key string) (string, pb.GroupCacheClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return "", nil, ErrClosed
+	}
+	if c.ring == nil || len(c.peers) == 0 {
+		return "", nil, ErrNoPeers
+	}
+	peer := c.ring.Get(key)
+	conn := c.connLocked(peer)
+	if conn == nil {
+		return "", nil, fmt.Errorf("could not connect to peer %s", peer)
+	}
+	return peer, pb.NewGroupCacheClient(conn), nil
+}
+
+// Get 读取key，近端缓存中有时直接返回，否则向所属节点读取
+func (c *Client) Get(ctx context.Context, group string, key string) ([]byte, error) {
+	if c.near != nil {
+		if v, ok := c.near.get(group, key); ok {
+			c.nearHits.Add(1)
+			return v, nil
+		}
+		c.nearMisses.Add(1)
+		c.watch(group)
+	}
+	//  同一个key的并发读取只发送一次请求，请求不受某一个调用方的ctx影响
+	v, err, _ := c.loader.DoContext(ctx, nearKey(group, key), func() (interface{}, error) {
+		return c.load(group, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneBytes(v.([]byte)), nil
+}
+
+// load 向所属节点读取key
+// 读取之前所属节点的订阅已经建立时才放入近端缓存，这样读取之后的写入一定会让它失效
+func (c *Client) load(group string, key string) ([]byte, error) {
+	peer, cli, err := c.pick(key)
+	if err != nil {
+		return nil, err
+	}
+	var epoch uint64
+	cacheable := c.near != nil && c.watching(peer, group)
+	if cacheable {
+		epoch = c.near.epoch(group)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	resp, err := cli.Get(ctx, &pb.Request{Group: group, Key: key})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%s/%s: %w", group, key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s/%s from peer %s: %v", group, key, peer, err)
+	}
+	if cacheable {
+		var expire time.Time
+		if resp.GetExpire() != 0 {
+			expire = time.Unix(0, resp.GetExpire())
+		}
+		c.near.add(group, key, resp.GetValue(), expire, epoch)
+	}
+	return resp.GetValue(), nil
+}
+
+// Set 写入key，ttl为0时永不过期
+func (c *Client) Set(ctx context.Context, group string, key string, value []byte, ttl time.Duration) error {
+	peer, cli, err := c.pick(key)
+	if err != nil {
+		return err
+	}
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err = cli.Put(ctx, &pb.Request{Group: group, Key: key, Value: value, Expire: expire})
+	//  无论成功与否都删除近端缓存，失败的写入也可能已经生效
+	c.invalidate(group, key)
+	if err != nil {
+		return fmt.Errorf("could not set %s/%s on peer %s: %v", group, key, peer, err)
+	}
+	return nil
+}
+
+// Delete 删除key
+func (c *Client) Delete(ctx context.Context, group string, key string) error {
+	peer, cli, err := c.pick(key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err = cli.Delete(ctx, &pb.Request{Group: group, Key: key})
+	c.invalidate(group, key)
+	if err != nil {
+		return fmt.Errorf("could not delete %s/%s on peer %s: %v", group, key, peer, err)
+	}
+	return nil
+}
+
+func (c *Client) invalidate(group, key string) {
+	if c.near != nil {
+		c.near.invalidate(group, key)
+	}
+}
+
+// Stats 返回近端缓存的统计
+func (c *Client) Stats() Stats {
+	st := Stats{
+		NearHits:      c.nearHits.Load(),
+		NearMisses:    c.nearMisses.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+	if c.near != nil {
+		st.NearItems = int64(c.near.len())
+	}
+	return st
+}
+
+// Close 停止订阅并关闭所有连接
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.cancel()
+	c.mu.Unlock()
+	c.wg.Wait()
+
+	c.mu.Lock()
+	for p, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, p)
+	}
+	c.mu.Unlock()
+	if c.etcdCli != nil {
+		return c.etcdCli.Close()
+	}
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	geecache "DistributedCache"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// startNode 启动一个不依赖etcd的进程内节点，返回节点地址和节点上的Group
+func startNode(t *testing.T, group string) (string, *geecache.Group, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	svr, err := geecache.NewServer(addr, geecache.WithoutRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Set(addr)
+	g := geecache.NewGroup(group, 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s: %w", key, geecache.ErrNotFound)
+		}
+		return []byte("db-" + key), nil
+	}))
+	g.RegisterPeers(svr)
+	go svr.Start()
+	t.Cleanup(func() {
+		svr.Stop()
+		geecache.DestroyGroup(group)
+	})
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr, g, svr.Stop
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("node %s did not start", addr)
+	return "", nil, nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func mustGet(t *testing.T, c *Client, group, key string) string {
+	t.Helper()
+	v, err := c.Get(context.Background(), group, key)
+	if err != nil {
+		t.Fatalf("Get(%s) = %v", key, err)
+	}
+	return string(v)
+}
+
+// 测试近端缓存命中时不访问节点，节点上的写入通过订阅的事件让近端缓存失效
+func TestNearCacheInvalidation(t *testing.T) {
+	addr, g, _ := startNode(t, "client-near")
+	c, err := New(WithPeers(addr), WithNearCache(1<<10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	//  订阅建立之前读到的值不放入近端缓存
+	mustGet(t, c, "client-near", "k")
+	waitFor(t, func() bool { return c.watching(addr, "client-near") })
+	if v := mustGet(t, c, "client-near", "k"); v != "db-k" {
+		t.Fatalf("Get = %q", v)
+	}
+	served := g.Stats.ServerRequests.Get()
+	for i := 0; i < 5; i++ {
+		mustGet(t, c, "client-near", "k")
+	}
+	if n := g.Stats.ServerRequests.Get(); n != served {
+		t.Fatalf("near cache hits should not reach the node, %d requests", n-served)
+	}
+	if st := c.Stats(); st.NearHits != 5 || st.NearItems != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	//  其他应用直接写入节点
+	if err := g.Set(context.Background(), "k", []byte("new"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return c.Stats().NearItems == 0 })
+	if v := mustGet(t, c, "client-near", "k"); v != "new" {
+		t.Fatalf("stale value %q after write", v)
+	}
+
+	//  通过Client写入时立即失效
+	if err := c.Set(context.Background(), "client-near", "k", []byte("mine"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "client-near", "k"); v != "mine" {
+		t.Fatalf("Get after Set = %q", v)
+	}
+	if err := c.Delete(context.Background(), "client-near", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, c, "client-near", "k"); v != "db-k" {
+		t.Fatalf("Get after Delete = %q", v)
+	}
+
+	//  清空Group时删除所有key
+	mustGet(t, c, "client-near", "other")
+	if _, err := g.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return c.Stats().NearItems == 0 })
+
+	if _, err := c.Get(context.Background(), "client-near", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing key: %v", err)
+	}
+}
+
+// 测试与节点的订阅断开时清空近端缓存
+func TestNearCacheDisconnect(t *testing.T) {
+	addr, _, stop := startNode(t, "client-disconnect")
+	c, err := New(WithPeers(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	mustGet(t, c, "client-disconnect", "k")
+	waitFor(t, func() bool { return c.watching(addr, "client-disconnect") })
+	mustGet(t, c, "client-disconnect", "k")
+	if c.Stats().NearItems != 1 {
+		t.Fatalf("value should be cached")
+	}
+	stop()
+	waitFor(t, func() bool { return c.Stats().NearItems == 0 })
+	if _, err := c.Get(context.Background(), "client-disconnect", "k"); err == nil {
+		t.Fatalf("Get should fail after the node stopped")
+	}
+}
+
+// 测试关闭近端缓存时每次读取都访问节点，key按一致性哈希路由
+func TestNoNearCache(t *testing.T) {
+	addr, g, _ := startNode(t, "client-direct")
+	c, err := New(WithPeers(addr), WithNearCache(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		mustGet(t, c, "client-direct", "k")
+	}
+	if n := g.Stats.ServerRequests.Get(); n != 3 {
+		t.Fatalf("node served %d requests", n)
+	}
+	c.Close()
+	if _, err := c.Get(context.Background(), "client-direct", "k"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get after Close: %v", err)
+	}
+
+	empty, err := New(WithPeers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, err := empty.Get(context.Background(), "client-direct", "k"); !errors.Is(err, ErrNoPeers) {
+		t.Fatalf("Get without peers: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"DistributedCache/lru"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nearCache 是应用本地的LRU缓存，保存最近从集群读取的值，最多保存ttl时长
+// 每次删除都会增加对应Group的epoch，读取之前和之后的epoch不同时说明期间有写入，读到的值不再保存
+type nearCache struct {
+	mu     sync.Mutex
+	lru    *lru.Cache
+	ttl    time.Duration
+	gen    uint64            //  清空整个近端缓存的次数
+	epochs map[string]uint64 //  每个Group删除的次数
+}
+
+// nearValue 实现lru.Value
+type nearValue []byte
+
+func (v nearValue) Len() int {
+	return len(v)
+}
+
+func newNearCache(maxBytes int64, ttl time.Duration) *nearCache {
+	return &nearCache{
+		lru:    lru.New(maxBytes, nil),
+		ttl:    ttl,
+		epochs: make(map[string]uint64),
+	}
+}
+
+// nearKey 组合Group和key，Group的名字中不会出现\x00
+func nearKey(group, key string) string {
+	return group + "\x00" + key
+}
+
+// get 返回值的拷贝
+func (n *nearCache) get(group, key string) ([]byte, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	v, ok := n.lru.Get(nearKey(group, key))
+	if !ok {
+		return nil, false
+	}
+	return cloneBytes(v.(nearValue)), true
+}
+
+// epoch 返回Group当前的epoch，在读取集群之前调用
+func (n *nearCache) epoch(group string) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.gen + n.epochs[group]
+}
+
+// add 保存从集群读取的值，epoch已经变化时放弃，expire为值本身的过期时间
+func (n *nearCache) add(group, key string, value []byte, expire time.Time, epoch uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.gen+n.epochs[group] != epoch {
+		return false
+	}
+	deadline := time.Now().Add(n.ttl)
+	if !expire.IsZero() && expire.Before(deadline) {
+		deadline = expire
+	}
+	n.lru.Add(nearKey(group, key), nearValue(cloneBytes(value)), deadline)
+	return true
+}
+
+// invalidate 删除一个key
+func (n *nearCache) invalidate(group, key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.epochs[group]++
+	n.lru.Remove(nearKey(group, key))
+}
+
+// clearGroup 删除一个Group的所有key
+func (n *nearCache) clearGroup(group string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.epochs[group]++
+	prefix := nearKey(group, "")
+	for _, k := range n.lru.Keys() {
+		if strings.HasPrefix(k, prefix) {
+			n.lru.Remove(k)
+		}
+	}
+}
+
+// clear 删除所有key
+func (n *nearCache) clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gen++
+	for _, k := range n.lru.Keys() {
+		n.lru.Remove(k)
+	}
+}
+
+// len 返回条目数量
+func (n *nearCache) len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lru.Len()
+}
+
+func cloneBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
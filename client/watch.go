@@ -0,0 +1,122 @@
+package client
+
+import (
+	pb "DistributedCache/geecachepb"
+	"context"
+	"google.golang.org/grpc"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//  Watch断开之后重连的等待时间，每次失败翻倍
+	minWatchBackoff = 100 * time.Millisecond
+	maxWatchBackoff = 10 * time.Second
+)
+
+// 会让近端缓存中的副本失效的事件，加载、淘汰和过期不会改变key的值
+var invalidatingEvents = []string{"set", "delete", "flush"}
+
+// watchKey 每个节点上的每个Group各有一个Watch
+type watchKey struct {
+	peer  string
+	group string
+}
+
+// watcher 订阅一个节点上一个Group的事件
+type watcher struct {
+	cancel context.CancelFunc
+	ready  atomic.Bool //  已经订阅成功，之后的事件不会错过
+}
+
+// watch 开始订阅所有节点上group的事件，已经订阅过时什么都不做
+func (c *Client) watch(group string) {
+	if c.near == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.groups[group] || c.closed {
+		return
+	}
+	c.groups[group] = true
+	for _, peer := range c.peers {
+		c.startWatcherLocked(peer, group)
+	}
+}
+
+// watching 返回peer上group的订阅是否已经建立，没有建立时读到的值不能放入近端缓存
+func (c *Client) watching(peer, group string) bool {
+	c.mu.Lock()
+	w := c.watchers[watchKey{peer, group}]
+	c.mu.Unlock()
+	return w != nil && w.ready.Load()
+}
+
+// startWatcherLocked 启动一个Watch，调用方持有c.mu
+func (c *Client) startWatcherLocked(peer, group string) {
+	conn := c.connLocked(peer)
+	if conn == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	w := &watcher{cancel: cancel}
+	c.watchers[watchKey{peer, group}] = w
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.runWatcher(ctx, w, conn, peer, group)
+	}()
+}
+
+// runWatcher 订阅事件直到ctx结束，断开之后清空group的近端缓存并重连
+func (c *Client) runWatcher(ctx context.Context, w *watcher, conn *grpc.ClientConn, peer, group string) {
+	backoff := minWatchBackoff
+	for {
+		subscribed, err := c.watchOnce(ctx, w, conn, group)
+		w.ready.Store(false)
+		//  断开期间可能错过了事件
+		c.near.clearGroup(group)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			backoff = minWatchBackoff
+		}
+		log.Printf("[geecache client] watch %s on %s: %v, retry in %v", group, peer, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxWatchBackoff {
+			backoff = maxWatchBackoff
+		}
+	}
+}
+
+// watchOnce 建立一次订阅并处理事件，返回订阅是否曾经建立
+func (c *Client) watchOnce(ctx context.Context, w *watcher, conn *grpc.ClientConn, group string) (bool, error) {
+	stream, err := pb.NewGroupCacheClient(conn).Watch(ctx, &pb.WatchRequest{Group: group, Types: invalidatingEvents})
+	if err != nil {
+		return false, err
+	}
+	//  节点订阅之后才发送header
+	if _, err := stream.Header(); err != nil {
+		return false, err
+	}
+	w.ready.Store(true)
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			return true, err
+		}
+		c.invalidations.Add(1)
+		if e.GetDropped() > 0 || e.GetType() == "flush" {
+			c.near.clearGroup(group)
+		} else {
+			c.near.invalidate(group, e.GetKey())
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	geecache "DistributedCache"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config 是一个缓存节点的完整配置，可以从YAML或JSON文件加载，命令行参数会覆盖文件中的值
+type Config struct {
+	Addr        string         `json:"addr" yaml:"addr"`                 // 本节点gRPC监听地址 x.x.x.x:port
+	Peers       []string       `json:"peers" yaml:"peers"`               // 集群中所有节点的地址，包括自己
+	Registry    RegistryConfig `json:"registry" yaml:"registry"`         // 服务注册与发现
+	TLS         TLSConfig      `json:"tls" yaml:"tls"`                   // 节点间通信的TLS
+	Breaker     BreakerConfig  `json:"breaker" yaml:"breaker"`           // 访问远程节点的熔断
+	Eviction    string         `json:"eviction" yaml:"eviction"`         // 淘汰策略，目前只支持lru
+	MetricsAddr string         `json:"metrics_addr" yaml:"metrics_addr"` // 指标HTTP服务地址，为空则不开启
+	HTTPAddr    string         `json:"http_addr" yaml:"http_addr"`       // HTTP/REST前端地址，为空则不开启
+	Redis       FrontendConfig `json:"redis" yaml:"redis"`               // Redis协议前端
+	Memcache    FrontendConfig `json:"memcache" yaml:"memcache"`         // memcached协议前端
+	Groups      []GroupConfig  `json:"groups" yaml:"groups"`
+}
+
+// RegistryConfig 服务注册配置
+// Type 为 etcd 时通过etcd注册和发现节点，为 none 时节点之间按Peers直连
+type RegistryConfig struct {
+	Type        string   `json:"type" yaml:"type"`
+	Endpoints   []string `json:"endpoints" yaml:"endpoints"`
+	DialTimeout Duration `json:"dial_timeout" yaml:"dial_timeout"`
+}
+
+// TLSConfig 证书配置，CAFile不为空时同时开启双向认证
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	CAFile   string `json:"ca_file" yaml:"ca_file"`
+}
+
+// Enabled 是否开启TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// BreakerConfig 每个远程节点的熔断器配置，ErrorRate和SlowRate都为0时不开启
+type BreakerConfig struct {
+	Window      Duration `json:"window" yaml:"window"`             // 统计失败比例的时间窗口
+	MinRequests int      `json:"min_requests" yaml:"min_requests"` // 窗口内的请求少于这个数量时不打开
+	ErrorRate   float64  `json:"error_rate" yaml:"error_rate"`     // 失败比例达到该值时打开
+	SlowCall    Duration `json:"slow_call" yaml:"slow_call"`       // 耗时超过该值的请求算作慢请求
+	SlowRate    float64  `json:"slow_rate" yaml:"slow_rate"`       // 慢请求比例达到该值时打开
+	OpenTimeout Duration `json:"open_timeout" yaml:"open_timeout"` // 打开之后经过这段时间进入半开
+}
+
+// Enabled 是否开启熔断
+func (c BreakerConfig) Enabled() bool {
+	return c.ErrorRate > 0 || c.SlowRate > 0
+}
+
+// FrontendConfig 兼容其它缓存协议的前端配置，Addr为空则不开启
+// Group 为默认使用的Group
+type FrontendConfig struct {
+	Addr  string `json:"addr" yaml:"addr"`
+	Group string `json:"group" yaml:"group"`
+}
+
+// GroupConfig 一个缓存空间的配置
+type GroupConfig struct {
+	Name                 string       `json:"name" yaml:"name"`
+	CacheBytes           int64        `json:"cache_bytes" yaml:"cache_bytes"`
+	Getter               GetterConfig `json:"getter" yaml:"getter"`
+	TTL                  Duration     `json:"ttl" yaml:"ttl"`                                       // 从数据源加载的值的过期时间
+	StaleWhileRevalidate Duration     `json:"stale_while_revalidate" yaml:"stale_while_revalidate"` // 过期后返回旧值并后台刷新的窗口
+	StaleIfError         Duration     `json:"stale_if_error" yaml:"stale_if_error"`                 // 加载失败时返回旧值的最大过期时长
+	TTLJitter            Duration     `json:"ttl_jitter" yaml:"ttl_jitter"`                         // 在ttl上随机增加的最大时长
+	EarlyRefreshBeta     float64      `json:"early_refresh_beta" yaml:"early_refresh_beta"`         // 提前刷新的beta，0表示关闭
+	Expiration           string       `json:"expiration" yaml:"expiration"`                         // 过期方式：absolute、sliding、jitter
+	OwnerFallback        string       `json:"owner_fallback" yaml:"owner_fallback"`                 // 所属节点不可用时：local、fail、stale
+	OwnerTimeout         Duration     `json:"owner_timeout" yaml:"owner_timeout"`                   // 等待所属节点的最长时间
+	OwnerCopyMaxAge      Duration     `json:"owner_copy_max_age" yaml:"owner_copy_max_age"`         // owner_fallback为stale时旧值最多保留的时长，0使用默认值
+	SnapshotFile         string       `json:"snapshot_file" yaml:"snapshot_file"`                   // 启动时从该文件预热缓存，退出时写回
+	SnapshotInterval     Duration     `json:"snapshot_interval" yaml:"snapshot_interval"`           // 定期写入快照的间隔，0表示只在退出时写入
+	AOFFile              string       `json:"aof_file" yaml:"aof_file"`                             // 显式写入的AOF日志，启动时在快照之后重放
+	AOFSync              string       `json:"aof_sync" yaml:"aof_sync"`                             // fsync策略：always、everysec、never
+	AOFRewriteSize       int64        `json:"aof_rewrite_size" yaml:"aof_rewrite_size"`             // 日志达到该大小并翻倍后重写，0使用默认值
+	HotKeys              int          `json:"hot_keys" yaml:"hot_keys"`                             // 统计并报告访问最多的key的个数，0表示不统计
+	HotCacheQPS          float64      `json:"hot_cache_qps" yaml:"hot_cache_qps"`                   // 其它节点上的key超过该QPS时在本地保存副本
+	HotCacheTTL          Duration     `json:"hot_cache_ttl" yaml:"hot_cache_ttl"`                   // 热点副本的有效期，0表示不保存副本
+	ReplicateQPS         float64      `json:"replicate_qps" yaml:"replicate_qps"`                   // 作为所属节点时把超过该QPS的key复制到所有请求方
+	ReplicateTTL         Duration     `json:"replicate_ttl" yaml:"replicate_ttl"`                   // 请求方保存复制的副本的时长，0表示不复制
+	RetryAttempts        int          `json:"retry_attempts" yaml:"retry_attempts"`                 // 向其它节点请求的最多次数，小于等于1表示不重试
+	RetryBackoff         Duration     `json:"retry_backoff" yaml:"retry_backoff"`                   // 第一次重试之前的等待时间，之后每次翻倍
+	RetryMaxBackoff      Duration     `json:"retry_max_backoff" yaml:"retry_max_backoff"`           // 重试等待时间的上限，0表示不限制
+	RetryJitter          float64      `json:"retry_jitter" yaml:"retry_jitter"`                     // 等待时间随机减少的最大比例，在0到1之间
+	Hedge                bool         `json:"hedge" yaml:"hedge"`                                   // 读取超过p95耗时后向后备节点发送对冲请求
+	HedgeMinDelay        Duration     `json:"hedge_min_delay" yaml:"hedge_min_delay"`               // 发送对冲请求之前最少等待的时间
+}
+
+// GetterConfig 数据源配置
+// Type 为 http 时从URL指向的源站获取，为 dir 时从Path目录读取
+type GetterConfig struct {
+	Type    string   `json:"type" yaml:"type"`
+	URL     string   `json:"url" yaml:"url"`
+	Path    string   `json:"path" yaml:"path"`
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+}
+
+// expirationModes 配置中过期方式的名字，为空时使用absolute
+var expirationModes = map[string]geecache.ExpirationMode{
+	"":                                     geecache.ExpireAbsolute,
+	geecache.ExpireAbsolute.String():       geecache.ExpireAbsolute,
+	geecache.ExpireSliding.String():        geecache.ExpireSliding,
+	geecache.ExpireAbsoluteJitter.String(): geecache.ExpireAbsoluteJitter,
+}
+
+// ownerFallbacks 配置中回退方式的名字，为空时在本节点加载
+var ownerFallbacks = map[string]geecache.OwnerFallback{
+	"":                                  geecache.FallbackLocalLoad,
+	geecache.FallbackLocalLoad.String(): geecache.FallbackLocalLoad,
+	geecache.FallbackFail.String():      geecache.FallbackFail,
+	geecache.FallbackStale.String():     geecache.FallbackStale,
+}
+
+// aofSyncs 配置中fsync策略的名字，为空时每秒fsync
+var aofSyncs = map[string]geecache.AOFSync{
+	"":                                geecache.SyncEverySecond,
+	geecache.SyncEverySecond.String(): geecache.SyncEverySecond,
+	geecache.SyncAlways.String():      geecache.SyncAlways,
+	geecache.SyncNever.String():       geecache.SyncNever,
+}
+
+// Duration 支持 "5s"、"100ms" 这样的写法
+type Duration time.Duration
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.set(value.Value)
+}
+
+// defaultConfig 不提供配置文件时使用的配置
+func defaultConfig() Config {
+	return Config{
+		Addr: "127.0.0.1:6324",
+		Registry: RegistryConfig{
+			Type:        "etcd",
+			Endpoints:   []string{"localhost:2379"},
+			DialTimeout: Duration(5 * time.Second),
+		},
+		Eviction: "lru",
+	}
+}
+
+// loadConfig 读取配置文件，按扩展名选择YAML或JSON，未出现的字段保留默认值
+func loadConfig(path string, cfg *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, cfg)
+	default:
+		return fmt.Errorf("unsupported config format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parse %s: %v", path, err)
+	}
+	return nil
+}
+
+// parseConfig 解析命令行参数，先加载-config指定的文件，再用显式给出的参数覆盖
+func parseConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("geecache", flag.ContinueOnError)
+	var (
+		path     = fs.String("config", "", "path to a YAML or JSON config file")
+		addr     = fs.String("addr", cfg.Addr, "listen address of this node, x.x.x.x:port")
+		peers    = fs.String("peers", "", "comma separated addresses of all nodes")
+		registry = fs.String("registry", cfg.Registry.Type, "registry backend: etcd or none")
+		etcd     = fs.String("etcd", strings.Join(cfg.Registry.Endpoints, ","), "comma separated etcd endpoints")
+		metrics  = fs.String("metrics", "", "metrics listen address, empty to disable")
+		httpAddr = fs.String("http", "", "HTTP/REST gateway listen address, empty to disable")
+		redis    = fs.String("redis", "", "Redis protocol listen address, empty to disable")
+		memcache = fs.String("memcache", "", "memcached protocol listen address, empty to disable")
+		certFile = fs.String("tls-cert", "", "TLS certificate file")
+		keyFile  = fs.String("tls-key", "", "TLS key file")
+		caFile   = fs.String("tls-ca", "", "TLS CA file, enables mutual TLS")
+		eviction = fs.String("eviction", cfg.Eviction, "eviction policy")
+	)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if *path != "" {
+		if err := loadConfig(*path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	//  只覆盖命令行中显式设置过的值
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "peers":
+			cfg.Peers = splitList(*peers)
+		case "registry":
+			cfg.Registry.Type = *registry
+		case "etcd":
+			cfg.Registry.Endpoints = splitList(*etcd)
+		case "metrics":
+			cfg.MetricsAddr = *metrics
+		case "http":
+			cfg.HTTPAddr = *httpAddr
+		case "redis":
+			cfg.Redis.Addr = *redis
+		case "memcache":
+			cfg.Memcache.Addr = *memcache
+		case "tls-cert":
+			cfg.TLS.CertFile = *certFile
+		case "tls-key":
+			cfg.TLS.KeyFile = *keyFile
+		case "tls-ca":
+			cfg.TLS.CAFile = *caFile
+		case "eviction":
+			cfg.Eviction = *eviction
+		}
+	})
+	return cfg, cfg.validate()
+}
+
+func (c *Config) validate() error {
+	if len(c.Peers) == 0 {
+		c.Peers = []string{c.Addr}
+	}
+	switch c.Registry.Type {
+	case "etcd":
+		if len(c.Registry.Endpoints) == 0 {
+			return fmt.Errorf("registry etcd requires endpoints")
+		}
+	case "none":
+	default:
+		return fmt.Errorf("unknown registry %q", c.Registry.Type)
+	}
+	if c.Eviction != "" && c.Eviction != "lru" {
+		return fmt.Errorf("unsupported eviction policy %q", c.Eviction)
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires both cert_file and key_file")
+	}
+	if b := c.Breaker; b.Window < 0 || b.MinRequests < 0 || b.SlowCall < 0 || b.OpenTimeout < 0 ||
+		b.ErrorRate < 0 || b.ErrorRate > 1 || b.SlowRate < 0 || b.SlowRate > 1 {
+		return fmt.Errorf("breaker: durations must not be negative and rates must be between 0 and 1")
+	}
+	if c.Breaker.SlowRate > 0 && c.Breaker.SlowCall == 0 {
+		return fmt.Errorf("breaker: slow_rate requires slow_call")
+	}
+	if len(c.Groups) == 0 {
+		return fmt.Errorf("at least one group is required")
+	}
+	if c.Redis.Group != "" && !c.hasGroup(c.Redis.Group) {
+		return fmt.Errorf("redis: unknown group %q", c.Redis.Group)
+	}
+	//  memcached协议没有选择Group的方式，未配置时使用第一个Group
+	if c.Memcache.Group == "" {
+		c.Memcache.Group = c.Groups[0].Name
+	}
+	if !c.hasGroup(c.Memcache.Group) {
+		return fmt.Errorf("memcache: unknown group %q", c.Memcache.Group)
+	}
+	for _, g := range c.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("group name is required")
+		}
+		if _, ok := expirationModes[g.Expiration]; !ok {
+			return fmt.Errorf("group %s: unknown expiration %q", g.Name, g.Expiration)
+		}
+		if _, ok := ownerFallbacks[g.OwnerFallback]; !ok {
+			return fmt.Errorf("group %s: unknown owner_fallback %q", g.Name, g.OwnerFallback)
+		}
+		if g.OwnerTimeout < 0 || g.OwnerCopyMaxAge < 0 {
+			return fmt.Errorf("group %s: owner settings must not be negative", g.Name)
+		}
+		if g.SnapshotInterval < 0 || g.SnapshotInterval > 0 && g.SnapshotFile == "" {
+			return fmt.Errorf("group %s: snapshot_interval requires snapshot_file", g.Name)
+		}
+		if _, ok := aofSyncs[g.AOFSync]; !ok {
+			return fmt.Errorf("group %s: unknown aof_sync %q", g.Name, g.AOFSync)
+		}
+		if g.AOFRewriteSize < 0 {
+			return fmt.Errorf("group %s: aof_rewrite_size must not be negative", g.Name)
+		}
+		if g.HotKeys < 0 || g.HotCacheQPS < 0 || g.HotCacheTTL < 0 || g.ReplicateQPS < 0 || g.ReplicateTTL < 0 {
+			return fmt.Errorf("group %s: hot key settings must not be negative", g.Name)
+		}
+		if g.RetryAttempts < 0 || g.RetryBackoff < 0 || g.RetryMaxBackoff < 0 || g.HedgeMinDelay < 0 {
+			return fmt.Errorf("group %s: retry settings must not be negative", g.Name)
+		}
+		if g.RetryJitter < 0 || g.RetryJitter > 1 {
+			return fmt.Errorf("group %s: retry_jitter must be between 0 and 1", g.Name)
+		}
+		if g.EarlyRefreshBeta < 0 {
+			return fmt.Errorf("group %s: early_refresh_beta must not be negative", g.Name)
+		}
+		switch g.Getter.Type {
+		case "http":
+			if g.Getter.URL == "" {
+				return fmt.Errorf("group %s: http getter requires url", g.Name)
+			}
+		case "dir":
+			if g.Getter.Path == "" {
+				return fmt.Errorf("group %s: dir getter requires path", g.Name)
+			}
+		default:
+			return fmt.Errorf("group %s: unknown getter %q", g.Name, g.Getter.Type)
+		}
+	}
+	return nil
+}
+
+func (c *Config) hasGroup(name string) bool {
+	for _, g := range c.Groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
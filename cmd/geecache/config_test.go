@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlConfig = `
+addr: 127.0.0.1:8001
+peers: [127.0.0.1:8001, 127.0.0.1:8002]
+registry:
+  type: etcd
+  endpoints: [10.0.0.1:2379]
+  dial_timeout: 3s
+metrics_addr: 127.0.0.1:9101
+breaker:
+  window: 30s
+  min_requests: 10
+  error_rate: 0.5
+  slow_call: 1s
+  slow_rate: 0.8
+  open_timeout: 15s
+redis:
+  addr: 127.0.0.1:6379
+  group: scores
+groups:
+  - name: scores
+    cache_bytes: 2048
+    getter:
+      type: http
+      url: http://origin.local/scores
+      timeout: 500ms
+    ttl: 1m
+    stale_while_revalidate: 10s
+    expiration: sliding
+    owner_fallback: stale
+    owner_timeout: 2s
+    owner_copy_max_age: 10m
+    snapshot_file: /var/lib/geecache/scores.snap
+    snapshot_interval: 5m
+    aof_file: /var/lib/geecache/scores.aof
+    aof_sync: always
+    hot_keys: 20
+    hot_cache_qps: 100
+    hot_cache_ttl: 2s
+    replicate_qps: 1000
+    replicate_ttl: 500ms
+    retry_attempts: 3
+    retry_backoff: 20ms
+    retry_max_backoff: 200ms
+    retry_jitter: 0.5
+    hedge: true
+    hedge_min_delay: 5ms
+`
+
+const jsonConfig = `{
+  "addr": "127.0.0.1:8001",
+  "registry": {"type": "none"},
+  "groups": [{"name": "files", "cache_bytes": 4096, "getter": {"type": "dir", "path": "/srv/data"}}]
+}`
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseYAML(t *testing.T) {
+	cfg, err := parseConfig([]string{"-config", writeFile(t, "node.yaml", yamlConfig)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "127.0.0.1:8001" || len(cfg.Peers) != 2 || cfg.MetricsAddr != "127.0.0.1:9101" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if time.Duration(cfg.Registry.DialTimeout) != 3*time.Second {
+		t.Fatalf("expected dial timeout 3s, got %v", time.Duration(cfg.Registry.DialTimeout))
+	}
+	if cfg.Redis.Addr != "127.0.0.1:6379" || cfg.Redis.Group != "scores" {
+		t.Fatalf("unexpected redis config %+v", cfg.Redis)
+	}
+	if b := cfg.Breaker; !b.Enabled() || time.Duration(b.Window) != 30*time.Second || b.MinRequests != 10 ||
+		b.ErrorRate != 0.5 || time.Duration(b.SlowCall) != time.Second || b.SlowRate != 0.8 ||
+		time.Duration(b.OpenTimeout) != 15*time.Second {
+		t.Fatalf("unexpected breaker config %+v", cfg.Breaker)
+	}
+	//  memcache未配置Group时使用第一个Group
+	if cfg.Memcache.Group != "scores" {
+		t.Fatalf("unexpected memcache config %+v", cfg.Memcache)
+	}
+	g := cfg.Groups[0]
+	if g.Name != "scores" || g.CacheBytes != 2048 || time.Duration(g.Getter.Timeout) != 500*time.Millisecond ||
+		time.Duration(g.TTL) != time.Minute || time.Duration(g.StaleWhileRevalidate) != 10*time.Second || g.Expiration != "sliding" ||
+		g.OwnerFallback != "stale" || time.Duration(g.OwnerTimeout) != 2*time.Second || time.Duration(g.OwnerCopyMaxAge) != 10*time.Minute ||
+		g.SnapshotFile != "/var/lib/geecache/scores.snap" || time.Duration(g.SnapshotInterval) != 5*time.Minute ||
+		g.AOFFile != "/var/lib/geecache/scores.aof" || g.AOFSync != "always" ||
+		g.HotKeys != 20 || g.HotCacheQPS != 100 || time.Duration(g.HotCacheTTL) != 2*time.Second ||
+		g.ReplicateQPS != 1000 || time.Duration(g.ReplicateTTL) != 500*time.Millisecond ||
+		g.RetryAttempts != 3 || time.Duration(g.RetryBackoff) != 20*time.Millisecond ||
+		time.Duration(g.RetryMaxBackoff) != 200*time.Millisecond || g.RetryJitter != 0.5 ||
+		!g.Hedge || time.Duration(g.HedgeMinDelay) != 5*time.Millisecond {
+		t.Fatalf("unexpected group %+v", g)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	cfg, err := parseConfig([]string{"-config", writeFile(t, "node.json", jsonConfig)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//  未配置peers时只有自己
+	if !reflect.DeepEqual(cfg.Peers, []string{"127.0.0.1:8001"}) || cfg.Registry.Type != "none" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.Groups[0].Getter.Path != "/srv/data" {
+		t.Fatalf("unexpected getter %+v", cfg.Groups[0].Getter)
+	}
+}
+
+// 测试命令行参数覆盖配置文件
+func TestFlagsOverride(t *testing.T) {
+	cfg, err := parseConfig([]string{
+		"-config", writeFile(t, "node.yaml", yamlConfig),
+		"-addr", "127.0.0.1:8003",
+		"-peers", "127.0.0.1:8003, 127.0.0.1:8004",
+		"-registry", "none",
+		"-http", "127.0.0.1:9999",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "127.0.0.1:8003" || cfg.Registry.Type != "none" || cfg.HTTPAddr != "127.0.0.1:9999" {
+		t.Fatalf("flags did not override config: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Peers, []string{"127.0.0.1:8003", "127.0.0.1:8004"}) {
+		t.Fatalf("unexpected peers %v", cfg.Peers)
+	}
+	//  未在命令行出现的值保留文件中的配置
+	if cfg.MetricsAddr != "127.0.0.1:9101" {
+		t.Fatalf("metrics addr should come from file, got %q", cfg.MetricsAddr)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, err := parseConfig([]string{"-addr", "127.0.0.1:8001"}); err == nil {
+		t.Fatalf("config without groups should be rejected")
+	}
+	path := writeFile(t, "node.json", jsonConfig)
+	if _, err := parseConfig([]string{"-config", path, "-eviction", "lfu"}); err == nil {
+		t.Fatalf("unsupported eviction policy should be rejected")
+	}
+	bad := writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "expiration: sliding", "expiration: idle", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("unknown expiration mode should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "owner_fallback: stale", "owner_fallback: retry", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("unknown owner fallback should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "snapshot_file: /var/lib/geecache/scores.snap", "", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("snapshot interval without a file should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "aof_sync: always", "aof_sync: sometimes", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("unknown aof sync policy should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "hot_keys: 20", "hot_keys: -1", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("negative hot_keys should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "retry_jitter: 0.5", "retry_jitter: 2", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("retry_jitter above 1 should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "error_rate: 0.5", "error_rate: 50", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("breaker error_rate above 1 should be rejected")
+	}
+	bad = writeFile(t, "bad.yaml", strings.Replace(yamlConfig, "group: scores", "group: nogroup", 1))
+	if _, err := parseConfig([]string{"-config", bad}); err == nil {
+		t.Fatalf("redis frontend with unknown group should be rejected")
+	}
+}
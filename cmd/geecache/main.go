@@ -0,0 +1,272 @@
+package main
+
+import (
+	geecache "DistributedCache"
+	"DistributedCache/backend"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// geecache 启动一个缓存节点
+//
+//	geecache -config node.yaml
+//	geecache -addr 127.0.0.1:8001 -peers 127.0.0.1:8001,127.0.0.1:8002 -registry none -config groups.json
+func main() {
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(cfg Config) error {
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		return err
+	}
+	svr, err := geecache.NewServer(cfg.Addr, opts...)
+	if err != nil {
+		return err
+	}
+	svr.Set(cfg.Peers...)
+
+	names := make([]string, 0, len(cfg.Groups))
+	snapshotCtx, stopSnapshots := context.WithCancel(context.Background())
+	defer stopSnapshots()
+	var snapshots sync.WaitGroup
+	for _, gc := range cfg.Groups {
+		g := geecache.NewGroup(gc.Name, gc.CacheBytes, newGetter(gc.Getter), groupOptions(gc)...)
+		g.RegisterPeers(svr)
+		names = append(names, gc.Name)
+		if gc.SnapshotFile != "" {
+			startSnapshots(snapshotCtx, &snapshots, g, gc)
+		}
+		//  快照之后的写入在日志中，在加入集群之前重放
+		if gc.AOFFile != "" {
+			if err := openAOF(g, gc); err != nil {
+				return err
+			}
+			defer g.CloseAOF()
+		}
+	}
+
+	var httpServers []*http.Server
+	if cfg.MetricsAddr != "" {
+		httpServers = append(httpServers, serveHTTP("metrics", cfg.MetricsAddr, metricsHandler(svr, names)))
+	}
+	if cfg.HTTPAddr != "" {
+		httpServers = append(httpServers, serveHTTP("http gateway", cfg.HTTPAddr, geecache.NewHTTPGateway()))
+	}
+
+	var frontends []frontend
+	if cfg.Redis.Addr != "" {
+		frontends = append(frontends, serveFrontend("redis protocol server", cfg.Redis.Addr, geecache.NewRESPServer(cfg.Redis.Group)))
+	}
+	if cfg.Memcache.Addr != "" {
+		frontends = append(frontends, serveFrontend("memcache protocol server", cfg.Memcache.Addr, geecache.NewMemcacheServer(cfg.Memcache.Group)))
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Println("geecache is running at", cfg.Addr)
+		errc <- svr.Start()
+	}()
+
+	//  收到退出信号后优雅关闭：先从注册中心下线，再等待进行中的请求结束
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Printf("received %s, shutting down", s)
+	case err := <-errc:
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range httpServers {
+		s.Shutdown(ctx)
+	}
+	for _, f := range frontends {
+		f.Close()
+	}
+	svr.Stop()
+	//  不再接受请求之后写入最后一次快照
+	stopSnapshots()
+	snapshots.Wait()
+	return <-errc
+}
+
+// openAOF 重放AOF日志，之后的显式写入都追加到日志
+func openAOF(g *geecache.Group, gc GroupConfig) error {
+	opts := []geecache.AOFOption{geecache.WithAOFSync(aofSyncs[gc.AOFSync])}
+	if gc.AOFRewriteSize > 0 {
+		opts = append(opts, geecache.WithAOFRewrite(gc.AOFRewriteSize))
+	}
+	n, err := g.OpenAOF(gc.AOFFile, opts...)
+	if err != nil {
+		return fmt.Errorf("group %s: open aof %s: %v", gc.Name, gc.AOFFile, err)
+	}
+	log.Printf("group %s: replayed %d records from %s", gc.Name, n, gc.AOFFile)
+	return nil
+}
+
+// startSnapshots 在加入集群之前从快照预热缓存，然后在后台定期写入快照
+// 快照损坏时只记录日志，已经校验通过的条目仍然有效，节点照常启动
+func startSnapshots(ctx context.Context, wg *sync.WaitGroup, g *geecache.Group, gc GroupConfig) {
+	n, err := g.LoadSnapshotFile(gc.SnapshotFile)
+	if err != nil {
+		log.Printf("group %s: load snapshot %s: %v", gc.Name, gc.SnapshotFile, err)
+	}
+	log.Printf("group %s: restored %d keys from %s", gc.Name, n, gc.SnapshotFile)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := g.RunSnapshots(ctx, gc.SnapshotFile, time.Duration(gc.SnapshotInterval)); err != nil {
+			log.Printf("group %s: save snapshot %s: %v", gc.Name, gc.SnapshotFile, err)
+		}
+	}()
+}
+
+// serveHTTP 在后台启动一个HTTP服务
+func serveHTTP(name, addr string, handler http.Handler) *http.Server {
+	s := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		log.Printf("%s is running at %s", name, addr)
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("%s: %v", name, err)
+		}
+	}()
+	return s
+}
+
+// frontend 兼容其它缓存协议的前端
+type frontend interface {
+	ListenAndServe(addr string) error
+	Close() error
+}
+
+// serveFrontend 在后台启动一个协议前端
+func serveFrontend(name, addr string, f frontend) frontend {
+	go func() {
+		log.Printf("%s is running at %s", name, addr)
+		if err := f.ListenAndServe(addr); err != nil && err != geecache.ErrServerClosed {
+			log.Printf("%s: %v", name, err)
+		}
+	}()
+	return f
+}
+
+// serverOptions 根据配置生成server的可选项
+func serverOptions(cfg Config) ([]geecache.ServerOption, error) {
+	var opts []geecache.ServerOption
+	if cfg.Registry.Type == "none" {
+		opts = append(opts, geecache.WithoutRegistry())
+	} else {
+		opts = append(opts, geecache.WithEtcdConfig(clientv3.Config{
+			Endpoints:   cfg.Registry.Endpoints,
+			DialTimeout: time.Duration(cfg.Registry.DialTimeout),
+		}))
+	}
+	if cfg.TLS.Enabled() {
+		serverCreds, clientCreds, err := loadTLS(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, geecache.WithTransportCredentials(serverCreds, clientCreds))
+	}
+	if b := cfg.Breaker; b.Enabled() {
+		opts = append(opts, geecache.WithCircuitBreaker(geecache.BreakerConfig{
+			Window:      time.Duration(b.Window),
+			MinRequests: b.MinRequests,
+			ErrorRate:   b.ErrorRate,
+			SlowCall:    time.Duration(b.SlowCall),
+			SlowRate:    b.SlowRate,
+			OpenTimeout: time.Duration(b.OpenTimeout),
+		}))
+	}
+	return opts, nil
+}
+
+// loadTLS 加载证书，返回服务端和客户端使用的凭证
+func loadTLS(c TLSConfig) (credentials.TransportCredentials, credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load tls key pair: %v", err)
+	}
+	serverConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	clientConf := &tls.Config{}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+		//  双向认证：节点之间互相校验对方证书
+		serverConf.ClientCAs = pool
+		serverConf.ClientAuth = tls.RequireAndVerifyClientCert
+		clientConf.RootCAs = pool
+		clientConf.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(serverConf), credentials.NewTLS(clientConf), nil
+}
+
+// groupOptions 根据配置生成Group的可选项
+func groupOptions(c GroupConfig) []geecache.GroupOption {
+	opts := []geecache.GroupOption{
+		geecache.WithTTL(time.Duration(c.TTL)),
+		geecache.WithStaleWhileRevalidate(time.Duration(c.StaleWhileRevalidate)),
+		geecache.WithStaleIfError(time.Duration(c.StaleIfError)),
+		geecache.WithTTLJitter(time.Duration(c.TTLJitter)),
+		geecache.WithEarlyRefresh(c.EarlyRefreshBeta),
+		geecache.WithExpirationMode(expirationModes[c.Expiration]),
+		geecache.WithOwnerLoad(ownerFallbacks[c.OwnerFallback], time.Duration(c.OwnerTimeout)),
+		geecache.WithOwnerCopyMaxAge(time.Duration(c.OwnerCopyMaxAge)),
+	}
+	if c.HotKeys > 0 {
+		opts = append(opts, geecache.WithHotKeys(c.HotKeys))
+	}
+	if c.HotCacheTTL > 0 {
+		opts = append(opts, geecache.WithHotCache(c.HotCacheQPS, time.Duration(c.HotCacheTTL)))
+	}
+	if c.ReplicateTTL > 0 {
+		opts = append(opts, geecache.WithHotReplication(c.ReplicateQPS, time.Duration(c.ReplicateTTL)))
+	}
+	if c.RetryAttempts > 1 {
+		opts = append(opts, geecache.WithRetry(geecache.RetryPolicy{
+			Attempts:   c.RetryAttempts,
+			Backoff:    time.Duration(c.RetryBackoff),
+			MaxBackoff: time.Duration(c.RetryMaxBackoff),
+			Jitter:     c.RetryJitter,
+		}))
+	}
+	if c.Hedge {
+		opts = append(opts, geecache.WithHedging(time.Duration(c.HedgeMinDelay)))
+	}
+	return opts
+}
+
+// newGetter 根据配置创建数据源
+func newGetter(c GetterConfig) geecache.Getter {
+	switch c.Type {
+	case "http":
+		return backend.NewHTTP(c.URL, time.Duration(c.Timeout))
+	default:
+		return backend.NewDir(c.Path)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	geecache "DistributedCache"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// breakerSource 提供各远程节点熔断器的状态，由节点的server实现
+type breakerSource interface {
+	Breakers() []geecache.PeerBreaker
+}
+
+// 熔断器的所有状态，每个节点的每个状态输出一个指标，当前状态为1
+var breakerStates = []geecache.BreakerState{geecache.BreakerClosed, geecache.BreakerOpen, geecache.BreakerHalfOpen}
+
+// metricsHandler 以Prometheus文本格式输出各Group的指标和节点熔断器的状态
+func metricsHandler(svr breakerSource, groups []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, name := range groups {
+			if g := geecache.GetGroup(name); g != nil {
+				writeGroupMetrics(w, g)
+			}
+		}
+		writeBreakerMetrics(w, svr.Breakers())
+	})
+}
+
+func writeBreakerMetrics(w io.Writer, breakers []geecache.PeerBreaker) {
+	for _, b := range breakers {
+		for _, state := range breakerStates {
+			v := 0
+			if b.State == state {
+				v = 1
+			}
+			fmt.Fprintf(w, "geecache_peer_breaker_state{peer=%q,state=%q} %d\n", b.Peer, state, v)
+		}
+	}
+}
+
+func writeGroupMetrics(w io.Writer, g *geecache.Group) {
+	s := &g.Stats
+	counters := []struct {
+		name  string
+		value *geecache.AtomicInt
+	}{
+		{"gets_total", &s.Gets},
+		{"cache_hits_total", &s.CacheHits},
+		{"loads_total", &s.Loads},
+		{"loads_deduped_total", &s.LoadsDeduped},
+		{"peer_loads_total", &s.PeerLoads},
+		{"peer_errors_total", &s.PeerErrors},
+		{"local_loads_total", &s.LocalLoads},
+		{"local_load_errors_total", &s.LocalLoadErrs},
+		{"server_requests_total", &s.ServerRequests},
+		{"stale_hits_total", &s.StaleHits},
+		{"stale_errors_total", &s.StaleErrors},
+		{"refreshes_total", &s.Refreshes},
+		{"early_refreshes_total", &s.EarlyRefreshes},
+		{"hot_cache_hits_total", &s.HotCacheHits},
+		{"hot_promotions_total", &s.HotPromotions},
+		{"hot_replicated_total", &s.HotReplicated},
+		{"retries_total", &s.Retries},
+		{"hedges_total", &s.Hedges},
+		{"hedge_wins_total", &s.HedgeWins},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "geecache_%s{group=%q} %d\n", c.name, g.Name(), c.value.Get())
+	}
+	cs := g.CacheStats()
+	fmt.Fprintf(w, "geecache_cache_bytes{group=%q} %d\n", g.Name(), cs.Bytes)
+	fmt.Fprintf(w, "geecache_cache_items{group=%q} %d\n", g.Name(), cs.Items)
+	fmt.Fprintf(w, "geecache_generation{group=%q} %d\n", g.Name(), g.Generation())
+	//  只输出前k个key，标签的基数是有限的
+	for _, k := range g.HotKeys() {
+		fmt.Fprintf(w, "geecache_hot_key_qps{group=%q,key=%q} %g\n", g.Name(), k.Key, k.QPS)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"DistributedCache/consistenthash"
+	pb "DistributedCache/geecachepb"
+	"DistributedCache/registry"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ctl 保存命令行参数以及与各节点的连接
+type ctl struct {
+	out      io.Writer
+	format   string        // table 或 json
+	addrs    []string      // 直接指定的节点地址
+	etcd     []string      // etcd地址，未指定addrs时从etcd发现节点
+	service  string        // 节点在etcd中注册的服务名
+	replicas int           // 虚拟节点倍数，必须与节点一致
+	timeout  time.Duration // 每个请求的超时时间
+	dialOpts []grpc.DialOption
+
+	peers []string
+	conns map[string]*grpc.ClientConn
+}
+
+func parseFlags(args []string, out io.Writer) (*ctl, []string, error) {
+	c := &ctl{out: out, conns: make(map[string]*grpc.ClientConn)}
+	fs := flag.NewFlagSet("geecachectl", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(out, usage)
+		fs.PrintDefaults()
+	}
+	var addrs, etcd, caFile, certFile, keyFile string
+	fs.StringVar(&addrs, "addr", "", "comma separated node addresses, skips the registry")
+	fs.StringVar(&etcd, "etcd", "localhost:2379", "comma separated etcd endpoints")
+	fs.StringVar(&c.service, "service", "geecache", "service name registered in etcd")
+	fs.StringVar(&c.format, "o", "table", "output format: table or json")
+	fs.IntVar(&c.replicas, "replicas", 50, "virtual nodes per peer, must match the servers")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Second, "timeout of each request")
+	fs.StringVar(&caFile, "tls-ca", "", "CA file used to verify nodes, enables TLS")
+	fs.StringVar(&certFile, "tls-cert", "", "client certificate for mutual TLS")
+	fs.StringVar(&keyFile, "tls-key", "", "client key for mutual TLS")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if c.format != "table" && c.format != "json" {
+		return nil, nil, fmt.Errorf("unknown output format %q", c.format)
+	}
+	c.addrs = splitList(addrs)
+	c.etcd = splitList(etcd)
+
+	creds := grpc.WithInsecure()
+	if caFile != "" {
+		conf, err := tlsConfig(caFile, certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(conf))
+	}
+	c.dialOpts = []grpc.DialOption{creds}
+	return c, fs.Args(), nil
+}
+
+func tlsConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	conf := &tls.Config{RootCAs: pool}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// Close 关闭所有连接
+func (c *ctl) Close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+func (c *ctl) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// listPeers 返回集群中的节点，优先使用-addr，否则从etcd读取
+func (c *ctl) listPeers(args []string) error {
+	peers, err := c.getPeers()
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(peers))
+	for _, p := range peers {
+		rows = append(rows, []string{p})
+	}
+	return c.print(peers, []string{"PEER"}, rows)
+}
+
+func (c *ctl) getPeers() ([]string, error) {
+	if c.peers != nil {
+		return c.peers, nil
+	}
+	if len(c.addrs) > 0 {
+		c.peers = c.addrs
+		return c.peers, nil
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: c.etcd, DialTimeout: c.timeout})
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	ctx, cancel := c.context()
+	defer cancel()
+	peers, err := registry.ListEndpoints(ctx, cli, c.service)
+	if err != nil {
+		return nil, fmt.Errorf("list peers from etcd: %v", err)
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peer registered as %q", c.service)
+	}
+	c.peers = peers
+	return peers, nil
+}
+
+// hashRing 用与节点相同的参数构建一致性哈希环
+func (c *ctl) hashRing() (*consistenthash.Map, error) {
+	peers, err := c.getPeers()
+	if err != nil {
+		return nil, err
+	}
+	m := consistenthash.New(c.replicas, nil)
+	m.Add(peers...)
+	return m, nil
+}
+
+// owner 返回key所属的节点
+func (c *ctl) owner(key string) (string, error) {
+	m, err := c.hashRing()
+	if err != nil {
+		return "", err
+	}
+	return m.Get(key), nil
+}
+
+func (c *ctl) client(peer string) (pb.GroupCacheClient, error) {
+	conn, ok := c.conns[peer]
+	if !ok {
+		ctx, cancel := c.context()
+		defer cancel()
+		var err error
+		opts := append([]grpc.DialOption{grpc.WithBlock()}, c.dialOpts...)
+		conn, err = grpc.DialContext(ctx, peer, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("dial %s: %v", peer, err)
+		}
+		c.conns[peer] = conn
+	}
+	return pb.NewGroupCacheClient(conn), nil
+}
+
+func (c *ctl) ownerClient(key string) (string, pb.GroupCacheClient, error) {
+	peer, err := c.owner(key)
+	if err != nil {
+		return "", nil, err
+	}
+	cli, err := c.client(peer)
+	return peer, cli, err
+}
+
+type keyValue struct {
+	Group  string `json:"group"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Expire string `json:"expire,omitempty"`
+	Peer   string `json:"peer"`
+}
+
+func (c *ctl) get(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: get <group> <key>")
+	}
+	peer, cli, err := c.ownerClient(args[1])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	resp, err := cli.Get(ctx, &pb.Request{Group: args[0], Key: args[1]})
+	if err != nil {
+		return err
+	}
+	kv := keyValue{Group: args[0], Key: args[1], Value: string(resp.GetValue()), Peer: peer}
+	if resp.GetExpire() != 0 {
+		kv.Expire = time.Unix(0, resp.GetExpire()).Format(time.RFC3339)
+	}
+	if c.format == "table" {
+		//  表格模式只输出值本身，方便在脚本中使用
+		_, err = fmt.Fprintln(c.out, kv.Value)
+		return err
+	}
+	return c.print(kv, nil, nil)
+}
+
+func (c *ctl) set(args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	fs.SetOutput(c.out)
+	ttl := fs.Duration("ttl", 0, "time to live, 0 means never expire")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return fmt.Errorf("usage: set [-ttl d] <group> <key> <value>")
+	}
+	group, key, value := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	peer, cli, err := c.ownerClient(key)
+	if err != nil {
+		return err
+	}
+	req := &pb.Request{Group: group, Key: key, Value: []byte(value)}
+	if *ttl > 0 {
+		req.Expire = time.Now().Add(*ttl).UnixNano()
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	if _, err := cli.Put(ctx, req); err != nil {
+		return err
+	}
+	return c.print(map[string]string{"result": "OK", "peer": peer}, []string{"RESULT", "PEER"}, [][]string{{"OK", peer}})
+}
+
+func (c *ctl) delete(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: delete <group> <key>")
+	}
+	peer, cli, err := c.ownerClient(args[1])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	if _, err := cli.Delete(ctx, &pb.Request{Group: args[0], Key: args[1]}); err != nil {
+		return err
+	}
+	return c.print(map[string]string{"result": "OK", "peer": peer}, []string{"RESULT", "PEER"}, [][]string{{"OK", peer}})
+}
+
+type peerStats struct {
+	Peer   string           `json:"peer"`
+	Groups []*pb.GroupStats `json:"groups"`
+}
+
+func (c *ctl) stats(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: stats [group]")
+	}
+	req := &pb.StatsRequest{}
+	if len(args) == 1 {
+		req.Group = args[0]
+	}
+	peers, err := c.getPeers()
+	if err != nil {
+		return err
+	}
+	var all []peerStats
+	var rows [][]string
+	for _, peer := range peers {
+		cli, err := c.client(peer)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.context()
+		resp, err := cli.Stats(ctx, req)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("stats from %s: %v", peer, err)
+		}
+		all = append(all, peerStats{Peer: peer, Groups: resp.GetGroups()})
+		for _, g := range resp.GetGroups() {
+			rows = append(rows, []string{peer, g.Name,
+				itoa(g.Gets), itoa(g.CacheHits), itoa(g.LoadsDeduped), itoa(g.PeerLoads),
+				itoa(g.LocalLoads), itoa(g.PeerErrors + g.LocalLoadErrs), itoa(g.CacheItems), itoa(g.CacheBytes)})
+		}
+	}
+	header := []string{"PEER", "GROUP", "GETS", "HITS", "LOADS", "PEER_LOADS", "LOCAL_LOADS", "ERRORS", "ITEMS", "BYTES"}
+	return c.print(all, header, rows)
+}
+
+type hotKey struct {
+	Peer  string  `json:"peer"`
+	Group string  `json:"group"`
+	Key   string  `json:"key"`
+	QPS   float64 `json:"qps"`
+}
+
+// hotKeys 汇总各节点报告的热点key，按QPS从高到低排列
+func (c *ctl) hotKeys(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: hotkeys [group]")
+	}
+	req := &pb.StatsRequest{}
+	if len(args) == 1 {
+		req.Group = args[0]
+	}
+	peers, err := c.getPeers()
+	if err != nil {
+		return err
+	}
+	all := []hotKey{}
+	for _, peer := range peers {
+		cli, err := c.client(peer)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.context()
+		resp, err := cli.Stats(ctx, req)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("stats from %s: %v", peer, err)
+		}
+		for _, g := range resp.GetGroups() {
+			for _, k := range g.GetHotKeys() {
+				all = append(all, hotKey{Peer: peer, Group: g.Name, Key: k.Key, QPS: k.Qps})
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].QPS > all[j].QPS })
+	rows := make([][]string, 0, len(all))
+	for _, k := range all {
+		rows = append(rows, []string{k.Peer, k.Group, k.Key, fmt.Sprintf("%.1f", k.QPS)})
+	}
+	return c.print(all, []string{"PEER", "GROUP", "KEY", "QPS"}, rows)
+}
+
+type ringPeer struct {
+	Peer     string  `json:"peer"`
+	Replicas int     `json:"replicas"`
+	Share    float64 `json:"share"`
+}
+
+type ringInfo struct {
+	Peers []ringPeer        `json:"peers"`
+	Keys  map[string]string `json:"keys,omitempty"`
+}
+
+func (c *ctl) ring(args []string) error {
+	m, err := c.hashRing()
+	if err != nil {
+		return err
+	}
+	share := m.Ownership()
+	info := ringInfo{}
+	var peerRows, keyRows [][]string
+	for _, peer := range m.Peers() {
+		info.Peers = append(info.Peers, ringPeer{Peer: peer, Replicas: c.replicas, Share: share[peer]})
+		peerRows = append(peerRows, []string{peer, fmt.Sprint(c.replicas), fmt.Sprintf("%.2f%%", share[peer]*100)})
+	}
+	if len(args) > 0 {
+		info.Keys = make(map[string]string)
+		for _, key := range args {
+			info.Keys[key] = m.Get(key)
+			keyRows = append(keyRows, []string{key, m.Get(key)})
+		}
+	}
+	if c.format == "json" {
+		return c.print(info, nil, nil)
+	}
+	if err := c.print(nil, []string{"PEER", "REPLICAS", "SHARE"}, peerRows); err != nil || len(keyRows) == 0 {
+		return err
+	}
+	fmt.Fprintln(c.out)
+	return c.print(nil, []string{"KEY", "OWNER"}, keyRows)
+}
+
+func (c *ctl) whichPeer(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: which-peer <key>")
+	}
+	peer, err := c.owner(args[0])
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]string{"key": args[0], "peer": peer}, []string{"KEY", "PEER"}, [][]string{{args[0], peer}})
+}
+
+type peerKeys struct {
+	Peer string   `json:"peer"`
+	Keys []string `json:"keys"`
+}
+
+func (c *ctl) dump(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: dump <group>")
+	}
+	peers, err := c.getPeers()
+	if err != nil {
+		return err
+	}
+	var all []peerKeys
+	var rows [][]string
+	for _, peer := range peers {
+		cli, err := c.client(peer)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := c.context()
+		resp, err := cli.Keys(ctx, &pb.KeysRequest{Group: args[0]})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("keys from %s: %v", peer, err)
+		}
+		keys := resp.GetKeys()
+		sort.Strings(keys)
+		all = append(all, peerKeys{Peer: peer, Keys: keys})
+		for _, key := range keys {
+			rows = append(rows, []string{peer, key})
+		}
+	}
+	return c.print(all, []string{"PEER", "KEY"}, rows)
+}
+
+// print 按-o指定的格式输出，json输出v，table输出header和rows
+func (c *ctl) print(v interface{}, header []string, rows [][]string) error {
+	if c.format == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func itoa(n int64) string {
+	return fmt.Sprint(n)
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	geecache "DistributedCache"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var db = map[string]string{
+	"Tom":  "630",
+	"Jack": "589",
+	"Sam":  "567",
+}
+
+// startNode 启动一个不依赖etcd的进程内节点
+func startNode(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	svr, err := geecache.NewServer(addr, geecache.WithoutRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Set(addr)
+	g := geecache.NewGroup("scores", 2<<10, geecache.GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}), geecache.WithHotKeys(5))
+	g.RegisterPeers(svr)
+	go svr.Start()
+	t.Cleanup(func() {
+		svr.Stop()
+		geecache.DestroyGroup("scores")
+	})
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("node %s did not start", addr)
+	return ""
+}
+
+func runCtl(t *testing.T, args ...string) string {
+	var out bytes.Buffer
+	if err := run(args, &out); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out.String()
+}
+
+func TestCtl(t *testing.T) {
+	addr := startNode(t)
+
+	if out := runCtl(t, "-addr", addr, "get", "scores", "Tom"); out != "630\n" {
+		t.Fatalf("get Tom: %q", out)
+	}
+	runCtl(t, "-addr", addr, "set", "-ttl", "1m", "scores", "Lily", "701")
+	var kv keyValue
+	if err := json.Unmarshal([]byte(runCtl(t, "-addr", addr, "-o", "json", "get", "scores", "Lily")), &kv); err != nil {
+		t.Fatal(err)
+	}
+	if kv.Value != "701" || kv.Expire == "" || kv.Peer != addr {
+		t.Fatalf("unexpected get result %+v", kv)
+	}
+
+	//  dump应该包含读过和写过的key
+	var dumped []peerKeys
+	if err := json.Unmarshal([]byte(runCtl(t, "-addr", addr, "-o", "json", "dump", "scores")), &dumped); err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != 1 || strings.Join(dumped[0].Keys, ",") != "Lily,Tom" {
+		t.Fatalf("unexpected dump %+v", dumped)
+	}
+
+	runCtl(t, "-addr", addr, "delete", "scores", "Lily")
+	if out := runCtl(t, "-addr", addr, "dump", "scores"); strings.Contains(out, "Lily") {
+		t.Fatalf("Lily should be deleted:\n%s", out)
+	}
+
+	var stats []peerStats
+	if err := json.Unmarshal([]byte(runCtl(t, "-addr", addr, "-o", "json", "stats", "scores")), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Groups[0].Gets != 2 || stats[0].Groups[0].LocalLoads != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if out := runCtl(t, "-addr", addr, "stats"); !strings.Contains(out, "scores") || !strings.HasPrefix(out, "PEER") {
+		t.Fatalf("unexpected stats table:\n%s", out)
+	}
+
+	//  Tom和Lily各读过一次
+	var hot []hotKey
+	if err := json.Unmarshal([]byte(runCtl(t, "-addr", addr, "-o", "json", "hotkeys", "scores")), &hot); err != nil {
+		t.Fatal(err)
+	}
+	if len(hot) != 2 || hot[0].Peer != addr || hot[0].QPS <= 0 {
+		t.Fatalf("unexpected hot keys %+v", hot)
+	}
+	if out := runCtl(t, "-addr", addr, "hotkeys"); !strings.Contains(out, "Tom") {
+		t.Fatalf("unexpected hotkeys table:\n%s", out)
+	}
+}
+
+func TestCtlRing(t *testing.T) {
+	peers := "127.0.0.1:8001,127.0.0.1:8002,127.0.0.1:8003"
+
+	var info ringInfo
+	if err := json.Unmarshal([]byte(runCtl(t, "-addr", peers, "-o", "json", "ring", "Tom")), &info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Peers) != 3 {
+		t.Fatalf("expected 3 peers, got %+v", info.Peers)
+	}
+	total := 0.0
+	for _, p := range info.Peers {
+		total += p.Share
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Fatalf("shares should add up to 1, got %f", total)
+	}
+
+	owner := strings.Fields(runCtl(t, "-addr", peers, "which-peer", "Tom"))
+	if owner[len(owner)-1] != info.Keys["Tom"] {
+		t.Fatalf("which-peer %v disagrees with ring %s", owner, info.Keys["Tom"])
+	}
+	if out := runCtl(t, "-addr", peers, "list-peers"); strings.Count(out, "127.0.0.1") != 3 {
+		t.Fatalf("unexpected peers:\n%s", out)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// geecachectl 是geecache集群的命令行管理工具
+//
+//	geecachectl -addr 127.0.0.1:8001 get scores Tom
+//	geecachectl -etcd localhost:2379 -o json stats
+const usage = `usage: geecachectl [flags] <command> [args]
+
+commands:
+  get <group> <key>                 read a key through the cluster
+  set [-ttl d] <group> <key> <value> write a key on its owner
+  delete <group> <key>              delete a key on its owner
+  stats [group]                     per node group statistics
+  hotkeys [group]                   the most requested keys on every node
+  ring [key...]                     print the hash ring and key ownership
+  which-peer <key>                  print the node that owns key
+  list-peers                        list the nodes known to the registry
+  dump <group>                      list the keys cached on every node
+
+flags:
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "geecachectl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	ctl, rest, err := parseFlags(args, stdout)
+	if err != nil {
+		return err
+	}
+	defer ctl.Close()
+	if len(rest) == 0 {
+		return fmt.Errorf("command required, see -h")
+	}
+	cmd, rest := rest[0], rest[1:]
+	switch cmd {
+	case "get":
+		return ctl.get(rest)
+	case "set":
+		return ctl.set(rest)
+	case "delete":
+		return ctl.delete(rest)
+	case "stats":
+		return ctl.stats(rest)
+	case "hotkeys":
+		return ctl.hotKeys(rest)
+	case "ring":
+		return ctl.ring(rest)
+	case "which-peer":
+		return ctl.whichPeer(rest)
+	case "list-peers":
+		return ctl.listPeers(rest)
+	case "dump":
+		return ctl.dump(rest)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
@@ -77,3 +77,59 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetN 从key所在的位置顺时针返回最多n个不同的真实节点，第一个与Get相同
+// 之后的节点是所属节点不可用时的后备节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	var peers []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(m.keys) && len(peers) < n; i++ {
+		peer := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[peer] {
+			seen[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Peers 返回哈希环上所有真实节点，按名称排序
+func (m *Map) Peers() []string {
+	seen := make(map[string]bool)
+	var peers []string
+	for _, peer := range m.hashMap {
+		if !seen[peer] {
+			seen[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+/*
+*
+Ownership 计算每个真实节点负责的哈希空间占比
+每个虚拟节点负责从上一个虚拟节点(不含)到自己(含)的区间，
+第一个虚拟节点还负责环尾到2^32的部分
+*/
+func (m *Map) Ownership() map[string]float64 {
+	share := make(map[string]float64)
+	if len(m.keys) == 0 {
+		return share
+	}
+	const space = float64(1 << 32)
+	prev := m.keys[len(m.keys)-1] - (1 << 32)
+	for _, k := range m.keys {
+		share[m.hashMap[k]] += float64(k-prev) / space
+		prev = k
+	}
+	return share
+}
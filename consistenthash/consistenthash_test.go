@@ -44,3 +44,38 @@ func TestHashing(t *testing.T) {
 - 那么用例 2/11/23/27 选择的虚拟节点分别是 02/12/24/02，也就是真实节点 2/2/4/2。
 - 添加一个真实节点 8，对应虚拟节点的哈希值是 08/18/28，此时，用例 27 对应的虚拟节点从 `02` 变更为 `28`，即真实节点 8。
 */
+
+func TestOwnership(t *testing.T) {
+	hash := New(1, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("0", "1073741824") //  虚拟节点 00 和 01073741824
+
+	share := hash.Ownership()
+	if peers := hash.Peers(); len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", peers)
+	}
+	if share["1073741824"] != 0.25 || share["0"] != 0.75 {
+		t.Fatalf("unexpected ownership %v", share)
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2") //  虚拟节点 02/04/06 12/14/16 22/24/26
+
+	if peers := hash.GetN("23", 2); len(peers) != 2 || peers[0] != "4" || peers[1] != "6" {
+		t.Fatalf("unexpected replicas %v", peers)
+	}
+	//  越过环尾回到开头，最多返回所有真实节点
+	if peers := hash.GetN("27", 5); len(peers) != 3 || peers[0] != "2" || peers[1] != "4" || peers[2] != "6" {
+		t.Fatalf("unexpected replicas %v", peers)
+	}
+	if peers := hash.GetN("11", 1); len(peers) != 1 || peers[0] != hash.Get("11") {
+		t.Fatalf("first replica should be the owner, got %v", peers)
+	}
+}
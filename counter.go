@@ -0,0 +1,115 @@
+package DistributedCache
+
+import (
+	"DistributedCache/lru"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+计数器用于限流、配额这类场景，值以十进制整数的形式保存，与Redis的INCR相同
+Incr由key所属的节点在持有缓存锁的情况下完成，因此是原子的
+计数器只有所属节点上的一份：所属节点不可用时不会回退到本地计数，
+否则各节点各自计数，结果无法合并；Incr也不是幂等的，失败后调用方不能盲目重试
+*/
+
+var (
+	// ErrNotInteger key的值不是十进制整数
+	ErrNotInteger = errors.New("geecache: value is not an integer")
+	// ErrIncrOverflow 结果超出了int64的范围
+	ErrIncrOverflow = errors.New("geecache: increment would overflow")
+)
+
+// IncrInit key不存在时计数器的初始值
+type IncrInit int
+
+const (
+	// IncrFromZero 从0开始计数，默认方式
+	IncrFromZero IncrInit = iota
+	// IncrFromGetter 通过getter加载初始值，getter返回ErrNotFound时从0开始
+	IncrFromGetter
+)
+
+// WithIncrInit 设置Incr遇到不存在的key时的初始值
+func WithIncrInit(init IncrInit) GroupOption {
+	return func(g *Group) {
+		g.incrInit = init
+	}
+}
+
+// Incr 把key的值加上delta并返回新的值，由key所属的节点原子地完成
+// key不存在时按WithIncrInit初始化，ttl只在创建key时生效，为0表示永不过期
+func (g *Group) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+	var expir time.Time
+	if ttl > 0 {
+		expir = time.Now().Add(ttl)
+	}
+	g.dropCopies(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return peer.Incr(ctx, g.name, key, delta, expir)
+		}
+	}
+	return g.incrLocally(key, delta, expir)
+}
+
+// Decr 把key的值减去delta并返回新的值，结果可以为负数
+func (g *Group) Decr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, ErrIncrOverflow
+	}
+	return g.Incr(ctx, key, -delta, ttl)
+}
+
+// incrLocally 在本节点完成Incr
+func (g *Group) incrLocally(key string, delta int64, expir time.Time) (int64, error) {
+	var initial []byte
+	switch g.incrInit {
+	case IncrFromZero:
+		initial = []byte("0")
+	case IncrFromGetter:
+		//  先尝试已有的值，不存在时才调用getter，getter不能在持有缓存锁时调用
+		n, found, err := g.incr(key, delta, nil)
+		if found {
+			return n, err
+		}
+		initial, err = g.getter.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			initial, err = []byte("0"), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	it := g.newItem(ByteView{b: cloneBytes(initial), t: expir}, expir, 0)
+	//  getter加载期间其它请求可能已经创建了key，此时在已有的值上累加
+	n, _, err := g.incr(key, delta, &it)
+	return n, err
+}
+
+// incr 在mainCache中累加并写入AOF日志，日志写入失败时found为true
+func (g *Group) incr(key string, delta int64, initial *lru.Item) (n int64, found bool, err error) {
+	logErr := g.logKey(key, func() bool {
+		//  与setLocally相同，之前发起的加载不能再用数据源的值覆盖计数器
+		//  只在累加成功时作废，值不是整数等失败的Incr不影响租约和进行中的加载
+		n, found, err = g.mainCache.incr(key, delta, initial, func() {
+			g.leases.invalidate(key)
+		})
+		return found && err == nil
+	})
+	if logErr != nil {
+		return 0, true, logErr
+	}
+	if found && err == nil {
+		g.loader.Forget(key)
+		g.publish(EventSet, key, 0)
+		g.dropReplicas(key)
+	}
+	return n, found, err
+}
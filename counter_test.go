@@ -0,0 +1,130 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIncr(t *testing.T) {
+	g := NewGroup("incr", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+
+	if n, err := g.Incr(ctx, "key", 5, 0); err != nil || n != 5 {
+		t.Fatalf("Incr missing key = %d, %v", n, err)
+	}
+	if n, err := g.Decr(ctx, "key", 7, 0); err != nil || n != -2 {
+		t.Fatalf("Decr = %d, %v", n, err)
+	}
+	if v, _ := g.mainCache.get("key"); v.String() != "-2" {
+		t.Fatalf("stored value %q", v.String())
+	}
+
+	g.Set(ctx, "text", []byte("abc"), time.Time{})
+	if _, err := g.Incr(ctx, "text", 1, 0); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expected ErrNotInteger, got %v", err)
+	}
+	g.Set(ctx, "max", []byte(fmt.Sprint(int64(math.MaxInt64))), time.Time{})
+	if _, err := g.Incr(ctx, "max", 1, 0); !errors.Is(err, ErrIncrOverflow) {
+		t.Fatalf("expected ErrIncrOverflow, got %v", err)
+	}
+
+	//  ttl只在创建时生效，过期之后重新计数
+	g.Incr(ctx, "window", 1, 20*time.Millisecond)
+	v, _ := g.mainCache.get("window")
+	expire := v.Expire()
+	g.Incr(ctx, "window", 1, time.Hour)
+	if v, _ := g.mainCache.get("window"); v.String() != "2" || !v.Expire().Equal(expire) {
+		t.Fatalf("incr should keep expire: %q, %v", v.String(), v.Expire())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n, _ := g.Incr(ctx, "window", 1, time.Hour); n != 1 {
+		t.Fatalf("expired counter should restart, got %d", n)
+	}
+}
+
+// 测试失败的Incr不会让租约失效
+func TestIncrFailureKeepsLease(t *testing.T) {
+	g := NewGroup("incr-lease", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("source unavailable")
+	}), WithIncrInit(IncrFromGetter))
+	ctx := context.Background()
+	l, err := g.LeaseGet(ctx, "key")
+	if err != nil || l.Token == 0 {
+		t.Fatalf("expected lease, got %+v, %v", l, err)
+	}
+	if _, err := g.Incr(ctx, "key", 1, 0); err == nil {
+		t.Fatalf("Incr should fail when the getter fails")
+	}
+	if err := g.SetWithLease(ctx, "key", []byte("10"), time.Time{}, l.Token); err != nil {
+		t.Fatalf("failed Incr should not invalidate the lease, got %v", err)
+	}
+}
+
+// 测试并发的Incr不会丢失更新，缓存占用的字节数随值的长度变化
+func TestIncrConcurrent(t *testing.T) {
+	g := NewGroup("incr-concurrent", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				g.Incr(context.Background(), "key", 1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := g.mainCache.get("key"); v.String() != "1000" {
+		t.Fatalf("expected 1000, got %q", v.String())
+	}
+	if st := g.CacheStats(); st.Bytes != int64(len("key")+len("1000")) {
+		t.Fatalf("unexpected cache bytes %d", st.Bytes)
+	}
+}
+
+// 测试不存在的key通过getter初始化
+func TestIncrFromGetter(t *testing.T) {
+	g := NewGroup("incr-getter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "quota" {
+			return []byte("100"), nil
+		}
+		return nil, ErrNotFound
+	}), WithIncrInit(IncrFromGetter))
+	ctx := context.Background()
+	if n, err := g.Decr(ctx, "quota", 1, 0); err != nil || n != 99 {
+		t.Fatalf("Decr quota = %d, %v", n, err)
+	}
+	if n, err := g.Incr(ctx, "unknown", 1, 0); err != nil || n != 1 {
+		t.Fatalf("Incr unknown = %d, %v", n, err)
+	}
+}
+
+// 测试Incr经过gRPC在所属节点上执行
+func TestIncrRemote(t *testing.T) {
+	NewGroup("incr-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("text"), nil
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Incr(ctx, "incr-remote", "key", 2, time.Time{})
+	if n, err := c.Incr(ctx, "incr-remote", "key", 3, time.Time{}); err != nil || n != 5 {
+		t.Fatalf("remote Incr = %d, %v", n, err)
+	}
+	c.Fetch(ctx, "incr-remote", "text")
+	if _, err := c.Incr(ctx, "incr-remote", "text", 1, time.Time{}); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expected ErrNotInteger, got %v", err)
+	}
+}
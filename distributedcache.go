@@ -2,10 +2,17 @@ package DistributedCache
 
 import (
 	pb "DistributedCache/geecachepb"
+	"DistributedCache/lru"
 	"DistributedCache/singleflight"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,7 +20,11 @@ import (
 *Group 是 GeeCache 最核心的数据结构
 负责与用户的交互，并且控制缓存值存储和获取的流程
 */
-//  要求对象实现从数据源获取数据的能力
+// ErrNotFound 表示数据源中不存在该key
+// Getter 返回的错误可以包装它，这样HTTP等前端可以区分"不存在"和其它错误
+var ErrNotFound = errors.New("geecache: key not found")
+
+// 要求对象实现从数据源获取数据的能力
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -38,6 +49,159 @@ type Group struct {
 	peers  PeerPicker          //	用于获取远程节点请求客户端
 	loader *singleflight.Group //	避免对同一个key多次加载造成缓存击穿
 	//emptyKeyDuration time.Duration//  getter返回error时对应空值key的过期时间
+	ttl                  time.Duration //  getter加载的值的过期时间，为0表示由调用方决定
+	staleWhileRevalidate time.Duration //  过期后仍直接返回旧值并在后台刷新的时间窗口
+	staleIfError         time.Duration //  加载失败时允许返回旧值的最大过期时长
+	ttlJitter            time.Duration //  在ttl上随机增加的最大时长，避免同时过期
+	earlyRefreshBeta     float64       //  提前刷新(XFetch)的beta，为0表示不提前刷新
+	expiration           ExpirationMode
+	ownerFallback        OwnerFallback          //  所属节点不可用时的处理方式
+	ownerTimeout         time.Duration          //  等待所属节点的最长时间，为0表示不限制
+	ownerCopies          cache                  //  最近从所属节点取得的值，FallbackStale时使用
+	ownerCopyMaxAge      time.Duration          //  ownerCopies中的值最多保留的时长，为0时使用默认值
+	hotCache             cache                  //  热点缓存，其它节点上的热点key在本节点的短期副本
+	leases               leaseTable             //  本节点发放的租约
+	leaseTTL             time.Duration          //  租约的有效期
+	incrInit             IncrInit               //  Incr遇到不存在的key时的初始值
+	events               eventBus               //  本节点的键空间事件订阅
+	aof                  atomic.Pointer[aofLog] //  打开的AOF日志，为nil时不记录写入
+	hotKeys              *hotKeyTracker         //  访问频率统计，为nil时不统计
+	hotQPS               float64                //  写入hotCache的QPS阈值
+	hotTTL               time.Duration          //  hotCache中副本的有效期，为0表示不写入hotCache
+	replicateQPS         float64                //  作为所属节点时把key复制到请求方的QPS阈值
+	replicateTTL         time.Duration          //  请求方保存副本的时长，为0表示不复制
+	replicas             replicaTable           //  热点复制的状态
+	replicaLoader        singleflight.Group     //  作为后备节点处理对冲请求时的加载
+	retryPolicy          RetryPolicy            //  向其它节点请求失败后的重试策略
+	hedgeMinDelay        time.Duration          //  发送对冲请求之前最少等待的时间
+	fetchLatency         *latencyTracker        //  向所属节点读取的耗时，为nil时不发送对冲请求
+
+	Stats Stats //	运行指标
+}
+
+// GroupOption Group的可选项
+type GroupOption func(*Group)
+
+// WithTTL 设置从数据源加载的值的过期时间，Get传入的expir不为零值时以expir为准
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithStaleWhileRevalidate 条目过期后的d时间内，Get直接返回旧值，同时在后台刷新一次
+func WithStaleWhileRevalidate(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleWhileRevalidate = d
+	}
+}
+
+// WithStaleIfError 条目过期后的d时间内，如果重新加载失败则返回旧值
+func WithStaleIfError(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleIfError = d
+	}
+}
+
+// ExpirationMode 缓存值的过期方式
+type ExpirationMode int
+
+const (
+	// ExpireAbsolute 在写入时确定的时间过期，默认方式
+	ExpireAbsolute ExpirationMode = iota
+	// ExpireSliding 每次读取都把过期时间延长一个TTL，适合会话类缓存
+	// 从数据源加载的值TTL为WithTTL的值，显式写入的值TTL为写入时距过期的时长
+	ExpireSliding
+	// ExpireAbsoluteJitter 与ExpireAbsolute相同，但从数据源加载的值会随机增加一段时间
+	// 未设置WithTTLJitter时随机增加ttl的10%以内
+	ExpireAbsoluteJitter
+)
+
+// String 返回过期方式的名字
+func (m ExpirationMode) String() string {
+	switch m {
+	case ExpireAbsolute:
+		return "absolute"
+	case ExpireSliding:
+		return "sliding"
+	case ExpireAbsoluteJitter:
+		return "jitter"
+	}
+	return "ExpirationMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// WithExpirationMode 设置Group的过期方式
+func WithExpirationMode(mode ExpirationMode) GroupOption {
+	return func(g *Group) {
+		g.expiration = mode
+	}
+}
+
+// WithTTLJitter 从数据源加载的值的过期时间在ttl基础上随机增加[0, jitter)
+// 避免同一时间加载的热点key同时过期，滑动过期时不生效
+func WithTTLJitter(jitter time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttlJitter = jitter
+	}
+}
+
+// WithEarlyRefresh 开启概率性的提前刷新(XFetch)
+// 越接近过期、加载越慢的key越可能在过期前被后台刷新，beta越大越早刷新，通常取1
+func WithEarlyRefresh(beta float64) GroupOption {
+	return func(g *Group) {
+		g.earlyRefreshBeta = beta
+	}
+}
+
+// OwnerFallback 向key所属的节点加载失败或超时后，非所属节点的处理方式
+// 无论哪种方式，所属节点都会把并发的远程请求合并为一次加载
+type OwnerFallback int
+
+const (
+	// FallbackLocalLoad 在本节点调用getter加载，默认方式
+	FallbackLocalLoad OwnerFallback = iota
+	// FallbackFail 返回ErrOwnerUnavailable，getter只会在所属节点上调用
+	FallbackFail
+	// FallbackStale 返回最近一次从所属节点取得的值，过期不超过WithStaleIfError的时长，
+	// 并且取得之后不超过WithOwnerCopyMaxAge的时长
+	// 没有可用的值时与FallbackFail相同
+	FallbackStale
+)
+
+// String 返回回退方式的名字
+func (f OwnerFallback) String() string {
+	switch f {
+	case FallbackLocalLoad:
+		return "local"
+	case FallbackFail:
+		return "fail"
+	case FallbackStale:
+		return "stale"
+	}
+	return "OwnerFallback(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ErrOwnerUnavailable 所属节点不可用，并且回退方式不允许在本节点加载
+var ErrOwnerUnavailable = errors.New("geecache: owner peer unavailable")
+
+// WithOwnerLoad 设置向所属节点加载时的超时和失败后的回退方式
+// timeout 避免所属节点宕机或加载卡住时请求一直阻塞，为0表示只受调用方ctx的限制
+func WithOwnerLoad(fallback OwnerFallback, timeout time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ownerFallback = fallback
+		g.ownerTimeout = timeout
+	}
+}
+
+// defaultOwnerCopyMaxAge FallbackStale保存的值默认最多保留的时长
+const defaultOwnerCopyMaxAge = 5 * time.Minute
+
+// WithOwnerCopyMaxAge 设置FallbackStale保存的值从所属节点取得之后最多保留的时长，为0时使用默认的5分钟
+// 没有过期时间的值同样受它限制，所属节点长时间不可用时不会返回任意旧的数据
+func WithOwnerCopyMaxAge(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ownerCopyMaxAge = d
+	}
 }
 
 var (
@@ -53,7 +217,7 @@ var (
 缓存学生信息的命名为 info，缓存学生课程的命名为 courses
 */
 
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
@@ -65,11 +229,28 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 		getter:    getter,                        //  缓存未命中时，获取源数据的回调函数（callback）
 		mainCache: cache{cacheBytes: cacheBytes}, //  一开始实现的并发缓存
 		loader:    &singleflight.Group{},
+		//  与groupcache的hotCache一样，只占主缓存的1/8
+		ownerCopies: cache{cacheBytes: cacheBytes / 8},
+		hotCache:    cache{cacheBytes: cacheBytes / 8},
+	}
+	g.mainCache.notify = g.evicted
+	for _, opt := range opts {
+		opt(g)
 	}
 	groups[name] = g
 	return g
 }
 
+// Name 返回Group的名字
+func (g *Group) Name() string {
+	return g.name
+}
+
+// CacheStats 返回mainCache的容量信息
+func (g *Group) CacheStats() CacheStats {
+	return g.mainCache.stats()
+}
+
 // GetGroup returns the named group created with NewGroup or nil if groups not exist
 func GetGroup(name string) *Group {
 	mu.RLock()
@@ -86,6 +267,17 @@ func GetGroup(name string) *Group {
 //	g.peers = p
 //}
 
+// GroupNames 返回所有Group的名字
+func GroupNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	return names
+}
+
 // 删除groups映射
 func DestroyGroup(name string) {
 	g := GetGroup(name)
@@ -99,33 +291,242 @@ func DestroyGroup(name string) {
 
 // 命中缓存就返回，不然就调用load去获取
 func (g *Group) Get(key string, expir time.Time) (ByteView, error) {
+	return g.GetContext(context.Background(), key, expir)
+}
+
+// GetContext 与Get相同，ctx用于携带追踪上下文并传递给远程节点
+func (g *Group) GetContext(ctx context.Context, key string, expir time.Time) (value ByteView, err error) {
+	ctx, span := startSpan(ctx, "Group.Get")
+	span.SetAttribute("group", g.name)
+	span.SetAttribute("key", key)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	g.Stats.Gets.Add(1)
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	//  远程节点的请求经过server.Get也会进入这里，所属节点统计的是整个集群的访问
+	g.recordAccess(key)
 
-	if v, ok := g.mainCache.get(key); ok {
+	item, ok := g.mainCache.getItem(key)
+	v := item.value
+	if ok && !item.stale {
+		g.Stats.CacheHits.Add(1)
 		log.Println("[GeeCache] hit")
+		span.SetAttribute("cache_hit", true)
+		if g.shouldRefreshEarly(item) {
+			g.Stats.EarlyRefreshes.Add(1)
+			g.refresh(key, expir, v.Version())
+		}
+		return v, nil
+	}
+	if !ok {
+		//  本节点不是所属节点时，热点key可能在hotCache中有副本
+		if v, ok := g.hotCache.get(key); ok {
+			g.Stats.HotCacheHits.Add(1)
+			g.replicas.hit(key)
+			span.SetAttribute("cache_hit", true)
+			span.SetAttribute("hot", true)
+			return v, nil
+		}
+		span.SetAttribute("cache_hit", false)
+		return g.load(ctx, key, expir)
+	}
+	span.SetAttribute("cache_hit", false)
+
+	//  条目已过期但还在保留期内
+	span.SetAttribute("stale", true)
+	if item.age <= g.staleWhileRevalidate {
+		g.Stats.StaleHits.Add(1)
+		g.refresh(key, expir, v.Version())
+		return v, nil
+	}
+	value, err = g.load(ctx, key, expir)
+	if err != nil && !errors.Is(err, ErrNotFound) && item.age <= g.staleIfError {
+		g.Stats.StaleErrors.Add(1)
+		log.Printf("[GeeCache] serve stale %s after load error: %v", key, err)
 		return v, nil
 	}
+	return value, err
+}
+
+// shouldRefreshEarly XFetch算法：now - cost*beta*ln(rand) >= expire 时提前刷新
+// 其中cost是上次加载的耗时，rand在(0,1]之间均匀分布；距离过期的时间由缓存的时钟计算
+func (g *Group) shouldRefreshEarly(item cacheItem) bool {
+	if g.earlyRefreshBeta <= 0 || item.left <= 0 || item.cost <= 0 {
+		return false
+	}
+	gap := -float64(item.cost) * g.earlyRefreshBeta * math.Log(1-rand.Float64())
+	return gap >= float64(item.left)
+}
 
-	return g.load(key, expir)
+// refresh 在后台重新加载key，seen是调用方看到的版本号
+// 并发的刷新经过singleflight只会加载一次
+func (g *Group) refresh(key string, expir time.Time, seen uint64) {
+	go func() {
+		//  版本号变了，说明已经被其它请求刷新过；调用方指定了过期时间时刷新前后的过期时间相同，不能用它判断
+		if v, ok := g.mainCache.get(key); ok && v.Version() != seen {
+			return
+		}
+		g.Stats.Refreshes.Add(1)
+		if _, err := g.load(context.Background(), key, expir); err != nil {
+			log.Printf("[GeeCache] background refresh %s: %v", key, err)
+		}
+	}()
 }
 
 // getLocally调用用户回调函数g.getter.Get(key)获取数据
 //
 //	本地向Retriever取回数据并填充缓存
-func (g *Group) getLocally(key string, expir time.Time) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string, expir time.Time) (ByteView, error) {
+	_, span := startSpan(ctx, "Group.getLocally")
+	defer span.End()
+	//  加载期间key被Set、Delete或按标签失效时填充租约失效，加载到的旧值不再写入缓存
+	token := g.leases.fill(key)
+	start := time.Now()
+	bytes, tags, err := g.callGetter(key)
+	cost := time.Since(start)
+	span.RecordError(err)
 	if err != nil {
+		g.leases.releaseFill(key, token, nil)
+		g.Stats.LocalLoadErrs.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value, expir)
+	g.Stats.LocalLoads.Add(1)
+	if expir.IsZero() && g.ttl > 0 {
+		expir = time.Now().Add(g.ttl + g.jitter())
+	}
+	value := ByteView{b: cloneBytes(bytes), t: expir}
+	//  在缓存锁内校验填充租约，校验和写入之间不会插入其它写入
+	if v, ok := g.mainCache.addItemIf(key, g.newItem(value, expir, cost), tags, func() bool {
+		return g.leases.releaseFill(key, token, tags)
+	}); ok {
+		value = v
+		g.publish(EventLoad, key, value.Version())
+	}
 	return value, nil
 }
 
-func (g *Group) populateCache(key string, value ByteView, expir time.Time) {
-	g.mainCache.add(key, value, expir)
+// callGetter 调用getter加载key，getter实现了TaggedGetter时同时返回标签
+func (g *Group) callGetter(key string) ([]byte, []string, error) {
+	if tg, ok := g.getter.(TaggedGetter); ok {
+		return tg.GetWithTags(key)
+	}
+	b, err := g.getter.Get(key)
+	return b, nil, err
+}
+
+// jitter 返回加载的值需要随机增加的过期时间
+func (g *Group) jitter() time.Duration {
+	max := g.ttlJitter
+	switch g.expiration {
+	case ExpireSliding:
+		return 0
+	case ExpireAbsoluteJitter:
+		if max <= 0 {
+			max = g.ttl / 10
+		}
+	}
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// populateCache 写入mainCache，cost为加载耗时，显式写入时为0，tags为key的标签
+// 返回带有版本号的值
+func (g *Group) populateCache(key string, value ByteView, expir time.Time, cost time.Duration, tags []string) ByteView {
+	return g.mainCache.addItem(key, g.newItem(value, expir, cost), tags)
+}
+
+// newItem 按Group的过期配置生成缓存条目
+func (g *Group) newItem(value ByteView, expir time.Time, cost time.Duration) lru.Item {
+	//  需要提供旧值时，条目在过期之后再保留一段时间
+	keep := g.staleWhileRevalidate
+	if g.staleIfError > keep {
+		keep = g.staleIfError
+	}
+	it := lru.Item{Value: value, Expire: expir, Cost: cost}
+	if g.expiration == ExpireSliding && !expir.IsZero() {
+		it.Mode, it.TTL = lru.ExpireSliding, time.Until(expir)
+	}
+	if !expir.IsZero() && keep > 0 {
+		it.StaleUntil = expir.Add(keep)
+	}
+	return it
+}
+
+// Set 显式写入缓存，写入由key所属的节点完成
+// expir 为零值表示永不过期，tags替换key原有的标签，用于InvalidateTag
+func (g *Group) Set(ctx context.Context, key string, value []byte, expir time.Time, tags ...string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	//  本节点保存的旧副本不能再作为回退值
+	g.dropCopies(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return g.withRetry(ctx, deadlineOf(ctx), func() error {
+				return peer.Set(ctx, g.name, key, value, expir, tags...)
+			})
+		}
+	}
+	return g.setLocally(key, value, expir, tags)
+}
+
+// setLocally 写入本节点的缓存
+func (g *Group) setLocally(key string, value []byte, expir time.Time, tags []string) error {
+	//  之后的Get不再等待写入之前发起的加载
+	g.loader.Forget(key)
+	g.leases.invalidate(key)
+	var v ByteView
+	err := g.logKey(key, func() bool {
+		v = g.populateCache(key, ByteView{b: cloneBytes(value), t: expir}, expir, 0, tags)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	g.publish(EventSet, key, v.Version())
+	g.dropReplicas(key)
+	return nil
+}
+
+// Delete 删除缓存，删除由key所属的节点完成
+func (g *Group) Delete(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	//  本节点保存的旧副本不能再作为回退值
+	g.dropCopies(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return g.withRetry(ctx, deadlineOf(ctx), func() error {
+				return peer.Delete(ctx, g.name, key)
+			})
+		}
+	}
+	return g.deleteLocally(key)
+}
+
+// deleteLocally 删除本节点的缓存
+func (g *Group) deleteLocally(key string) error {
+	g.loader.Forget(key)
+	g.leases.invalidate(key)
+	err := g.logKey(key, func() bool {
+		g.mainCache.remove(key)
+		return true
+	})
+	g.dropReplicas(key)
+	return err
+}
+
+// Keys 返回本节点缓存的所有key
+func (g *Group) Keys() []string {
+	return g.mainCache.keys()
 }
 
 // `RegisterPeers()` 方法，将 实现了 PeerPicker 接口的 HTTPPool 注入到 Group 中。
@@ -138,10 +539,24 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 
 // 修改 load 方法，使用 `PickPeer()` 方法选择节点，若非本机节点
 // 则调用 `getFromPeer()` 从远程获取。若是本机节点或失败，则回退到 `getLocally()`
-// 使用 `g.loader.Do` 包裹起来即可，这样确保了并发场景下针对相同的 key，`load` 过程只会调用一次。
-func (g *Group) load(key string, expir time.Time) (value ByteView, err error) {
+// 使用 `g.loader.DoContext` 包裹起来即可，这样确保了并发场景下针对相同的 key，`load` 过程只会调用一次。
+// ctx结束时当前请求不再等待，但加载会继续进行，结果交给其它等待者并写入缓存
+func (g *Group) load(ctx context.Context, key string, expir time.Time) (value ByteView, err error) {
+	ctx, span := startSpan(ctx, "Group.load")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	g.Stats.Loads.Add(1)
+	//  singleflight的等待单独记一个Span，用于区分排队时间和真正的加载时间
+	waitCtx, wait := startSpan(ctx, "singleflight.Do")
+	//  加载被多个请求共享，不能随发起者的ctx一起取消，只保留追踪信息
+	loadCtx := detach(waitCtx)
+	//  发起者的截止时间之后不再重试
+	deadline := deadlineOf(ctx)
 	//若非本机节点则调用 `getFromPeer()`
-	view, err := g.loader.Do(key, func() (interface{}, error) {
+	view, err, shared := g.loader.DoContext(waitCtx, key, func() (interface{}, error) {
+		g.Stats.LoadsDeduped.Add(1)
 		if g.peers != nil {
 			//if peer, ok := g.peers.PickPeer(key); ok {
 			//	if value, err = g.getFromPeer(peer, key); err == nil {
@@ -149,33 +564,92 @@ func (g *Group) load(key string, expir time.Time) (value ByteView, err error) {
 			//	}
 			//	log.Println("[GeeCaChe] Failed to get from peer", err)
 			//}
-			if fetcher, ok := g.peers.PickPeer(key); ok {
-				bytes, err := fetcher.Fetch(g.name, key)
+			fetcher, ok, diverted := g.pickReadPeer(key)
+			if diverted {
+				return g.loadDiverted(loadCtx, deadline, fetcher, ok, key)
+			}
+			if ok {
+				var value ByteView
+				err := g.withRetry(loadCtx, deadline, func() (err error) {
+					value, err = g.fetchFromPeers(loadCtx, fetcher, key)
+					return err
+				})
 				if err == nil {
-					return ByteView{b: cloneBytes(bytes)}, nil
+					g.Stats.PeerLoads.Add(1)
+					g.promoteHot(key, value)
+					return value, nil
 				}
+				//  所属节点已经确认数据源中没有该key，不必再本地加载
+				if errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+				g.Stats.PeerErrors.Add(1)
 				log.Printf("fail to get *%s* from peer, %s.\n", key, err.Error())
+				if g.ownerFallback != FallbackLocalLoad {
+					return g.ownerUnavailable(key, err)
+				}
 			}
 		}
-		return g.getLocally(key, expir)
+		return g.getLocally(loadCtx, key, expir)
 	})
+	wait.SetAttribute("shared", shared)
+	wait.RecordError(err)
+	wait.End()
 	if err == nil {
 		return view.(ByteView), nil
 	}
 	return ByteView{}, err
 }
 
+// fetchFromOwner 向所属节点加载，所属节点上并发的请求经过它的singleflight只加载一次
+func (g *Group) fetchFromOwner(ctx context.Context, fetcher Fetcher, key string) (ByteView, error) {
+	if g.ownerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.ownerTimeout)
+		defer cancel()
+	}
+	value, err := g.fetch(ctx, fetcher, key)
+	if err == nil && g.ownerFallback == FallbackStale {
+		it := lru.Item{Value: value, Expire: value.Expire()}
+		if !it.Expire.IsZero() && g.staleIfError > 0 {
+			it.StaleUntil = it.Expire.Add(g.staleIfError)
+		}
+		maxAge := g.ownerCopyMaxAge
+		if maxAge <= 0 {
+			maxAge = defaultOwnerCopyMaxAge
+		}
+		//  永不过期或保留时间超过上限的值在上限时删除
+		if limit := time.Now().Add(maxAge); !hardExpired(it, limit) {
+			it.StaleUntil = limit
+		}
+		g.ownerCopies.addItem(key, it, nil)
+	}
+	return value, err
+}
+
+// ownerUnavailable 所属节点加载失败后按回退方式处理，不会调用getter
+func (g *Group) ownerUnavailable(key string, err error) (interface{}, error) {
+	if g.ownerFallback == FallbackStale {
+		if item, ok := g.ownerCopies.getItem(key); ok {
+			g.Stats.StaleErrors.Add(1)
+			return item.value, nil
+		}
+	}
+	return nil, fmt.Errorf("%s/%s: %w: %v", g.name, key, ErrOwnerUnavailable, err)
+}
+
 // `getFromPeer()` 方法，使用实现了 PeerGetter 接口的 httpGetter 从访问远程节点，获取缓存值。
-func (g *Group) getFromPeer(peer Fetcher, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer Fetcher, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	_, err := peer.Fetch(req.Group, req.Key)
+	view, err := peer.Fetch(ctx, req.Group, req.Key)
 	//bytes, err := peer.Get(g.name, key)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	res.Value = view.ByteSlice()
+	return ByteView{b: res.Value, t: view.Expire()}, nil
 }
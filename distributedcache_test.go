@@ -1,10 +1,13 @@
 package DistributedCache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var db1 = map[string]string{
@@ -31,16 +34,16 @@ func TestGet(t *testing.T) {
 		}))
 	//---------------------上面是回调函数------------------------------------------
 	for k, v := range db1 {
-		if view, err := gee.Get(k); err != nil || view.String() != v {
+		if view, err := gee.Get(k, time.Time{}); err != nil || view.String() != v {
 			t.Fatal("failed to get value of Tom")
 		} //  load from callback function
 		// 统计某个键调用回调函数的次数，如果次数大于1，则表示调用了多次回调函数，没有缓存。
-		if _, err := gee.Get(k); err != nil || loadCounts[k] > 1 {
+		if _, err := gee.Get(k, time.Time{}); err != nil || loadCounts[k] > 1 {
 			t.Fatalf("cache %s miss", k)
 		} //  cache hit
 	}
 
-	if view, err := gee.Get("unknow"); err == nil {
+	if view, err := gee.Get("unknow", time.Time{}); err == nil {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
@@ -55,3 +58,340 @@ func TestGetter(t *testing.T) {
 		t.Errorf("callback failed")
 	}
 }
+
+// waitFor 等待cond成立，用于检查后台刷新的结果
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads AtomicInt
+	release := make(chan struct{})
+	g := NewGroup("stale-revalidate", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := loads.Get() + 1
+		if n > 1 {
+			<-release
+		}
+		loads.Add(1)
+		return []byte(fmt.Sprintf("v%d", n)), nil
+	}), WithTTL(20*time.Millisecond), WithStaleWhileRevalidate(time.Minute))
+
+	if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "v1" {
+		t.Fatalf("first get: %v %v", v, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	//  过期后立即返回旧值，并发的请求只触发一次后台刷新
+	for i := 0; i < 10; i++ {
+		if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "v1" {
+			t.Fatalf("stale get: %v %v", v, err)
+		}
+	}
+	close(release)
+	waitFor(t, func() bool {
+		v, _ := g.Get("key", time.Time{})
+		return v.String() == "v2"
+	})
+	if loads.Get() != 2 || g.Stats.StaleHits.Get() < 10 {
+		t.Fatalf("expected one refresh, loads=%d stale_hits=%d", loads.Get(), g.Stats.StaleHits.Get())
+	}
+}
+
+// 测试保留期使用与缓存相同的时钟判断
+func TestStaleWindowClock(t *testing.T) {
+	var loads AtomicInt
+	g := NewGroup("stale-clock", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(fmt.Sprintf("v%d", loads.Get())), nil
+	}), WithTTL(10*time.Second), WithStaleWhileRevalidate(5*time.Second), WithStaleIfError(time.Minute))
+	if _, err := g.Get("key", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Add(20 * time.Second)
+	g.mainCache.lru.Now = func() time.Time { return now }
+	//  过期10s，超过了5s的保留期，同步加载新值
+	if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "v2" {
+		t.Fatalf("entry outside the stale window should be reloaded, got %q, %v", v.String(), err)
+	}
+	if g.Stats.StaleHits.Get() != 0 {
+		t.Fatalf("unexpected stale hits %d", g.Stats.StaleHits.Get())
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var fail, notFound bool
+	g := NewGroup("stale-if-error", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		switch {
+		case notFound:
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		case fail:
+			return nil, fmt.Errorf("source unavailable")
+		}
+		return []byte("v1"), nil
+	}), WithTTL(10*time.Millisecond), WithStaleIfError(time.Minute))
+
+	if _, err := g.Get("key", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	fail = true
+	if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "v1" {
+		t.Fatalf("expected stale value on error, got %v %v", v, err)
+	}
+	if g.Stats.StaleErrors.Get() != 1 {
+		t.Fatalf("expected 1 stale error, got %d", g.Stats.StaleErrors.Get())
+	}
+	//  数据源确认key不存在时不返回旧值
+	notFound = true
+	if _, err := g.Get("key", time.Time{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	var loads AtomicInt
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(time.Millisecond)
+		loads.Add(1)
+		return []byte(fmt.Sprintf("v%d", loads.Get())), nil
+	})
+	//  beta足够大时每次命中都会触发提前刷新
+	g := NewGroup("early-refresh", 2<<10, getter, WithTTL(time.Hour), WithEarlyRefresh(1e12))
+	if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "v1" {
+		t.Fatalf("first get: %v %v", v, err)
+	}
+	if v, _ := g.Get("key", time.Time{}); v.String() != "v1" {
+		t.Fatalf("early refresh should not block the hit, got %v", v)
+	}
+	waitFor(t, func() bool {
+		v, _ := g.mainCache.get("key")
+		return v.String() == "v2"
+	})
+	if g.Stats.EarlyRefreshes.Get() != 1 {
+		t.Fatalf("expected 1 early refresh, got %d", g.Stats.EarlyRefreshes.Get())
+	}
+
+	//  调用方指定过期时间时刷新前后的过期时间相同，已经刷新过的版本不再重复加载
+	loads = 0
+	explicit := NewGroup("early-refresh-explicit", 2<<10, getter, WithEarlyRefresh(1e12))
+	expir := time.Now().Add(time.Hour)
+	v1, _ := explicit.Get("key", expir)
+	explicit.refresh("key", expir, v1.Version())
+	waitFor(t, func() bool {
+		v, _ := explicit.mainCache.get("key")
+		return v.Version() != v1.Version()
+	})
+	explicit.refresh("key", expir, v1.Version())
+	time.Sleep(20 * time.Millisecond)
+	if loads.Get() != 2 {
+		t.Fatalf("refresh of an already refreshed version should be skipped, loads=%d", loads.Get())
+	}
+
+	//  离过期还很远时不会提前刷新
+	loads = 0
+	g = NewGroup("no-early-refresh", 2<<10, getter, WithTTL(time.Hour), WithEarlyRefresh(1))
+	for i := 0; i < 10; i++ {
+		g.Get("key", time.Time{})
+	}
+	if g.Stats.EarlyRefreshes.Get() != 0 || loads.Get() != 1 {
+		t.Fatalf("unexpected refresh, loads=%d", loads.Get())
+	}
+	//  距离过期的时间使用缓存的时钟，时钟接近过期时间时提前刷新
+	v, _ := g.mainCache.get("key")
+	now := v.Expire().Add(-time.Nanosecond)
+	g.mainCache.lru.Now = func() time.Time { return now }
+	g.Get("key", time.Time{})
+	if g.Stats.EarlyRefreshes.Get() != 1 {
+		t.Fatalf("refresh should follow the cache clock, got %d", g.Stats.EarlyRefreshes.Get())
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	g := NewGroup("ttl-jitter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithTTL(time.Minute), WithTTLJitter(time.Minute))
+
+	start := time.Now()
+	expires := make(map[time.Time]bool)
+	for i := 0; i < 10; i++ {
+		v, err := g.Get(fmt.Sprintf("key%d", i), time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := v.Expire().Sub(start); d < time.Minute || d >= 2*time.Minute+time.Second {
+			t.Fatalf("expire out of range: %v", d)
+		}
+		expires[v.Expire()] = true
+	}
+	if len(expires) < 2 {
+		t.Fatal("expected different expire times with jitter")
+	}
+}
+
+func TestExpirationModes(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	sliding := NewGroup("expire-sliding", 2<<10, getter, WithTTL(10*time.Second), WithExpirationMode(ExpireSliding))
+	absolute := NewGroup("expire-absolute", 2<<10, getter, WithTTL(10*time.Second))
+	for _, g := range []*Group{sliding, absolute} {
+		if _, err := g.Get("key", time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//  使用确定的时钟代替真实时间
+	now := time.Now()
+	sliding.mainCache.lru.Now = func() time.Time { return now }
+	absolute.mainCache.lru.Now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		now = now.Add(8 * time.Second)
+		if _, ok := sliding.mainCache.get("key"); !ok {
+			t.Fatalf("sliding entry should be extended, round %d", i)
+		}
+	}
+	if v, _ := sliding.mainCache.get("key"); v.Expire().Sub(now) < 9*time.Second || v.Expire().Sub(now) > 10*time.Second {
+		t.Fatalf("expire should follow the last read, got %v", v.Expire().Sub(now))
+	}
+	if _, ok := absolute.mainCache.get("key"); ok {
+		t.Fatal("absolute entry should be expired")
+	}
+
+	jitter := NewGroup("expire-jitter", 2<<10, getter, WithTTL(100*time.Second), WithExpirationMode(ExpireAbsoluteJitter))
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		v, _ := jitter.Get(fmt.Sprintf("key%d", i), time.Time{})
+		if d := v.Expire().Sub(start); d < 100*time.Second || d > 111*time.Second {
+			t.Fatalf("jitter should stay within 10%% of ttl, got %v", d)
+		}
+	}
+}
+
+// 测试请求超时后放弃等待，加载仍然完成并写入缓存
+func TestLoadAbandon(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("load-abandon", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte("slow"), nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetContext(ctx, "key", time.Time{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	close(release)
+	waitFor(t, func() bool {
+		v, ok := g.mainCache.get("key")
+		return ok && v.String() == "slow"
+	})
+}
+
+// fakeOwner 模拟key所属的远程节点，fetch为nil时表示节点不可用
+type fakeOwner struct {
+	fakeFetcher
+	fetch func(ctx context.Context, key string) (ByteView, error)
+}
+
+func (f *fakeOwner) PickPeer(key string) (Fetcher, bool) { return f, true }
+
+func (f *fakeOwner) GetAll() []Fetcher { return []Fetcher{f} }
+
+func (f *fakeOwner) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	if f.fetch == nil {
+		return ByteView{}, errors.New("connection refused")
+	}
+	return f.fetch(ctx, key)
+}
+
+func (f *fakeOwner) Set(ctx context.Context, group string, key string, value []byte, expir time.Time, tags ...string) error {
+	return nil
+}
+
+func (f *fakeOwner) Delete(ctx context.Context, group string, key string) error { return nil }
+
+// 测试所属节点不可用时的回退方式，fail和stale都不会调用getter
+func TestOwnerFallback(t *testing.T) {
+	var loads int
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("local"), nil
+	})
+	owner := &fakeOwner{}
+
+	local := NewGroup("owner-local", 2<<10, getter)
+	local.RegisterPeers(owner)
+	if v, err := local.Get("key", time.Time{}); err != nil || v.String() != "local" || loads != 1 {
+		t.Fatalf("local fallback: %q, %v, %d loads", v.String(), err, loads)
+	}
+
+	fail := NewGroup("owner-fail", 2<<10, getter, WithOwnerLoad(FallbackFail, 0))
+	fail.RegisterPeers(owner)
+	if _, err := fail.Get("key", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) || loads != 1 {
+		t.Fatalf("fail fallback: %v, %d loads", err, loads)
+	}
+
+	stale := NewGroup("owner-stale", 2<<10, getter, WithOwnerLoad(FallbackStale, 0), WithStaleIfError(time.Minute))
+	stale.RegisterPeers(owner)
+	owner.fetch = func(ctx context.Context, key string) (ByteView, error) {
+		return ByteView{b: []byte("remote"), t: time.Now().Add(-time.Second)}, nil
+	}
+	if v, err := stale.Get("key", time.Time{}); err != nil || v.String() != "remote" {
+		t.Fatalf("fetch from owner: %q, %v", v.String(), err)
+	}
+	owner.fetch = nil
+	if v, err := stale.Get("key", time.Time{}); err != nil || v.String() != "remote" || loads != 1 {
+		t.Fatalf("stale fallback: %q, %v, %d loads", v.String(), err, loads)
+	}
+	if _, err := stale.Get("other", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("stale fallback without copy: %v", err)
+	}
+	//  删除之后旧副本不能再使用
+	stale.Delete(context.Background(), "key")
+	if _, err := stale.Get("key", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("stale fallback after delete: %v", err)
+	}
+
+	//  永不过期的值同样只保留WithOwnerCopyMaxAge的时长
+	aged := NewGroup("owner-stale-age", 2<<10, getter, WithOwnerLoad(FallbackStale, 0), WithOwnerCopyMaxAge(time.Minute))
+	aged.RegisterPeers(owner)
+	owner.fetch = func(ctx context.Context, key string) (ByteView, error) {
+		return ByteView{b: []byte("remote")}, nil
+	}
+	aged.Get("key", time.Time{})
+	owner.fetch = nil
+	if v, err := aged.Get("key", time.Time{}); err != nil || v.String() != "remote" {
+		t.Fatalf("stale fallback within max age: %q, %v", v.String(), err)
+	}
+	now := time.Now().Add(2 * time.Minute)
+	aged.ownerCopies.lru.Now = func() time.Time { return now }
+	if _, err := aged.Get("key", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("stale fallback past max age: %v", err)
+	}
+}
+
+// 测试所属节点卡住时，请求在超时后返回而不会一直阻塞
+func TestOwnerTimeout(t *testing.T) {
+	g := NewGroup("owner-timeout", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		t.Error("getter should not be called on non-owner")
+		return nil, nil
+	}), WithOwnerLoad(FallbackFail, 20*time.Millisecond))
+	g.RegisterPeers(&fakeOwner{fetch: func(ctx context.Context, key string) (ByteView, error) {
+		<-ctx.Done()
+		return ByteView{}, ctx.Err()
+	}})
+
+	start := time.Now()
+	if _, err := g.Get("key", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("expected ErrOwnerUnavailable, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("owner timeout not respected, took %v", d)
+	}
+}
@@ -0,0 +1,179 @@
+package DistributedCache
+
+import (
+	"DistributedCache/lru"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+键空间事件：
+每个节点只发布本节点mainCache上发生的事件，key所属节点上的Set/加载/淘汰/过期/删除都会产生事件，
+需要整个集群的事件时订阅所有节点（Watch RPC）
+事件在持有缓存锁时发布，不能阻塞：订阅方的缓冲区满了之后新的事件被丢弃并计数，
+订阅方可以通过Dropped得知丢失了多少事件
+*/
+
+// EventType 键空间事件的类型
+type EventType int
+
+const (
+	// EventSet key被Set/CompareAndSet/Incr/SetWithLease写入
+	EventSet EventType = iota
+	// EventLoad 未命中时通过getter加载并写入缓存
+	EventLoad
+	// EventEvict 超过cacheBytes被淘汰
+	EventEvict
+	// EventExpire 硬过期之后被清理，过期的条目在被读取或者淘汰时才清理，事件可能远晚于过期时间
+	EventExpire
+	// EventDelete 被Delete或者按标签、前缀失效删除
+	EventDelete
+	// EventFlush Group被Flush，Key为空
+	EventFlush
+)
+
+var eventTypeNames = []string{"set", "load", "evict", "expire", "delete", "flush"}
+
+func (t EventType) String() string {
+	if t >= 0 && int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return "unknown"
+}
+
+// ParseEventType 把String返回的名字转换为EventType
+func ParseEventType(name string) (EventType, bool) {
+	for i, n := range eventTypeNames {
+		if n == name {
+			return EventType(i), true
+		}
+	}
+	return 0, false
+}
+
+// Event 是一个键空间事件
+type Event struct {
+	Type    EventType
+	Group   string
+	Key     string
+	Version uint64 //  写入后的版本号，只有EventSet和EventLoad有，Incr产生的事件为0
+	Time    time.Time
+}
+
+// EventFilter 选择订阅的事件
+type EventFilter struct {
+	Prefix string      //  只接收以Prefix开头的key的事件，EventFlush影响所有key，总是接收
+	Types  []EventType //  只接收这些类型的事件，为空时接收所有类型
+}
+
+func (f EventFilter) match(e Event) bool {
+	if e.Type != EventFlush && !strings.HasPrefix(e.Key, f.Prefix) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// eventBufferSize 每个订阅的缓冲区大小
+const eventBufferSize = 1024
+
+// Subscription 是Subscribe返回的订阅，使用完之后需要Close
+type Subscription struct {
+	C <-chan Event //  Close之后被关闭
+
+	c       chan Event
+	filter  EventFilter
+	dropped AtomicInt
+	bus     *eventBus
+	once    sync.Once
+}
+
+// Dropped 返回因为缓冲区已满而丢弃的事件数量
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Get()
+}
+
+// Close 取消订阅并关闭C，可以重复调用
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		defer s.bus.mu.Unlock()
+		delete(s.bus.subs, s)
+		atomic.AddInt32(&s.bus.n, -1)
+		close(s.c)
+	})
+}
+
+// eventBus 把事件分发给Group的所有订阅
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+	n    int32 //  订阅的数量，没有订阅时发布事件不需要加锁
+}
+
+func (b *eventBus) subscribe(filter EventFilter) *Subscription {
+	c := make(chan Event, eventBufferSize)
+	s := &Subscription{C: c, c: c, filter: filter, bus: b}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[s] = struct{}{}
+	atomic.AddInt32(&b.n, 1)
+	return s
+}
+
+func (b *eventBus) active() bool {
+	return atomic.LoadInt32(&b.n) > 0
+}
+
+// publish 把事件非阻塞地发送给匹配的订阅
+func (b *eventBus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe 订阅本节点上Group的键空间事件
+func (g *Group) Subscribe(filter EventFilter) *Subscription {
+	return g.events.subscribe(filter)
+}
+
+// publish 发布Group的事件
+func (g *Group) publish(typ EventType, key string, ver uint64) {
+	if !g.events.active() {
+		return
+	}
+	g.events.publish(Event{Type: typ, Group: g.name, Key: key, Version: ver, Time: time.Now()})
+}
+
+// evicted 是mainCache的移除回调，调用时持有缓存锁
+func (g *Group) evicted(key string, reason lru.EvictReason) {
+	switch reason {
+	case lru.EvictCapacity:
+		g.publish(EventEvict, key, 0)
+	case lru.EvictExpired:
+		g.publish(EventExpire, key, 0)
+	case lru.EvictDeleted:
+		g.publish(EventDelete, key, 0)
+	}
+}
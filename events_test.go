@@ -0,0 +1,183 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nextEvent 从订阅中读取下一个事件
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e := <-sub.C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	return Event{}
+}
+
+// 测试写入、加载、淘汰、过期、删除和Flush都会产生事件
+func TestSubscribe(t *testing.T) {
+	g := NewGroup("events-all", int64(len("key1value")*2), GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value"), nil
+	}))
+	sub := g.Subscribe(EventFilter{})
+	defer sub.Close()
+	ctx := context.Background()
+
+	g.Set(ctx, "key1", []byte("value"), time.Time{})
+	g.Get("key2", time.Time{})
+	g.Set(ctx, "key3", []byte("value"), time.Time{}) //  淘汰key1
+	g.Delete(ctx, "key2")
+	g.Set(ctx, "key4", []byte("value"), time.Now().Add(-time.Second))
+	g.Get("key4", time.Time{}) //  key4已经过期，清理后重新加载
+	g.Flush(ctx)
+
+	want := []string{"set key1", "load key2", "evict key1", "set key3", "delete key2", "set key4", "expire key4", "load key4", "flush "}
+	for _, w := range want {
+		e := nextEvent(t, sub)
+		if got := e.Type.String() + " " + e.Key; got != w || e.Group != "events-all" {
+			t.Fatalf("got event %q in %s, want %q", got, e.Group, w)
+		}
+	}
+	//  Flush之前的条目被清理时不再产生事件
+	g.Get("key3", time.Time{})
+	if e := nextEvent(t, sub); e.Type != EventLoad || e.Key != "key3" {
+		t.Fatalf("unexpected event %v", e)
+	}
+}
+
+// 测试按前缀和类型过滤事件
+func TestSubscribeFilter(t *testing.T) {
+	g := NewGroup("events-filter", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	sub := g.Subscribe(EventFilter{Prefix: "user:", Types: []EventType{EventDelete, EventFlush}})
+	defer sub.Close()
+	ctx := context.Background()
+
+	g.Set(ctx, "user:1", []byte("v"), time.Time{})
+	g.Set(ctx, "order:1", []byte("v"), time.Time{})
+	g.InvalidatePrefix(ctx, "order:")
+	g.InvalidatePrefix(ctx, "user:")
+	g.Flush(ctx)
+	if e := nextEvent(t, sub); e.Type != EventDelete || e.Key != "user:1" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if e := nextEvent(t, sub); e.Type != EventFlush {
+		t.Fatalf("unexpected event %v", e)
+	}
+	select {
+	case e := <-sub.C:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+}
+
+// 测试订阅方跟不上时丢弃事件而不是阻塞写入，Close之后不再接收
+func TestSubscribeDropped(t *testing.T) {
+	g := NewGroup("events-dropped", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	sub := g.Subscribe(EventFilter{})
+	ctx := context.Background()
+	for i := 0; i < eventBufferSize+10; i++ {
+		g.Set(ctx, fmt.Sprintf("key%d", i), []byte("v"), time.Time{})
+	}
+	if n := sub.Dropped(); n != 10 {
+		t.Fatalf("Dropped = %d, want 10", n)
+	}
+	sub.Close()
+	sub.Close()
+	g.Set(ctx, "after", []byte("v"), time.Time{})
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Fatalf("received %d events after Close, want %d buffered", n, eventBufferSize)
+	}
+	if g.events.active() {
+		t.Fatalf("closed subscription should be removed")
+	}
+}
+
+// 测试Watch通过gRPC推送事件，节点停止时结束
+func TestWatchRemote(t *testing.T) {
+	g := NewGroup("events-watch", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	svr, _ := startServer(t)
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+
+	events := make(chan Event, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Watch(context.Background(), "events-watch", EventFilter{Types: []EventType{EventSet}}, func(e Event, dropped int64) error {
+			events <- e
+			return nil
+		})
+	}()
+	waitFor(t, g.events.active)
+	g.Delete(context.Background(), "key")
+	g.Set(context.Background(), "key", []byte("v"), time.Time{})
+	select {
+	case e := <-events:
+		v, _ := g.mainCache.get("key")
+		if e.Type != EventSet || e.Key != "key" || e.Group != "events-watch" || e.Version != v.Version() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+
+	svr.Stop()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("Watch should fail when the server stops")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not end Watch")
+	}
+	if g.events.active() {
+		t.Fatalf("subscription should be closed after Watch returns")
+	}
+}
+
+// 测试Watch的参数校验以及fn返回错误时结束
+func TestWatchErrors(t *testing.T) {
+	g := NewGroup("events-watch-errors", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+	noop := func(Event, int64) error { return nil }
+
+	if err := c.Watch(ctx, "no-such-group", EventFilter{}, noop); err == nil {
+		t.Fatalf("unknown group should fail")
+	}
+	if err := c.Watch(ctx, "events-watch-errors", EventFilter{Types: []EventType{EventType(42)}}, noop); err == nil {
+		t.Fatalf("unknown event type should fail")
+	}
+
+	stop := errors.New("stop")
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Watch(ctx, "events-watch-errors", EventFilter{}, func(Event, int64) error { return stop })
+	}()
+	waitFor(t, g.events.active)
+	g.Set(ctx, "key", []byte("v"), time.Time{})
+	if err := <-done; !errors.Is(err, stop) {
+		t.Fatalf("Watch = %v, want fn's error", err)
+	}
+	waitFor(t, func() bool { return !g.events.active() })
+}
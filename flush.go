@@ -0,0 +1,68 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+按代(generation)清空Group：
+每个条目写入时记录当前的代，读取时代不一致的条目视为不存在，
+Flush只需要把代加一，不用遍历删除，之前的条目随读取或LRU淘汰逐渐清理
+Flush在本节点完成之后广播给所有节点，节点进入的代不小于发起方的代
+*/
+
+// Generation 返回Group在本节点上当前的代
+func (g *Group) Generation() uint64 {
+	return g.mainCache.generation()
+}
+
+// Flush 清空集群中Group的所有缓存，返回本节点进入的代
+// 部分节点失败时，其余节点上的清空仍然生效，返回的错误包含失败的节点
+func (g *Group) Flush(ctx context.Context) (uint64, error) {
+	ctx, span := startSpan(ctx, "Group.Flush")
+	defer span.End()
+	gen, err := g.flushLocally(0)
+	if err != nil || g.peers == nil {
+		return gen, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, peer := range g.peers.GetAll() {
+		wg.Add(1)
+		go func(peer Fetcher) {
+			defer wg.Done()
+			if _, err := peer.Flush(ctx, g.name, gen); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	span.RecordError(err)
+	return gen, err
+}
+
+// flushLocally 让本节点进入不小于gen的新的代，返回新的代
+func (g *Group) flushLocally(gen uint64) (uint64, error) {
+	//  正在进行的加载拿到的是之前的数据，不能再写入
+	g.leases.invalidatePrefix("")
+	g.ownerCopies.flush(0)
+	g.hotCache.flush(0)
+	err := g.logFlush(func() uint64 {
+		gen = g.mainCache.flush(gen)
+		return gen
+	})
+	if err != nil {
+		return 0, err
+	}
+	g.publish(EventFlush, "", 0)
+	return gen, nil
+}
@@ -0,0 +1,151 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试Flush之后之前的条目立即失效，之后写入的条目正常可见
+func TestFlush(t *testing.T) {
+	loads := 0
+	g := NewGroup("flush-local", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("v"), nil
+	}))
+	ctx := context.Background()
+	g.Get("loaded", time.Time{})
+	g.Set(ctx, "set", []byte("v"), time.Time{})
+	g.Incr(ctx, "counter", 5, 0)
+	_, before, _ := g.GetWithVersion(ctx, "set")
+
+	gen, err := g.Flush(ctx)
+	if err != nil || gen != 1 || g.Generation() != 1 {
+		t.Fatalf("Flush = %d, %v, generation %d", gen, err, g.Generation())
+	}
+	if keys := g.Keys(); len(keys) != 0 {
+		t.Fatalf("flushed keys still listed: %v", keys)
+	}
+	if _, ok := g.mainCache.get("set"); ok {
+		t.Fatalf("flushed entry should miss")
+	}
+	//  之前的条目视为不存在：重新加载，计数器重新开始，CAS按不存在比较
+	g.Get("loaded", time.Time{})
+	if loads != 2 {
+		t.Fatalf("expected reload after flush, loads = %d", loads)
+	}
+	if n, _ := g.Incr(ctx, "counter", 1, 0); n != 1 {
+		t.Fatalf("counter should restart after flush, got %d", n)
+	}
+	if _, err := g.CompareAndSet(ctx, "set", []byte("v2"), before, 0); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("CAS against flushed version should conflict, got %v", err)
+	}
+	if _, err := g.CompareAndSet(ctx, "set", []byte("v2"), 0, 0); err != nil {
+		t.Fatalf("CAS on flushed key as absent: %v", err)
+	}
+}
+
+// 测试Flush之前发起的加载不会把旧值写入新的代
+func TestFlushDuringLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("flush-load", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("old"), nil
+	}))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.Get("key", time.Time{})
+	}()
+	<-started
+	g.Flush(context.Background())
+	close(release)
+	wg.Wait()
+	if _, ok := g.mainCache.get("key"); ok {
+		t.Fatalf("load started before Flush should not be cached")
+	}
+}
+
+// flushPeer 记录收到的Flush请求
+type flushPeer struct {
+	fakeFetcher
+	mu   sync.Mutex
+	gens []uint64
+	err  error
+}
+
+func (p *flushPeer) Flush(ctx context.Context, group string, gen uint64) (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gens = append(p.gens, gen)
+	return gen, p.err
+}
+
+type flushPeers []*flushPeer
+
+func (p flushPeers) PickPeer(key string) (Fetcher, bool) { return nil, false }
+
+func (p flushPeers) GetAll() []Fetcher {
+	all := make([]Fetcher, len(p))
+	for i, peer := range p {
+		all[i] = peer
+	}
+	return all
+}
+
+// 测试Flush广播给所有节点，部分节点失败时返回错误
+func TestFlushBroadcast(t *testing.T) {
+	g := NewGroup("flush-broadcast", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	down := errors.New("peer down")
+	peers := flushPeers{{}, {err: down}}
+	g.RegisterPeers(peers)
+	g.mainCache.flush(6)
+
+	gen, err := g.Flush(context.Background())
+	if !errors.Is(err, down) || gen != 7 {
+		t.Fatalf("Flush = %d, %v", gen, err)
+	}
+	for i, peer := range peers {
+		if len(peer.gens) != 1 || peer.gens[0] != 7 {
+			t.Fatalf("peer %d got %v", i, peer.gens)
+		}
+	}
+}
+
+// 测试Flush经过gRPC在远程节点上执行，远程节点进入的代不小于请求的代
+func TestFlushRemote(t *testing.T) {
+	g := NewGroup("flush-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "flush-remote", "key", []byte("v"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if gen, err := c.Flush(ctx, "flush-remote", 3); err != nil || gen != 3 {
+		t.Fatalf("remote Flush = %d, %v", gen, err)
+	}
+	//  请求的代不大于当前的代时仍然进入新的代
+	if gen, err := c.Flush(ctx, "flush-remote", 2); err != nil || gen != 4 {
+		t.Fatalf("remote Flush = %d, %v", gen, err)
+	}
+	if _, err := c.Fetch(ctx, "flush-remote", "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("flushed key should miss, got %v", err)
+	}
+	if g.StatsSnapshot().Generation != 4 {
+		t.Fatalf("unexpected generation %d", g.Generation())
+	}
+	if _, err := c.Flush(ctx, "no-such-group", 1); err == nil {
+		t.Fatalf("unknown group should fail")
+	}
+}
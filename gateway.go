@@ -0,0 +1,184 @@
+package DistributedCache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+gateway 模块为不会使用gRPC的客户端(shell脚本、非Go服务等)提供HTTP/REST接口
+所有请求都经过与gRPC相同的Group逻辑，包括转发到key所属的节点
+
+	GET    /groups/{group}/keys/{key}         读取缓存
+	PUT    /groups/{group}/keys/{key}?ttl=30s 写入缓存，body为缓存值
+	DELETE /groups/{group}/keys/{key}         删除缓存
+	GET    /groups/{group}/stats              Group指标(JSON)
+	GET    /stats                             本节点所有Group的指标(JSON)
+*/
+
+const (
+	gatewayGroupsPath = "/groups/"
+	gatewayStatsPath  = "/stats"
+	// 写入时允许的最大body大小
+	gatewayMaxValueBytes = 64 << 20
+)
+
+type httpGateway struct{}
+
+// NewHTTPGateway 返回HTTP前端的http.Handler
+func NewHTTPGateway() http.Handler {
+	return &httpGateway{}
+}
+
+func (h *httpGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[geecache_http] %s %s", r.Method, r.URL.Path)
+	path := r.URL.EscapedPath()
+	if path == gatewayStatsPath {
+		h.serveAllStats(w, r)
+		return
+	}
+	if !strings.HasPrefix(path, gatewayGroupsPath) {
+		http.NotFound(w, r)
+		return
+	}
+	//  访问路径格式为 {group}/keys/{key} 或 {group}/stats
+	parts := strings.SplitN(path[len(gatewayGroupsPath):], "/", 3)
+	group, err := url.PathUnescape(parts[0])
+	if err != nil || group == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	g := GetGroup(group)
+	if g == nil {
+		http.Error(w, "no such group: "+group, http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "stats":
+		h.serveGroupStats(w, r, g)
+	case len(parts) == 3 && parts[1] == "keys":
+		key, err := url.PathUnescape(parts[2])
+		if err != nil || key == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		h.serveKey(w, r, g, key)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *httpGateway) serveKey(w http.ResponseWriter, r *http.Request, g *Group, key string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		view, err := g.GetContext(r.Context(), key, time.Time{})
+		if err != nil {
+			writeGatewayError(w, err)
+			return
+		}
+		etag := etagOf(view)
+		w.Header().Set("ETag", etag)
+		if exp := view.Expire(); !exp.IsZero() {
+			w.Header().Set("Expires", exp.UTC().Format(http.TimeFormat))
+			maxAge := int64(time.Until(exp) / time.Second)
+			if maxAge < 0 {
+				maxAge = 0
+			}
+			w.Header().Set("Cache-Control", "max-age="+strconv.FormatInt(maxAge, 10))
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(view.Len()))
+		if r.Method == http.MethodGet {
+			w.Write(view.ByteSlice())
+		}
+	case http.MethodPut:
+		var expir time.Time
+		if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid ttl: "+ttl, http.StatusBadRequest)
+				return
+			}
+			expir = time.Now().Add(d)
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, gatewayMaxValueBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := g.Set(r.Context(), key, body, expir); err != nil {
+			writeGatewayError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := g.Delete(r.Context(), key); err != nil {
+			writeGatewayError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpGateway) serveGroupStats(w http.ResponseWriter, r *http.Request, g *Group) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, g.StatsSnapshot())
+}
+
+func (h *httpGateway) serveAllStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	names := GroupNames()
+	sort.Strings(names)
+	stats := make([]GroupStats, 0, len(names))
+	for _, name := range names {
+		if g := GetGroup(name); g != nil {
+			stats = append(stats, g.StatsSnapshot())
+		}
+	}
+	writeJSON(w, stats)
+}
+
+// writeGatewayError 将Group返回的错误转换为HTTP状态码
+func writeGatewayError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, ErrNotFound) {
+		code = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// etagOf 以缓存值的sha1作为强ETag
+func etagOf(v ByteView) string {
+	sum := sha1.Sum(v.b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
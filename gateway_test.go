@@ -0,0 +1,110 @@
+package DistributedCache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func doRequest(t *testing.T, method, url string, body string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res, string(b)
+}
+
+func TestHTTPGateway(t *testing.T) {
+	NewGroup("gateway", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db1[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}))
+	ts := httptest.NewServer(NewHTTPGateway())
+	defer ts.Close()
+	base := ts.URL + "/groups/gateway/keys/"
+
+	res, body := doRequest(t, http.MethodGet, base+"Sam", "", nil)
+	if res.StatusCode != http.StatusOK || body != db1["Sam"] {
+		t.Fatalf("GET Sam: %d %q", res.StatusCode, body)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	etag := res.Header.Get("ETag")
+	if res, _ := doRequest(t, http.MethodGet, base+"Sam", "", map[string]string{"If-None-Match": etag}); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", res.StatusCode)
+	}
+
+	if res, _ := doRequest(t, http.MethodGet, base+"unknown", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown key, got %d", res.StatusCode)
+	}
+	if res, _ := doRequest(t, http.MethodGet, ts.URL+"/groups/nogroup/keys/Sam", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown group, got %d", res.StatusCode)
+	}
+
+	//  key中可以包含转义后的 /
+	if res, _ := doRequest(t, http.MethodPut, base+"a%2Fb?ttl=1m", "v1", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT: %d", res.StatusCode)
+	}
+	res, body = doRequest(t, http.MethodGet, base+"a%2Fb", "", nil)
+	if res.StatusCode != http.StatusOK || body != "v1" || res.Header.Get("Expires") == "" {
+		t.Fatalf("GET after PUT: %d %q expires=%q", res.StatusCode, body, res.Header.Get("Expires"))
+	}
+	if res, _ := doRequest(t, http.MethodPut, base+"k?ttl=abc", "v", nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad ttl, got %d", res.StatusCode)
+	}
+
+	if res, _ := doRequest(t, http.MethodDelete, base+"a%2Fb", "", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: %d", res.StatusCode)
+	}
+	if res, _ := doRequest(t, http.MethodGet, base+"a%2Fb", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 after DELETE, got %d", res.StatusCode)
+	}
+	if res, _ := doRequest(t, http.MethodPost, base+"Sam", "", nil); res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", res.StatusCode)
+	}
+
+	res, body = doRequest(t, http.MethodGet, ts.URL+"/groups/gateway/stats", "", nil)
+	var stats GroupStats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("stats: %v %q", err, body)
+	}
+	if stats.Name != "gateway" || stats.LocalLoads != 1 || stats.CacheHits != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	var all []GroupStats
+	_, body = doRequest(t, http.MethodGet, ts.URL+"/stats", "", nil)
+	if err := json.Unmarshal([]byte(body), &all); err != nil || len(all) == 0 {
+		t.Fatalf("all stats: %v %q", err, body)
+	}
+}
+
+// 测试HTTP请求经过Group转发到key所属的节点
+func TestHTTPGatewayForward(t *testing.T) {
+	g := NewGroup("gateway-forward", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}))
+	g.RegisterPeers(fakePeers{fetcher: fakeFetcher{}})
+	ts := httptest.NewServer(NewHTTPGateway())
+	defer ts.Close()
+
+	res, body := doRequest(t, http.MethodGet, ts.URL+"/groups/gateway-forward/keys/k", "", nil)
+	if res.StatusCode != http.StatusOK || body != "remote-k" {
+		t.Fatalf("GET: %d %q", res.StatusCode, body)
+	}
+}
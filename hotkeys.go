@@ -0,0 +1,273 @@
+package DistributedCache
+
+import (
+	"DistributedCache/lru"
+	"container/heap"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+热点key统计：Count-Min Sketch估计每个key的访问次数，最小堆保存估计值最大的k个key(heavy hitters)
+Sketch只占用固定的内存，与key的数量无关，估计值只会偏大不会偏小
+访问频率按滑动窗口计算：保存当前窗口和上一个窗口两个Sketch，
+QPS = (上一个窗口的次数 * 上一个窗口仍在滑动窗口内的比例 + 当前窗口的次数) / 窗口长度
+*/
+
+const (
+	hotKeyWindow = time.Second //  统计QPS的窗口
+	sketchDepth  = 4           //  哈希函数的个数
+	sketchWidth  = 2048        //  每个哈希函数的计数器个数
+	//  默认报告的热点key个数
+	defaultHotKeys = 10
+)
+
+// HotKey 是一个热点key及其估计的QPS
+type HotKey struct {
+	Key string  `json:"key"`
+	QPS float64 `json:"qps"`
+}
+
+// WithHotKeys 统计本节点各个key的访问频率，GroupStats中报告访问最多的k个key
+func WithHotKeys(k int) GroupOption {
+	return func(g *Group) {
+		if k <= 0 {
+			k = defaultHotKeys
+		}
+		g.hotKeys = newHotKeyTracker(k)
+	}
+}
+
+// WithHotCache 从其它节点取得的值，如果key在本节点的QPS超过qps，就在本地的hotCache中保存ttl时长
+// 之后ttl内的读取不再访问所属节点，本节点的写入会清除这份副本，其它节点的写入最多在ttl之后可见
+// 未设置WithHotKeys时按默认的个数统计
+func WithHotCache(qps float64, ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if g.hotKeys == nil {
+			g.hotKeys = newHotKeyTracker(defaultHotKeys)
+		}
+		g.hotQPS, g.hotTTL = qps, ttl
+	}
+}
+
+// HotKeys 返回本节点访问最多的key，按QPS从高到低排列，未开启统计时返回nil
+func (g *Group) HotKeys() []HotKey {
+	if g.hotKeys == nil {
+		return nil
+	}
+	return g.hotKeys.top()
+}
+
+// recordAccess 记录一次对key的访问
+func (g *Group) recordAccess(key string) {
+	if g.hotKeys != nil {
+		g.hotKeys.record(key, 1)
+	}
+}
+
+// promoteHot 从所属节点取得的值写入hotCache：所属节点要求复制的key，或者在本节点足够热的key
+func (g *Group) promoteHot(key string, value ByteView) {
+	ttl := value.replicate
+	if ttl <= 0 && g.hotTTL > 0 && g.hotKeys.qps(key) >= g.hotQPS {
+		ttl = g.hotTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	value.replicate = 0
+	expire := time.Now().Add(ttl)
+	if e := value.Expire(); !e.IsZero() && e.Before(expire) {
+		expire = e
+	}
+	g.hotCache.addItem(key, lru.Item{Value: value, Expire: expire}, nil)
+	g.Stats.HotPromotions.Add(1)
+}
+
+// dropCopies 删除本节点保存的key的副本，key被写入或删除之后它们都不再可用
+func (g *Group) dropCopies(key string) {
+	g.ownerCopies.remove(key)
+	g.hotCache.remove(key)
+}
+
+// hotKeyTracker 估计key的访问频率并保存最热的k个key
+type hotKeyTracker struct {
+	mu    sync.Mutex
+	seed  maphash.Seed
+	k     int
+	cur   *countMinSketch //  当前窗口
+	prev  *countMinSketch //  上一个窗口
+	start time.Time       //  当前窗口的开始时间
+	heap  hotKeyHeap
+	index map[string]*hotKeyEntry
+	now   func() time.Time
+}
+
+func newHotKeyTracker(k int) *hotKeyTracker {
+	return &hotKeyTracker{
+		seed:  maphash.MakeSeed(),
+		k:     k,
+		cur:   new(countMinSketch),
+		prev:  new(countMinSketch),
+		index: make(map[string]*hotKeyEntry, k),
+		now:   time.Now,
+	}
+}
+
+// record 记录n次访问，key的估计值超过堆中最小的值时替换它
+func (t *hotKeyTracker) record(key string, n uint32) {
+	h := maphash.String(t.seed, key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	weight := t.rotate()
+	count := float64(t.cur.add(h, n)) + float64(t.prev.estimate(h))*weight
+	if e, ok := t.index[key]; ok {
+		e.count = count
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < t.k {
+		e := &hotKeyEntry{key: key, count: count}
+		heap.Push(&t.heap, e)
+		t.index[key] = e
+		return
+	}
+	if min := t.heap[0]; count > min.count {
+		delete(t.index, min.key)
+		min.key, min.count = key, count
+		t.index[key] = min
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// qps 返回key在滑动窗口内的QPS估计
+func (t *hotKeyTracker) qps(key string) float64 {
+	h := maphash.String(t.seed, key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.countLocked(h, t.rotate()) / hotKeyWindow.Seconds()
+}
+
+// top 返回最热的k个key，按QPS从高到低排列，已经没有访问的key不再报告
+func (t *hotKeyTracker) top() []HotKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	weight := t.rotate()
+	keys := make([]HotKey, 0, len(t.heap))
+	for _, e := range t.heap {
+		n := t.countLocked(maphash.String(t.seed, e.key), weight)
+		if n > 0 {
+			keys = append(keys, HotKey{Key: e.key, QPS: n / hotKeyWindow.Seconds()})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].QPS != keys[j].QPS {
+			return keys[i].QPS > keys[j].QPS
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+func (t *hotKeyTracker) countLocked(h uint64, weight float64) float64 {
+	return float64(t.cur.estimate(h)) + float64(t.prev.estimate(h))*weight
+}
+
+// rotate 在窗口结束时切换Sketch，返回上一个窗口的权重，调用方持有t.mu
+func (t *hotKeyTracker) rotate() float64 {
+	now := t.now()
+	elapsed := now.Sub(t.start)
+	if elapsed >= hotKeyWindow {
+		if elapsed < 2*hotKeyWindow {
+			t.prev, t.cur = t.cur, t.prev
+			t.start = t.start.Add(hotKeyWindow)
+		} else {
+			//  超过一个窗口没有访问，两个窗口的计数都已经过时
+			t.prev.reset()
+			t.start = now
+		}
+		t.cur.reset()
+		t.rescoreLocked()
+		elapsed = now.Sub(t.start)
+	}
+	return 1 - float64(elapsed)/float64(hotKeyWindow)
+}
+
+// rescoreLocked 切换窗口之后重新计算堆中各个key的估计值，调用方持有t.mu
+func (t *hotKeyTracker) rescoreLocked() {
+	for _, e := range t.heap {
+		e.count = float64(t.prev.estimate(maphash.String(t.seed, e.key)))
+	}
+	heap.Init(&t.heap)
+}
+
+// countMinSketch 每行用一个哈希函数映射到一个计数器，估计值取各行计数器的最小值
+type countMinSketch [sketchDepth][sketchWidth]uint32
+
+// add 把key的各个计数器加n，达到上限后不再增加，返回新的估计值
+func (s *countMinSketch) add(h uint64, n uint32) uint32 {
+	min := ^uint32(0)
+	for i := range s {
+		c := &s[i][sketchIndex(h, i)]
+		if *c > ^uint32(0)-n {
+			*c = ^uint32(0)
+		} else {
+			*c += n
+		}
+		if *c < min {
+			min = *c
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) estimate(h uint64) uint32 {
+	min := ^uint32(0)
+	for i := range s {
+		if c := s[i][sketchIndex(h, i)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) reset() {
+	*s = countMinSketch{}
+}
+
+// sketchIndex 用双重哈希从一个64位哈希值得到第i个哈希函数的结果
+func sketchIndex(h uint64, i int) uint32 {
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	return (h1 + uint32(i)*h2) % sketchWidth
+}
+
+// hotKeyEntry 是堆中的一个key
+type hotKeyEntry struct {
+	key   string
+	count float64
+	index int
+}
+
+// hotKeyHeap 按估计值排列的最小堆，堆顶是k个key中最冷的
+type hotKeyHeap []*hotKeyEntry
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	e := x.(*hotKeyEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
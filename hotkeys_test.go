@@ -0,0 +1,107 @@
+package DistributedCache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// 测试Count-Min Sketch在大量冷key中找出热点key，QPS随滑动窗口衰减
+func TestHotKeyTracker(t *testing.T) {
+	tr := newHotKeyTracker(3)
+	now := time.Now()
+	tr.now = func() time.Time { return now }
+
+	for i := 0; i < 5000; i++ {
+		tr.record(fmt.Sprintf("cold%d", i), 1)
+		if i%50 == 0 {
+			tr.record("hot", 1)
+		}
+		if i%100 == 0 {
+			tr.record("warm", 1)
+		}
+	}
+	top := tr.top()
+	if len(top) != 3 || top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Fatalf("unexpected top keys %+v", top)
+	}
+	//  估计值只会偏大
+	if top[0].QPS < 100 || top[0].QPS > 110 {
+		t.Fatalf("hot qps %f, want about 100", top[0].QPS)
+	}
+
+	//  滑动窗口过了一半，上一个窗口的次数只计一半
+	now = now.Add(hotKeyWindow * 3 / 2)
+	if qps := tr.qps("hot"); math.Abs(qps-top[0].QPS/2) > 1 {
+		t.Fatalf("qps after half a window %f, want %f", qps, top[0].QPS/2)
+	}
+	if top := tr.top(); len(top) == 0 || top[0].Key != "hot" {
+		t.Fatalf("unexpected top keys %+v", top)
+	}
+	now = now.Add(2 * hotKeyWindow)
+	if top := tr.top(); len(top) != 0 {
+		t.Fatalf("idle keys should not be reported: %+v", top)
+	}
+}
+
+// countingFetcher 记录Fetch的次数
+type countingFetcher struct {
+	fakeFetcher
+	fetches AtomicInt
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	f.fetches.Add(1)
+	return f.fakeFetcher.Fetch(ctx, group, key)
+}
+
+// 测试超过阈值的key写入hotCache，之后的读取不再访问所属节点，本节点的写入清除副本
+func TestHotCachePromotion(t *testing.T) {
+	g := NewGroup("hot-promote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}), WithHotKeys(5), WithHotCache(5, time.Minute))
+	now := time.Now()
+	g.hotKeys.now = func() time.Time { return now }
+	f := &countingFetcher{}
+	g.RegisterPeers(fakePeers{fetcher: f})
+
+	for i := 0; i < 10; i++ {
+		if v, err := g.Get("hot", time.Time{}); err != nil || v.String() != "remote-hot" {
+			t.Fatalf("Get = %q, %v", v.String(), err)
+		}
+		if i%3 == 0 {
+			g.Get("cold", time.Time{})
+		}
+	}
+	//  hot第5次读取时达到阈值，cold没有达到
+	if n := f.fetches.Get(); n != 5+4 {
+		t.Fatalf("owner fetched %d times", n)
+	}
+	st := g.StatsSnapshot()
+	if st.HotCacheHits != 5 || st.HotPromotions != 1 || len(st.HotKeys) != 2 || st.HotKeys[0].QPS != 10 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	g.Set(context.Background(), "hot", []byte("new"), time.Time{})
+	g.Get("hot", time.Time{})
+	if n := f.fetches.Get(); n != 10 {
+		t.Fatalf("hot copy should be dropped after Set, fetched %d times", n)
+	}
+}
+
+// 测试未开启统计时不报告热点key，也不写入hotCache
+func TestHotKeysDisabled(t *testing.T) {
+	g := NewGroup("hot-disabled", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}))
+	f := &countingFetcher{}
+	g.RegisterPeers(fakePeers{fetcher: f})
+	for i := 0; i < 10; i++ {
+		g.Get("hot", time.Time{})
+	}
+	if f.fetches.Get() != 10 || g.HotKeys() != nil {
+		t.Fatalf("hot keys should be disabled")
+	}
+}
@@ -0,0 +1,117 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+按标签和前缀批量失效：
+key的标签来自Set，或者来自实现了TaggedGetter的getter，每个节点在mainCache旁维护标签索引
+InvalidateTag/InvalidatePrefix 在本节点删除之后广播给所有节点，所有节点确认之后才返回成功
+*/
+
+// TaggedGetter 是可以同时返回标签的Getter，Group的getter实现了它时加载的值会带上标签
+type TaggedGetter interface {
+	Getter
+	GetWithTags(key string) ([]byte, []string, error)
+}
+
+// TaggedGetterFunc 把函数转换为TaggedGetter
+type TaggedGetterFunc func(key string) ([]byte, []string, error)
+
+// Get 实现Getter接口，丢弃标签
+func (f TaggedGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// GetWithTags 实现TaggedGetter接口
+func (f TaggedGetterFunc) GetWithTags(key string) ([]byte, []string, error) {
+	return f(key)
+}
+
+// InvalidateTag 删除集群中带有tag标签的所有key，返回删除的数量
+// 部分节点失败时，其余节点上的删除仍然生效，返回的错误包含失败的节点
+func (g *Group) InvalidateTag(ctx context.Context, tag string) (int64, error) {
+	if tag == "" {
+		return 0, fmt.Errorf("tag is required")
+	}
+	return g.invalidate(ctx, tag, "")
+}
+
+// InvalidatePrefix 删除集群中以prefix开头的所有key，返回删除的数量
+// prefix不能为空，清空整个Group应当使用专门的操作
+func (g *Group) InvalidatePrefix(ctx context.Context, prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix is required")
+	}
+	return g.invalidate(ctx, "", prefix)
+}
+
+// invalidate 先删除本节点，再并发地广播给其它节点并等待确认
+func (g *Group) invalidate(ctx context.Context, tag, prefix string) (int64, error) {
+	ctx, span := startSpan(ctx, "Group.invalidate")
+	defer span.End()
+	n, err := g.invalidateLocally(tag, prefix)
+	total := int64(n)
+	if err != nil || g.peers == nil {
+		return total, err
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, peer := range g.peers.GetAll() {
+		wg.Add(1)
+		go func(peer Fetcher) {
+			defer wg.Done()
+			n, err := peer.Invalidate(ctx, g.name, tag, prefix)
+			mu.Lock()
+			defer mu.Unlock()
+			total += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	span.RecordError(err)
+	return total, err
+}
+
+// invalidateLocally 删除本节点上带有tag标签或以prefix开头的key
+func (g *Group) invalidateLocally(tag, prefix string) (int, error) {
+	var n int
+	switch {
+	case tag != "" && prefix == "":
+		//  所属节点的旧副本没有标签，无法按标签区分，全部丢弃
+		g.ownerCopies.removePrefix("")
+		g.hotCache.removePrefix("")
+		//  与前缀相同，正在进行的加载不能再写入旧值，加载完成之前不知道标签，在写入时校验
+		g.leases.invalidateTag(tag)
+		var keys []string
+		err := g.logOp(aofInvalidateTag, tag, func() {
+			keys = g.mainCache.removeTag(tag, g.leases.invalidate)
+		})
+		for _, key := range keys {
+			g.loader.Forget(key)
+		}
+		return len(keys), err
+	case prefix != "" && tag == "":
+		//  正在进行的加载不能再写入旧值
+		g.leases.invalidatePrefix(prefix)
+		g.ownerCopies.removePrefix(prefix)
+		g.hotCache.removePrefix(prefix)
+		err := g.logOp(aofInvalidatePrefix, prefix, func() {
+			n = g.mainCache.removePrefix(prefix)
+		})
+		return n, err
+	}
+	return 0, fmt.Errorf("exactly one of tag and prefix is required")
+}
@@ -0,0 +1,190 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	g := NewGroup("invalidate-tag", 2<<10, TaggedGetterFunc(func(key string) ([]byte, []string, error) {
+		return []byte("v-" + key), []string{"product:1"}, nil
+	}))
+	ctx := context.Background()
+	g.Get("loaded", time.Time{})
+	g.Set(ctx, "set", []byte("v"), time.Time{}, "product:1", "catalog")
+	g.Set(ctx, "other", []byte("v"), time.Time{}, "product:2")
+	//  重新写入时替换原有的标签
+	g.Set(ctx, "retagged", []byte("v"), time.Time{}, "product:1")
+	g.Set(ctx, "retagged", []byte("v"), time.Time{}, "product:2")
+
+	if n, err := g.InvalidateTag(ctx, "product:1"); err != nil || n != 2 {
+		t.Fatalf("InvalidateTag = %d, %v", n, err)
+	}
+	for key, want := range map[string]bool{"loaded": false, "set": false, "other": true, "retagged": true} {
+		if _, ok := g.mainCache.get(key); ok != want {
+			t.Fatalf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+	//  被删除的key不再出现在其它标签中
+	if n, _ := g.InvalidateTag(ctx, "catalog"); n != 0 {
+		t.Fatalf("catalog should be empty, removed %d", n)
+	}
+	if _, err := g.InvalidateTag(ctx, ""); err == nil {
+		t.Fatalf("empty tag should be rejected")
+	}
+}
+
+// 测试加载期间标签被失效时，加载到的旧值不写入缓存，没有这个标签的加载不受影响
+func TestInvalidateTagDuringLoad(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	g := NewGroup("invalidate-tag-load", 2<<10, TaggedGetterFunc(func(key string) ([]byte, []string, error) {
+		started <- struct{}{}
+		<-release
+		return []byte("old-" + key), []string{"tag:" + key}, nil
+	}))
+	ctx := context.Background()
+	done := make(chan struct{})
+	for _, key := range []string{"a", "b"} {
+		go func(key string) {
+			defer func() { done <- struct{}{} }()
+			if v, err := g.Get(key, time.Time{}); err != nil || v.String() != "old-"+key {
+				t.Errorf("Get(%s) = %q, %v", key, v.String(), err)
+			}
+		}(key)
+	}
+	<-started
+	<-started
+	if _, err := g.InvalidateTag(ctx, "tag:a"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+	<-done
+	if _, ok := g.mainCache.get("a"); ok {
+		t.Fatalf("load started before InvalidateTag should not be cached")
+	}
+	if _, ok := g.mainCache.get("b"); !ok {
+		t.Fatalf("load with other tags should be cached")
+	}
+}
+
+// 测试条目被淘汰时标签索引同步清理
+func TestTagIndexEviction(t *testing.T) {
+	g := NewGroup("invalidate-evict", 64, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		g.Set(ctx, fmt.Sprintf("key%d", i), []byte("0123456789"), time.Time{}, "tag", fmt.Sprintf("t%d", i))
+	}
+	g.Delete(ctx, "key19")
+
+	c := &g.mainCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.keyTags) != c.lru.Len() || len(c.tags["tag"]) != c.lru.Len() {
+		t.Fatalf("index out of sync: %d keys tagged, %d in tag, %d cached", len(c.keyTags), len(c.tags["tag"]), c.lru.Len())
+	}
+	if len(c.tags) != c.lru.Len()+1 {
+		t.Fatalf("empty tags should be dropped, %d tags for %d keys", len(c.tags), c.lru.Len())
+	}
+}
+
+func TestInvalidatePrefix(t *testing.T) {
+	g := NewGroup("invalidate-prefix", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		g.Set(ctx, key, []byte("v"), time.Time{})
+	}
+	if n, err := g.InvalidatePrefix(ctx, "user:"); err != nil || n != 2 {
+		t.Fatalf("InvalidatePrefix = %d, %v", n, err)
+	}
+	if keys := g.Keys(); len(keys) != 1 || keys[0] != "order:1" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if _, err := g.InvalidatePrefix(ctx, ""); err == nil {
+		t.Fatalf("empty prefix should be rejected")
+	}
+}
+
+// recordingPeer 记录收到的Invalidate请求
+type recordingPeer struct {
+	fakeFetcher
+	mu    sync.Mutex
+	calls []string
+	err   error
+}
+
+func (p *recordingPeer) Invalidate(ctx context.Context, group string, tag string, prefix string) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, tag+"|"+prefix)
+	if p.err != nil {
+		return 0, p.err
+	}
+	return 1, nil
+}
+
+type broadcastPeers []*recordingPeer
+
+func (p broadcastPeers) PickPeer(key string) (Fetcher, bool) { return nil, false }
+
+func (p broadcastPeers) GetAll() []Fetcher {
+	all := make([]Fetcher, len(p))
+	for i, peer := range p {
+		all[i] = peer
+	}
+	return all
+}
+
+// 测试失效广播给所有节点，部分节点失败时返回错误，其它节点仍然生效
+func TestInvalidateBroadcast(t *testing.T) {
+	g := NewGroup("invalidate-broadcast", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	down := errors.New("peer down")
+	peers := broadcastPeers{{}, {}, {err: down}}
+	g.RegisterPeers(peers)
+	g.Set(context.Background(), "p:1", []byte("v"), time.Time{})
+
+	n, err := g.InvalidatePrefix(context.Background(), "p:")
+	if !errors.Is(err, down) || n != 3 {
+		t.Fatalf("InvalidatePrefix = %d, %v", n, err)
+	}
+	for i, peer := range peers {
+		if len(peer.calls) != 1 || peer.calls[0] != "|p:" {
+			t.Fatalf("peer %d calls %v", i, peer.calls)
+		}
+	}
+}
+
+// 测试Invalidate经过gRPC在远程节点上执行
+func TestInvalidateRemote(t *testing.T) {
+	g := NewGroup("invalidate-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "invalidate-remote", "key", []byte("v"), time.Time{}, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Invalidate(ctx, "invalidate-remote", "t1", ""); err != nil || n != 1 {
+		t.Fatalf("remote Invalidate = %d, %v", n, err)
+	}
+	if _, ok := g.mainCache.get("key"); ok {
+		t.Fatalf("key should be invalidated")
+	}
+	if _, err := c.Invalidate(ctx, "invalidate-remote", "t1", "p"); err == nil {
+		t.Fatalf("tag and prefix together should be rejected")
+	}
+}
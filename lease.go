@@ -0,0 +1,273 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+租约(lease)参考memcached的做法，解决两个问题：
+- 陈旧写入：慢的加载拿到的是旧数据，如果它晚于Set/Delete写入缓存，就会覆盖新的数据
+- 惊群：未命中时只有一个调用方拿到租约去加载，其它调用方等待重试或者直接使用旧值
+拿到租约之后key被Delete或者Set，租约就会失效，之后带着这个租约的写入会被拒绝
+租约由key所属的节点发放和校验
+本节点从getter加载时使用内部的填充租约，与调用方持有的租约互不影响，同样被Set/Delete作废
+*/
+
+var (
+	// ErrLeaseWait 其它调用方正在持有租约，稍后重试
+	ErrLeaseWait = errors.New("geecache: lease held by another caller")
+	// ErrLeaseInvalid 租约过期后被其它调用方取代，或者被之后的Set/Delete作废
+	ErrLeaseInvalid = errors.New("geecache: lease invalid")
+)
+
+// defaultLeaseTTL 租约的默认有效期，持有者在这段时间内没有写入则其它调用方可以重新获取
+const defaultLeaseTTL = 10 * time.Second
+
+// WithLeaseTTL 设置租约的有效期，为0时使用默认的10秒
+func WithLeaseTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.leaseTTL = ttl
+	}
+}
+
+// Lease 是LeaseGet的结果
+type Lease struct {
+	Value ByteView
+	Hit   bool   //  Value是未过期的缓存值
+	Stale bool   //  Value是已过期的旧值，其它调用方正在持有租约
+	Token uint64 //  不为0时调用方拿到了租约，加载后用SetWithLease写入
+}
+
+// LeaseGet 读取缓存，未命中时为调用方发放租约，不会调用getter
+// 已经有其它调用方持有租约时，有旧值则返回旧值，否则返回ErrLeaseWait
+func (g *Group) LeaseGet(ctx context.Context, key string) (Lease, error) {
+	if key == "" {
+		return Lease{}, fmt.Errorf("key is required")
+	}
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return peer.Lease(ctx, g.name, key)
+		}
+	}
+	return g.leaseLocally(key)
+}
+
+// leaseLocally 在本节点读取缓存或发放租约
+func (g *Group) leaseLocally(key string) (Lease, error) {
+	item, ok := g.mainCache.getItem(key)
+	if ok && !item.stale {
+		return Lease{Value: item.value, Hit: true}, nil
+	}
+	if token := g.leases.grant(key, g.leaseDuration()); token != 0 {
+		return Lease{Token: token}, nil
+	}
+	if ok {
+		return Lease{Value: item.value, Stale: true}, nil
+	}
+	return Lease{}, ErrLeaseWait
+}
+
+// SetWithLease 用LeaseGet拿到的租约写入缓存，租约失效时返回ErrLeaseInvalid
+// 租约只能使用一次
+func (g *Group) SetWithLease(ctx context.Context, key string, value []byte, expir time.Time, token uint64) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if token == 0 {
+		return ErrLeaseInvalid
+	}
+	g.dropCopies(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			return peer.SetWithLease(ctx, g.name, key, value, expir, token)
+		}
+	}
+	return g.setWithLeaseLocally(key, value, expir, token)
+}
+
+// setWithLeaseLocally 校验租约并写入本节点的缓存
+func (g *Group) setWithLeaseLocally(key string, value []byte, expir time.Time, token uint64) error {
+	var (
+		v  ByteView
+		ok bool
+	)
+	err := g.logKey(key, func() bool {
+		if ok = g.leases.release(key, token); ok {
+			g.loader.Forget(key)
+			v = g.populateCache(key, ByteView{b: cloneBytes(value), t: expir}, expir, 0, nil)
+		}
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLeaseInvalid
+	}
+	g.publish(EventSet, key, v.Version())
+	g.dropReplicas(key)
+	return nil
+}
+
+// leaseDuration 返回租约的有效期
+func (g *Group) leaseDuration() time.Duration {
+	if g.leaseTTL > 0 {
+		return g.leaseTTL
+	}
+	return defaultLeaseTTL
+}
+
+// leaseTable 记录每个key当前有效的租约
+type leaseTable struct {
+	mu    sync.Mutex
+	next  uint64
+	m     map[string]leaseEntry
+	fills map[string]uint64 //  本节点加载时的填充租约，不影响m中调用方持有的租约
+
+	//  按标签失效时记录的序号，与租约共用递增的序号，带有这些标签且早于序号发放的填充租约失效
+	//  加载完成之前不知道key的标签，只能在写入时按标签校验
+	tagged   map[string]uint64
+	tagFloor uint64 //  tagged过大时清空，早于tagFloor发放的带标签的填充租约全部失效
+}
+
+type leaseEntry struct {
+	token  uint64
+	expire time.Time
+}
+
+// leaseSweepSize 租约数量达到该值时清理过期的租约，避免持有者不再写入时租约一直占用内存
+const leaseSweepSize = 1024
+
+// grant 为key发放租约，如果已有未过期的租约则返回0
+func (t *leaseTable) grant(key string, ttl time.Duration) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.initLocked()
+	if e, ok := t.m[key]; ok && now.Before(e.expire) {
+		return 0
+	}
+	if len(t.m) >= leaseSweepSize {
+		for k, e := range t.m {
+			if !now.Before(e.expire) {
+				delete(t.m, k)
+			}
+		}
+	}
+	t.m[key] = leaseEntry{token: t.nextLocked(), expire: now.Add(ttl)}
+	return t.m[key].token
+}
+
+// fill 为本节点从getter加载key发放填充租约，替换key之前的填充租约，不影响调用方持有的租约
+// 加载期间key被Set/Delete时填充租约失效，加载到的旧值不再写入缓存
+func (t *leaseTable) fill(key string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.initLocked()
+	t.fills[key] = t.nextLocked()
+	return t.fills[key]
+}
+
+// releaseFill 如果token是key当前的填充租约，则收回并返回true
+// tags是加载到的标签，发放之后其中的标签被失效过时同样返回false
+func (t *leaseTable) releaseFill(key string, token uint64, tags []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.fills[key] != token {
+		return false
+	}
+	delete(t.fills, key)
+	if len(tags) > 0 && token < t.tagFloor {
+		return false
+	}
+	for _, tag := range tags {
+		if t.tagged[tag] > token {
+			return false
+		}
+	}
+	return true
+}
+
+// initLocked 延迟创建租约表，调用方持有t.mu
+func (t *leaseTable) initLocked() {
+	if t.m == nil {
+		t.m = make(map[string]leaseEntry)
+		t.fills = make(map[string]uint64)
+		//  随机的起始值，节点重启之后旧的租约不会恰好有效
+		t.next = uint64(rand.Int63())
+	}
+}
+
+// nextLocked 返回一个新的不为0的租约，调用方持有t.mu
+func (t *leaseTable) nextLocked() uint64 {
+	t.next++
+	if t.next == 0 {
+		t.next++
+	}
+	return t.next
+}
+
+// release 如果token是key当前的租约，则收回租约并返回true
+// 过期但没有被其它调用方替换的租约仍然有效，期间没有发生过Set/Delete
+// 收回之后调用方会写入，之前发起的加载的填充租约随之失效
+func (t *leaseTable) release(key string, token uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.m[key]
+	if !ok || e.token != token {
+		return false
+	}
+	delete(t.m, key)
+	delete(t.fills, key)
+	return true
+}
+
+// invalidate 作废key的租约和填充租约
+func (t *leaseTable) invalidate(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.m, key)
+	delete(t.fills, key)
+}
+
+// invalidateTag 作废之前发放的、加载到的值带有tag标签的填充租约
+func (t *leaseTable) invalidateTag(tag string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.fills) == 0 {
+		//  没有进行中的加载，之前的记录都不会再用到
+		t.tagged = nil
+		return
+	}
+	if len(t.tagged) >= leaseSweepSize {
+		t.tagged = nil
+		t.tagFloor = t.nextLocked()
+		return
+	}
+	if t.tagged == nil {
+		t.tagged = make(map[string]uint64)
+	}
+	t.tagged[tag] = t.nextLocked()
+}
+
+// invalidatePrefix 作废以prefix开头的key的租约和填充租约
+func (t *leaseTable) invalidatePrefix(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.m {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.m, key)
+		}
+	}
+	for key := range t.fills {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.fills, key)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	g := NewGroup("lease", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	ctx := context.Background()
+
+	l, err := g.LeaseGet(ctx, "key")
+	if err != nil || l.Token == 0 || l.Hit {
+		t.Fatalf("first miss should get a lease: %+v, %v", l, err)
+	}
+	if _, err := g.LeaseGet(ctx, "key"); !errors.Is(err, ErrLeaseWait) {
+		t.Fatalf("second miss should wait, got %v", err)
+	}
+	if err := g.SetWithLease(ctx, "key", []byte("v1"), time.Time{}, l.Token); err != nil {
+		t.Fatal(err)
+	}
+	if hit, err := g.LeaseGet(ctx, "key"); err != nil || !hit.Hit || hit.Value.String() != "v1" {
+		t.Fatalf("expected hit v1, got %+v, %v", hit, err)
+	}
+	//  租约只能使用一次
+	if err := g.SetWithLease(ctx, "key", []byte("v2"), time.Time{}, l.Token); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("reused lease should be rejected, got %v", err)
+	}
+
+	//  拿到租约之后的Delete让租约失效
+	l, _ = g.LeaseGet(ctx, "other")
+	g.Delete(ctx, "other")
+	if err := g.SetWithLease(ctx, "other", []byte("old"), time.Time{}, l.Token); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("lease should be invalidated by Delete, got %v", err)
+	}
+	if _, ok := g.mainCache.get("other"); ok {
+		t.Fatalf("stale set should not be cached")
+	}
+}
+
+// 测试普通读取未命中时的加载不会让调用方持有的租约失效
+func TestLeaseSurvivesLoad(t *testing.T) {
+	g := NewGroup("lease-load", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("loaded"), nil
+	}))
+	ctx := context.Background()
+	l, err := g.LeaseGet(ctx, "key")
+	if err != nil || l.Token == 0 {
+		t.Fatalf("expected lease, got %+v, %v", l, err)
+	}
+	if v, err := g.Get("key", time.Time{}); err != nil || v.String() != "loaded" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if err := g.SetWithLease(ctx, "key", []byte("v1"), time.Time{}, l.Token); err != nil {
+		t.Fatalf("lease should survive a read miss, got %v", err)
+	}
+	if v, _ := g.mainCache.get("key"); v.String() != "v1" {
+		t.Fatalf("unexpected value %q", v.String())
+	}
+}
+
+// 测试其它调用方持有租约时返回旧值
+func TestLeaseStale(t *testing.T) {
+	g := NewGroup("lease-stale", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithStaleWhileRevalidate(time.Minute))
+	ctx := context.Background()
+	g.setLocally("key", []byte("old"), time.Now().Add(-time.Second), nil)
+
+	if l, err := g.LeaseGet(ctx, "key"); err != nil || l.Token == 0 {
+		t.Fatalf("expired key should get a lease: %+v, %v", l, err)
+	}
+	if l, err := g.LeaseGet(ctx, "key"); err != nil || !l.Stale || l.Value.String() != "old" {
+		t.Fatalf("expected stale value, got %+v, %v", l, err)
+	}
+}
+
+// 测试加载期间的Set不会被慢的加载覆盖
+func TestLeaseSlowLoader(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("lease-slow", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte("stale"), nil
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Get("key", time.Time{})
+	}()
+	waitFor(t, func() bool {
+		g.leases.mu.Lock()
+		defer g.leases.mu.Unlock()
+		_, ok := g.leases.fills["key"]
+		return ok
+	})
+	if err := g.Set(context.Background(), "key", []byte("fresh"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+	if v, ok := g.mainCache.get("key"); !ok || v.String() != "fresh" {
+		t.Fatalf("slow load overwrote fresh write: %q", v.String())
+	}
+}
+
+// 测试租约经过gRPC在远程节点上发放和校验
+func TestLeaseRemote(t *testing.T) {
+	NewGroup("lease-remote", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	ctx := context.Background()
+
+	l, err := c.Lease(ctx, "lease-remote", "key")
+	if err != nil || l.Token == 0 {
+		t.Fatalf("expected lease, got %+v, %v", l, err)
+	}
+	if _, err := c.Lease(ctx, "lease-remote", "key"); !errors.Is(err, ErrLeaseWait) {
+		t.Fatalf("expected ErrLeaseWait, got %v", err)
+	}
+	if err := c.SetWithLease(ctx, "lease-remote", "key", []byte("v"), time.Time{}, l.Token+1); !errors.Is(err, ErrLeaseInvalid) {
+		t.Fatalf("expected ErrLeaseInvalid, got %v", err)
+	}
+	if err := c.SetWithLease(ctx, "lease-remote", "key", []byte("v"), time.Time{}, l.Token); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := c.Lease(ctx, "lease-remote", "key"); err != nil || !l.Hit || l.Value.String() != "v" {
+		t.Fatalf("expected hit, got %+v, %v", l, err)
+	}
+}
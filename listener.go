@@ -0,0 +1,95 @@
+package DistributedCache
+
+import (
+	"errors"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrServerClosed 协议前端的Serve在Close之后返回
+var ErrServerClosed = errors.New("geecache: server closed")
+
+// connServer 是各协议前端共用的连接管理：接受连接、跟踪活跃连接、关闭时等待所有连接退出
+type connServer struct {
+	mu     sync.Mutex
+	lis    net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// serve 在lis上接受连接，每个连接交给handle在单独的goroutine中处理，handle返回后连接被关闭
+// handle中的panic只关闭这个连接，不影响节点上的其它连接
+func (s *connServer) serve(lis net.Listener, handle func(net.Conn)) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		lis.Close()
+		return ErrServerClosed
+	}
+	s.lis = lis
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return ErrServerClosed
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer func() {
+				conn.Close()
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				s.wg.Done()
+			}()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[geecache] %s: panic serving connection: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+				}
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// activeConns 返回当前的连接数
+func (s *connServer) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// close 停止监听并关闭所有连接
+func (s *connServer) close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.lis != nil {
+		err = s.lis.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
@@ -28,7 +28,33 @@ type Cache struct {
 	ll       *list.List               // 双向链表存储缓存数据
 	cache    map[string]*list.Element // 字典，值是双向链表中对应节点的指针
 	// 可选，清理条目时调用
-	onEvicted func(key string, value Value) // 某条记录被移除时的回调函数，可以为 nil
+	onEvicted func(key string, value Value, reason EvictReason) // 某条记录被移除时的回调函数，可以为 nil
+}
+
+// EvictReason 条目被移除的原因
+type EvictReason int
+
+const (
+	// EvictCapacity 超过maxBytes被淘汰
+	EvictCapacity EvictReason = iota
+	// EvictExpired 硬过期之后被清理
+	// 过期的条目不会被主动清理，只有在被读取或者被淘汰时才会移除并触发回调，
+	// 因此回调的时间可能远晚于过期时间，从未被访问的过期条目在淘汰时才收到EvictExpired
+	EvictExpired
+	// EvictDeleted 通过Remove删除
+	EvictDeleted
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	}
+	return "unknown"
 }
 
 //	键值对entry，双向链表节点的数据类型，在链表中仍需要保存每个值对应的key
@@ -37,7 +63,45 @@ type Cache struct {
 type entry struct {
 	key    string
 	value  Value
-	expire time.Time
+	expire time.Time // 软过期时间，超过后条目是陈旧的
+	stale  time.Time // 硬过期时间，超过后条目被删除，零值表示与expire相同
+	cost   time.Duration
+	mode   ExpireMode
+	ttl    time.Duration // 滑动过期时每次命中延长的时长
+}
+
+// hardExpire 返回条目被删除的时间
+func (e *entry) hardExpire() time.Time {
+	if e.stale.IsZero() {
+		return e.expire
+	}
+	return e.stale
+}
+
+// ExpireMode 条目的过期方式
+type ExpireMode int
+
+const (
+	// ExpireAbsolute 在Expire时刻过期，读取不影响过期时间
+	ExpireAbsolute ExpireMode = iota
+	// ExpireSliding 每次Get命中都把过期时间延长到 Now()+TTL
+	ExpireSliding
+)
+
+// Item 是带过期信息的缓存条目
+// 在Expire和StaleUntil之间，Get认为条目已过期，GetItem仍然可以取到它
+type Item struct {
+	Value      Value
+	Expire     time.Time     // 软过期时间，零值表示永不过期
+	StaleUntil time.Time     // 硬过期时间，零值表示与Expire相同
+	Cost       time.Duration // 加载该值的耗时，用于提前刷新
+	Mode       ExpireMode    // 过期方式
+	TTL        time.Duration // 滑动过期的时长，Mode为ExpireSliding时有效
+}
+
+// Stale 条目在now时刻是否已经软过期
+func (it Item) Stale(now time.Time) bool {
+	return !it.Expire.IsZero() && it.Expire.Before(now)
 }
 
 // 为了通用性，我们允许值实现了Value接口的任意类型
@@ -48,6 +112,15 @@ type Value interface {
 
 // 实例化Cache
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	var fn func(string, Value, EvictReason)
+	if onEvicted != nil {
+		fn = func(key string, value Value, _ EvictReason) { onEvicted(key, value) }
+	}
+	return NewWithReason(maxBytes, fn)
+}
+
+// NewWithReason 与New相同，回调函数额外接收条目被移除的原因
+func NewWithReason(maxBytes int64, onEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes: maxBytes,
 		// nBytes:    0,
@@ -64,42 +137,75 @@ Get方法，通过key在cache中寻找数据，如果查找到就将节点移动
 并且返回数据
 */
 func (c *Cache) Get(key string) (value Value, ok bool) {
+	it, ok := c.GetItem(key)
+	if !ok || it.Stale(c.Now()) {
+		return nil, false
+	}
+	return it.Value, true
+}
+
+// GetItem 与Get相同，但是会返回已经软过期、尚未硬过期的条目，由调用方判断是否陈旧
+func (c *Cache) GetItem(key string) (it Item, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		// 已存在，节点移动到队头。（队尾的节点会被优先淘汰）
 		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
-		//  如果条目已过期，请将其从缓存中删除
-		if !kv.expire.IsZero() && kv.expire.Before(c.Now()) {
-			c.removeElement(ele)
-			return nil, false
+		//  如果条目已硬过期，请将其从缓存中删除
+		now := c.Now()
+		if hard := kv.hardExpire(); !hard.IsZero() && hard.Before(now) {
+			c.removeElement(ele, EvictExpired)
+			return Item{}, false
+		}
+		//  滑动过期：未过期的条目被读取后延长过期时间，陈旧时间窗口随之平移
+		if kv.mode == ExpireSliding && kv.ttl > 0 && !kv.expire.Before(now) {
+			expire := now.Add(kv.ttl)
+			if !kv.stale.IsZero() {
+				kv.stale = kv.stale.Add(expire.Sub(kv.expire))
+			}
+			kv.expire = expire
 		}
-		return kv.value, true
+		return Item{Value: kv.value, Expire: kv.expire, StaleUntil: kv.stale, Cost: kv.cost, Mode: kv.mode, TTL: kv.ttl}, true
+	}
+	return
+}
+
+// Peek 返回key对应的条目，不移动节点、不延长滑动过期时间，也不清理过期的条目
+func (c *Cache) Peek(key string) (it Item, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		return Item{Value: kv.value, Expire: kv.expire, StaleUntil: kv.stale, Cost: kv.cost, Mode: kv.mode, TTL: kv.ttl}, true
 	}
 	return
 }
 
 // 删除一个节点
-func (c *Cache) removeElement(e *list.Element) {
+func (c *Cache) removeElement(e *list.Element, reason EvictReason) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)
+	c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
 	if c.onEvicted != nil {
-		c.onEvicted(kv.key, kv.value)
+		c.onEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// Remove 删除指定的key，key不存在时是no-op
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictDeleted)
 	}
 }
 
+// RemoveOldest 淘汰最久未使用的条目，条目已经硬过期时回调收到的原因是EvictExpired
 func (c *Cache) RemoveOldest() {
 	// 取队首节点删除
 	ele := c.ll.Back()
 	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		// 	回调函数
-		if c.onEvicted != nil {
-			c.onEvicted(kv.key, kv.value)
+		reason := EvictCapacity
+		if hard := ele.Value.(*entry).hardExpire(); !hard.IsZero() && hard.Before(c.Now()) {
+			reason = EvictExpired
 		}
+		c.removeElement(ele, reason)
 	}
 }
 
@@ -109,6 +215,12 @@ Add，增加结点方法，先通过key查询，如果key存在直接将结点
 不存在则创建结点&entry
 */
 func (c *Cache) Add(key string, val Value, expir time.Time) {
+	c.AddItem(key, Item{Value: val, Expire: expir})
+}
+
+// AddItem 与Add相同，可以额外指定硬过期时间
+func (c *Cache) AddItem(key string, it Item) {
+	val, expir := it.Value, it.Expire
 	if ele, ok := c.cache[key]; ok {
 		// 已存在，节点移动到队尾。（队首的节点会被优先淘汰）
 		c.ll.MoveToFront(ele)
@@ -117,11 +229,19 @@ func (c *Cache) Add(key string, val Value, expir time.Time) {
 		c.nBytes += int64(val.Len()) - int64(kv.value.Len())
 		kv.value = val
 		kv.expire = expir
+		kv.stale = it.StaleUntil
+		kv.cost = it.Cost
+		kv.mode = it.Mode
+		kv.ttl = it.TTL
 	} else {
 		ele := c.ll.PushFront(&entry{
 			key:    key,
 			value:  val,
 			expire: expir,
+			stale:  it.StaleUntil,
+			cost:   it.Cost,
+			mode:   it.Mode,
+			ttl:    it.TTL,
 		})
 		c.cache[key] = ele
 		c.nBytes += int64(len(key)) + int64(val.Len())
@@ -132,7 +252,43 @@ func (c *Cache) Add(key string, val Value, expir time.Time) {
 	}
 }
 
+// AddItemBack 把key作为最久未使用的条目插入队尾，key已存在时不做任何事
+// 用于恢复比现有条目更旧的数据，超过maxBytes时最先被淘汰的是它自己
+func (c *Cache) AddItemBack(key string, it Item) {
+	if _, ok := c.cache[key]; ok {
+		return
+	}
+	ele := c.ll.PushBack(&entry{
+		key:    key,
+		value:  it.Value,
+		expire: it.Expire,
+		stale:  it.StaleUntil,
+		cost:   it.Cost,
+		mode:   it.Mode,
+		ttl:    it.TTL,
+	})
+	c.cache[key] = ele
+	c.nBytes += int64(len(key)) + int64(it.Value.Len())
+	for c.maxBytes != 0 && c.maxBytes < c.nBytes {
+		c.RemoveOldest()
+	}
+}
+
 // Len Value接口实现
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// Bytes 返回当前已使用的内存大小
+func (c *Cache) Bytes() int64 {
+	return c.nBytes
+}
+
+// Keys 按从新到旧的顺序返回所有key，包括已过期但尚未清理的
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		keys = append(keys, ele.Value.(*entry).key)
+	}
+	return keys
+}
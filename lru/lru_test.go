@@ -80,3 +80,119 @@ func TestAdd(t *testing.T) {
 		t.Fatal("expected 6 but got", lru.nBytes)
 	}
 }
+
+// 测试删除指定key后内存统计正确
+func TestRemove(t *testing.T) {
+	lru := New(int64(1000), nil)
+	lru.Add("key1", String("1234"), expir)
+	lru.Add("key2", String("5678"), expir)
+	lru.Remove("key1")
+	lru.Remove("unknown")
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("Remove key1 failed")
+	}
+	if lru.nBytes != int64(len("key2")+len("5678")) {
+		t.Fatal("expected 8 but got", lru.nBytes)
+	}
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"key2"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+// 测试软过期和硬过期之间Get取不到条目，GetItem可以取到陈旧的条目
+func TestStaleItem(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	lru.AddItem("key1", Item{Value: String("v1"), Expire: now.Add(time.Second), StaleUntil: now.Add(time.Minute), Cost: time.Millisecond})
+
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("key1 should be fresh")
+	}
+	now = now.Add(2 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("Get should not return a stale entry")
+	}
+	if it, ok := lru.GetItem("key1"); !ok || !it.Stale(now) || string(it.Value.(String)) != "v1" || it.Cost != time.Millisecond {
+		t.Fatalf("GetItem should return the stale entry, got %+v %v", it, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := lru.GetItem("key1"); ok || lru.Len() != 0 {
+		t.Fatalf("key1 should be removed after StaleUntil")
+	}
+}
+
+// 测试滑动过期：每次命中都延长过期时间
+func TestSlidingExpire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lru := New(int64(0), nil)
+	lru.Now = func() time.Time { return now }
+	lru.AddItem("key1", Item{Value: String("v1"), Expire: now.Add(10 * time.Second), Mode: ExpireSliding, TTL: 10 * time.Second})
+	lru.AddItem("key2", Item{Value: String("v2"), Expire: now.Add(10 * time.Second)})
+
+	for i := 0; i < 5; i++ {
+		now = now.Add(8 * time.Second)
+		if _, ok := lru.Get("key1"); !ok {
+			t.Fatalf("key1 should be extended by Get, round %d", i)
+		}
+	}
+	if it, _ := lru.GetItem("key1"); !it.Expire.Equal(now.Add(10 * time.Second)) {
+		t.Fatalf("unexpected expire %v", it.Expire)
+	}
+	if _, ok := lru.Get("key2"); ok {
+		t.Fatalf("key2 uses absolute expiration and should be expired")
+	}
+	now = now.Add(11 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should expire when not read within TTL")
+	}
+}
+
+// 测试回调函数收到条目被移除的原因
+func TestEvictReason(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	lru := NewWithReason(int64(len("key1v1key2v2")), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	now := time.Now()
+	lru.Now = func() time.Time { return now }
+	lru.Add("key1", String("v1"), expir)
+	lru.Add("key2", String("v2"), now.Add(time.Second))
+	lru.Add("key3", String("v3"), expir)
+	lru.Remove("key3")
+	now = now.Add(2 * time.Second)
+	lru.Get("key2")
+
+	expect := map[string]EvictReason{"key1": EvictCapacity, "key2": EvictExpired, "key3": EvictDeleted}
+	if !reflect.DeepEqual(expect, reasons) {
+		t.Fatalf("unexpected reasons %v", reasons)
+	}
+
+	//  已经硬过期但从未被读取的条目在淘汰时同样报告为过期
+	lru.Add("key4", String("v4"), now.Add(time.Second))
+	now = now.Add(2 * time.Second)
+	lru.Add("key5", String("v5"), expir)
+	lru.Add("key6", String("v6"), expir)
+	if reasons["key4"] != EvictExpired || len(reasons) != 4 {
+		t.Fatalf("unexpected reasons %v", reasons)
+	}
+}
+
+// 测试AddItemBack插入到队尾，超过容量时被淘汰的是它自己
+func TestAddItemBack(t *testing.T) {
+	lru := New(int64(len("key1v1key2v2")), nil)
+	lru.Add("key1", String("v1"), expir)
+	lru.AddItemBack("key2", Item{Value: String("v2")})
+	lru.AddItemBack("key1", Item{Value: String("xx")})
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"key1", "key2"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if v, _ := lru.Get("key1"); string(v.(String)) != "v1" {
+		t.Fatalf("existing key should not be replaced, got %v", v)
+	}
+	lru.AddItemBack("key3", Item{Value: String("v3")})
+	if _, ok := lru.Get("key3"); ok || lru.Len() != 2 {
+		t.Fatalf("key3 should be evicted right away")
+	}
+}
@@ -0,0 +1,873 @@
+package DistributedCache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+memcache 模块实现了memcached的文本协议和二进制协议，使memcached客户端可以直接访问缓存
+所有key都映射到同一个默认Group，请求经过Group处理，包括转发到key所属的节点
+支持的命令：get、gets、set、add、replace、delete、touch、incr、decr、stats、version、quit
+
+连接的第一个字节为0x80时按二进制协议处理，否则按文本协议处理
+客户端的flags不会被保存，读取时总是返回0
+*/
+
+const (
+	memcacheVersion = "1.6.21"
+	// memcacheMaxKeyLen key的最大长度，与memcached一致
+	memcacheMaxKeyLen = 250
+	// memcacheMaxValueBytes 单个value的最大长度，与memcached默认的item_size_max一致
+	memcacheMaxValueBytes = 1 << 20
+	// memcacheRelativeExptime exptime不超过30天时表示相对时间，否则是unix时间戳
+	memcacheRelativeExptime = 60 * 60 * 24 * 30
+	// memcacheMaxLine 文本协议单行的最大长度
+	memcacheMaxLine = 64 << 10
+)
+
+var errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+
+// memcacheCasRetries decr版本号冲突时的最大重试次数
+const memcacheCasRetries = 16
+
+// memcacheStats 协议层的指标，Group相关的指标来自Group.Stats
+type memcacheStats struct {
+	TotalConns AtomicInt
+	CmdGet     AtomicInt
+	CmdSet     AtomicInt
+	CmdTouch   AtomicInt
+	GetHits    AtomicInt
+	GetMisses  AtomicInt
+}
+
+// MemcacheServer memcached协议前端
+type MemcacheServer struct {
+	group   string
+	srv     connServer
+	started time.Time
+	stats   memcacheStats
+}
+
+// NewMemcacheServer 创建memcached协议前端，所有key都在group中读写
+func NewMemcacheServer(group string) *MemcacheServer {
+	return &MemcacheServer{group: group, started: time.Now()}
+}
+
+// ListenAndServe 监听addr并处理连接
+func (s *MemcacheServer) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve 在lis上接受连接，直到Close后返回ErrServerClosed
+func (s *MemcacheServer) Serve(lis net.Listener) error {
+	return s.srv.serve(lis, s.serveConn)
+}
+
+// Close 停止监听并关闭所有连接
+func (s *MemcacheServer) Close() error {
+	return s.srv.close()
+}
+
+// memcacheConn 单个客户端连接
+type memcacheConn struct {
+	s   *MemcacheServer
+	ctx context.Context
+	r   *bufio.Reader
+	w   *bufio.Writer
+}
+
+func (s *MemcacheServer) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.stats.TotalConns.Add(1)
+
+	c := &memcacheConn{
+		s:   s,
+		ctx: ctx,
+		r:   bufio.NewReaderSize(conn, memcacheMaxLine),
+		w:   bufio.NewWriter(conn),
+	}
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == memcacheReqMagic {
+		err = c.serveBinary()
+	} else {
+		err = c.serveText()
+	}
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		log.Printf("[geecache_memcache] %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// flush 管道中还有未处理的请求时先不flush，攒到一起写回
+func (c *memcacheConn) flush() error {
+	if c.r.Buffered() > 0 {
+		return nil
+	}
+	return c.w.Flush()
+}
+
+func (c *memcacheConn) groupOf() (*Group, error) {
+	g := GetGroup(c.s.group)
+	if g == nil {
+		return nil, fmt.Errorf("no such group %s", c.s.group)
+	}
+	return g, nil
+}
+
+// memcacheExpiry 按memcached的规则解析exptime
+// 0表示永不过期，负数表示立即过期，不超过30天为相对秒数，否则为unix时间戳
+func memcacheExpiry(exptime int64) (expir time.Time, expired bool) {
+	switch {
+	case exptime == 0:
+		return time.Time{}, false
+	case exptime < 0:
+		return time.Time{}, true
+	case exptime <= memcacheRelativeExptime:
+		return time.Now().Add(time.Duration(exptime) * time.Second), false
+	default:
+		expir = time.Unix(exptime, 0)
+		return expir, !expir.After(time.Now())
+	}
+}
+
+// casOf 返回gets的cas，即值的版本号，没有版本号时用value的哈希代替
+func casOf(v ByteView) uint64 {
+	if ver := v.Version(); ver != 0 {
+		return ver
+	}
+	h := fnv.New64a()
+	h.Write(v.b)
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+func validMemcacheKey(key string) bool {
+	if len(key) == 0 || len(key) > memcacheMaxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// get 通过Group读取key，不存在时ok为false
+func (c *memcacheConn) get(key string) (view ByteView, ok bool, err error) {
+	g, err := c.groupOf()
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	c.s.stats.CmdGet.Add(1)
+	view, err = g.GetContext(c.ctx, key, time.Time{})
+	if errors.Is(err, ErrNotFound) {
+		c.s.stats.GetMisses.Add(1)
+		return ByteView{}, false, nil
+	}
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	c.s.stats.GetHits.Add(1)
+	return view, true, nil
+}
+
+// store 实现set、add、replace
+// add和replace的存在性以所属节点的缓存为准，不调用Getter加载，检查和写入由CompareAndSet在所属节点上原子地完成
+func (c *memcacheConn) store(cmd, key string, value []byte, exptime int64) (stored bool, err error) {
+	g, err := c.groupOf()
+	if err != nil {
+		return false, err
+	}
+	c.s.stats.CmdSet.Add(1)
+	switch cmd {
+	case "add":
+		//  版本号0表示只在key不存在时写入
+		_, err := g.CompareAndSet(c.ctx, key, value, 0, memcacheTTL(exptime))
+		if errors.Is(err, ErrVersionConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	case "replace":
+		view, err := g.Peek(c.ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		//  期间key被删除时不写入，被其它写入修改时以新的版本号重试
+		ver := view.Version()
+		for i := 0; i < memcacheCasRetries; i++ {
+			_, err = g.CompareAndSet(c.ctx, key, value, ver, memcacheTTL(exptime))
+			var conflict *VersionConflictError
+			if !errors.As(err, &conflict) {
+				return err == nil, err
+			}
+			if conflict.Current == 0 {
+				return false, nil
+			}
+			ver = conflict.Current
+		}
+		return false, fmt.Errorf("too much contention on %s", key)
+	}
+	expir, expired := memcacheExpiry(exptime)
+	if expired {
+		//  写入一个已过期的值等同于删除
+		return true, g.Delete(c.ctx, key)
+	}
+	return true, g.Set(c.ctx, key, value, expir)
+}
+
+// memcacheTTL 把exptime转换为CompareAndSet的ttl，已过期时写入之后立即过期
+func memcacheTTL(exptime int64) time.Duration {
+	expir, expired := memcacheExpiry(exptime)
+	switch {
+	case expired:
+		return time.Nanosecond
+	case expir.IsZero():
+		return 0
+	}
+	if ttl := time.Until(expir); ttl > 0 {
+		return ttl
+	}
+	return time.Nanosecond
+}
+
+// cas 只有key当前的版本号等于unique时才写入，结果为STORED、EXISTS或NOT_FOUND
+func (c *memcacheConn) cas(key string, value []byte, exptime int64, unique uint64) (result string, err error) {
+	g, err := c.groupOf()
+	if err != nil {
+		return "", err
+	}
+	c.s.stats.CmdSet.Add(1)
+	_, err = g.CompareAndSet(c.ctx, key, value, unique, memcacheTTL(exptime))
+	var conflict *VersionConflictError
+	switch {
+	case errors.As(err, &conflict) && conflict.Current == 0:
+		return "NOT_FOUND", nil
+	case conflict != nil:
+		return "EXISTS", nil
+	case err != nil:
+		return "", err
+	}
+	return "STORED", nil
+}
+
+// touch 只更新过期时间，用CompareAndSet写回，期间key被修改时重新读取
+func (c *memcacheConn) touch(key string, exptime int64) (found bool, err error) {
+	c.s.stats.CmdTouch.Add(1)
+	g, err := c.groupOf()
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < memcacheCasRetries; i++ {
+		view, err := g.Peek(c.ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, expired := memcacheExpiry(exptime); expired {
+			return true, g.Delete(c.ctx, key)
+		}
+		_, err = g.CompareAndSet(c.ctx, key, view.b, view.Version(), memcacheTTL(exptime))
+		if !errors.Is(err, ErrVersionConflict) {
+			return true, err
+		}
+	}
+	return true, fmt.Errorf("too much contention on %s", key)
+}
+
+// incr 实现incr和decr，incr由Group.Incr在所属节点上原子地完成
+// decr最小为0，Incr无法表达这个下限，因此用CompareAndSet实现，版本号冲突时重试
+// key是否存在以所属节点的缓存为准，不调用Getter加载
+func (c *memcacheConn) incr(key string, delta uint64, decr bool) (n uint64, found bool, err error) {
+	g, err := c.groupOf()
+	if err != nil {
+		return 0, false, err
+	}
+	if !decr {
+		if _, err := g.Peek(c.ctx, key); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				err = nil
+			}
+			return 0, false, err
+		}
+		if delta > math.MaxInt64 {
+			return 0, true, errNonNumeric
+		}
+		v, err := g.Incr(c.ctx, key, int64(delta), 0)
+		if errors.Is(err, ErrNotInteger) || errors.Is(err, ErrIncrOverflow) {
+			return 0, true, errNonNumeric
+		}
+		return uint64(v), true, err
+	}
+	for i := 0; i < memcacheCasRetries; i++ {
+		view, err := g.Peek(c.ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, true, err
+		}
+		n, err = strconv.ParseUint(view.String(), 10, 64)
+		if err != nil {
+			return 0, true, errNonNumeric
+		}
+		if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		//  过期时间保持不变
+		var ttl time.Duration
+		if !view.Expire().IsZero() {
+			if ttl = time.Until(view.Expire()); ttl <= 0 {
+				continue
+			}
+		}
+		_, err = g.CompareAndSet(c.ctx, key, []byte(strconv.FormatUint(n, 10)), view.Version(), ttl)
+		if !errors.Is(err, ErrVersionConflict) {
+			return n, true, err
+		}
+	}
+	return 0, true, fmt.Errorf("too much contention on %s", key)
+}
+
+func (c *memcacheConn) delete(key string) error {
+	g, err := c.groupOf()
+	if err != nil {
+		return err
+	}
+	return g.Delete(c.ctx, key)
+}
+
+// statList stats命令的返回内容
+func (c *memcacheConn) statList() [][2]string {
+	s := c.s
+	stats := [][2]string{
+		{"pid", strconv.Itoa(os.Getpid())},
+		{"uptime", strconv.FormatInt(int64(time.Since(s.started)/time.Second), 10)},
+		{"time", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"version", memcacheVersion},
+		{"curr_connections", strconv.Itoa(s.srv.activeConns())},
+		{"total_connections", s.stats.TotalConns.String()},
+		{"cmd_get", s.stats.CmdGet.String()},
+		{"cmd_set", s.stats.CmdSet.String()},
+		{"cmd_touch", s.stats.CmdTouch.String()},
+		{"get_hits", s.stats.GetHits.String()},
+		{"get_misses", s.stats.GetMisses.String()},
+	}
+	if g := GetGroup(s.group); g != nil {
+		st := g.StatsSnapshot()
+		stats = append(stats,
+			[2]string{"curr_items", strconv.FormatInt(st.CacheItems, 10)},
+			[2]string{"bytes", strconv.FormatInt(st.CacheBytes, 10)},
+		)
+	}
+	return stats
+}
+
+/* ---------------- 文本协议 ---------------- */
+
+func (c *memcacheConn) serveText() error {
+	for {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			return c.w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			c.w.WriteString("ERROR\r\n")
+		} else if quit, err := c.execText(fields); err != nil || quit {
+			c.w.Flush()
+			return err
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// execText 执行一条文本命令，返回的error表示连接需要关闭
+func (c *memcacheConn) execText(fields []string) (quit bool, err error) {
+	cmd, args := fields[0], fields[1:]
+	noreply := len(args) > 0 && args[len(args)-1] == "noreply"
+	reply := func(s string) {
+		if !noreply {
+			c.w.WriteString(s + "\r\n")
+		}
+	}
+	if noreply {
+		args = args[:len(args)-1]
+	}
+	var keys []string
+	switch cmd {
+	case "get", "gets":
+		keys = args
+	case "set", "add", "replace", "cas", "delete", "touch", "incr", "decr":
+		if len(args) > 0 {
+			keys = args[:1]
+		}
+	}
+	for _, key := range keys {
+		if len(key) > memcacheMaxKeyLen {
+			c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false, nil
+		}
+	}
+
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		for _, key := range args {
+			view, ok, err := c.get(key)
+			if err != nil {
+				c.w.WriteString("SERVER_ERROR " + oneLine(err) + "\r\n")
+				return false, nil
+			}
+			if !ok {
+				continue
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(c.w, "VALUE %s 0 %d %d\r\n", key, view.Len(), casOf(view))
+			} else {
+				fmt.Fprintf(c.w, "VALUE %s 0 %d\r\n", key, view.Len())
+			}
+			c.w.Write(view.b)
+			c.w.WriteString("\r\n")
+		}
+		c.w.WriteString("END\r\n")
+	case "set", "add", "replace", "cas":
+		want := 4
+		if cmd == "cas" {
+			want = 5
+		}
+		if len(args) != want {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		var unique uint64
+		if cmd == "cas" {
+			var err error
+			if unique, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+				c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return false, nil
+			}
+		}
+		_, ferr := strconv.ParseUint(args[1], 10, 32)
+		exptime, eerr := strconv.ParseInt(args[2], 10, 64)
+		size, serr := strconv.Atoi(args[3])
+		if ferr != nil || eerr != nil || serr != nil || size < 0 {
+			c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return false, nil
+		}
+		if size > memcacheMaxValueBytes {
+			//  丢弃过大的数据块，连接可以继续使用
+			if _, err := io.CopyN(io.Discard, c.r, int64(size)+2); err != nil {
+				return false, err
+			}
+			reply("SERVER_ERROR object too large for cache")
+			return false, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return false, err
+		}
+		if !bytes.HasSuffix(data, []byte("\r\n")) {
+			//  数据块比声明的长，丢弃这一行剩下的内容
+			if data[size+1] != '\n' {
+				if _, err := c.r.ReadSlice('\n'); err != nil {
+					return false, err
+				}
+			}
+			c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return false, nil
+		}
+		if cmd == "cas" {
+			result, err := c.cas(args[0], data[:size], exptime, unique)
+			if err != nil {
+				result = "SERVER_ERROR " + oneLine(err)
+			}
+			reply(result)
+			return false, nil
+		}
+		stored, err := c.store(cmd, args[0], data[:size], exptime)
+		switch {
+		case err != nil:
+			reply("SERVER_ERROR " + oneLine(err))
+		case stored:
+			reply("STORED")
+		default:
+			reply("NOT_STORED")
+		}
+	case "delete":
+		//  旧版本客户端可能会发送 delete <key> 0
+		if len(args) != 1 && !(len(args) == 2 && args[1] == "0") {
+			reply("CLIENT_ERROR bad command line format")
+			return false, nil
+		}
+		if err := c.delete(args[0]); err != nil {
+			reply("SERVER_ERROR " + oneLine(err))
+			return false, nil
+		}
+		reply("DELETED")
+	case "touch":
+		if len(args) != 2 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		exptime, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR invalid exptime argument")
+			return false, nil
+		}
+		found, err := c.touch(args[0], exptime)
+		switch {
+		case err != nil:
+			reply("SERVER_ERROR " + oneLine(err))
+		case found:
+			reply("TOUCHED")
+		default:
+			reply("NOT_FOUND")
+		}
+	case "incr", "decr":
+		if len(args) != 2 {
+			c.w.WriteString("ERROR\r\n")
+			return false, nil
+		}
+		delta, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			reply("CLIENT_ERROR invalid numeric delta argument")
+			return false, nil
+		}
+		n, found, err := c.incr(args[0], delta, cmd == "decr")
+		switch {
+		case err == errNonNumeric:
+			reply("CLIENT_ERROR " + err.Error())
+		case err != nil:
+			reply("SERVER_ERROR " + oneLine(err))
+		case !found:
+			reply("NOT_FOUND")
+		default:
+			reply(strconv.FormatUint(n, 10))
+		}
+	case "stats":
+		if len(args) > 0 {
+			//  不支持 stats items、stats slabs 等子命令
+			c.w.WriteString("END\r\n")
+			return false, nil
+		}
+		for _, kv := range c.statList() {
+			c.w.WriteString("STAT " + kv[0] + " " + kv[1] + "\r\n")
+		}
+		c.w.WriteString("END\r\n")
+	case "version":
+		c.w.WriteString("VERSION " + memcacheVersion + "\r\n")
+	case "quit":
+		return true, nil
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return false, nil
+}
+
+// oneLine 去掉错误信息中的换行，避免破坏协议
+func oneLine(err error) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+}
+
+/* ---------------- 二进制协议 ---------------- */
+
+const (
+	memcacheReqMagic  = 0x80
+	memcacheResMagic  = 0x81
+	memcacheHeaderLen = 24
+)
+
+// 二进制协议的操作码
+const (
+	opGet        = 0x00
+	opSet        = 0x01
+	opAdd        = 0x02
+	opReplace    = 0x03
+	opDelete     = 0x04
+	opIncrement  = 0x05
+	opDecrement  = 0x06
+	opQuit       = 0x07
+	opGetQ       = 0x09
+	opNoop       = 0x0a
+	opVersion    = 0x0b
+	opGetK       = 0x0c
+	opGetKQ      = 0x0d
+	opStat       = 0x10
+	opSetQ       = 0x11
+	opAddQ       = 0x12
+	opReplaceQ   = 0x13
+	opDeleteQ    = 0x14
+	opIncrementQ = 0x15
+	opDecrementQ = 0x16
+	opQuitQ      = 0x17
+	opTouch      = 0x1c
+)
+
+// 二进制协议的状态码
+const (
+	statusOK            = 0x00
+	statusKeyNotFound   = 0x01
+	statusKeyExists     = 0x02
+	statusValueTooLarge = 0x03
+	statusInvalidArgs   = 0x04
+	statusNonNumeric    = 0x06
+	statusUnknownCmd    = 0x81
+	statusInternalErr   = 0x84
+)
+
+// memcacheHeader 二进制协议请求和响应共用的24字节头
+type memcacheHeader struct {
+	magic   byte
+	opcode  byte
+	keyLen  uint16
+	extLen  byte
+	status  uint16 //  请求中为vbucket id
+	bodyLen uint32
+	opaque  uint32
+	cas     uint64
+}
+
+func (c *memcacheConn) serveBinary() error {
+	var buf [memcacheHeaderLen]byte
+	for {
+		if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+			return err
+		}
+		req := memcacheHeader{
+			magic:   buf[0],
+			opcode:  buf[1],
+			keyLen:  binary.BigEndian.Uint16(buf[2:]),
+			extLen:  buf[4],
+			bodyLen: binary.BigEndian.Uint32(buf[8:]),
+			opaque:  binary.BigEndian.Uint32(buf[12:]),
+			cas:     binary.BigEndian.Uint64(buf[16:]),
+		}
+		if req.magic != memcacheReqMagic || uint32(req.keyLen)+uint32(req.extLen) > req.bodyLen {
+			return fmt.Errorf("invalid binary request header")
+		}
+		if req.bodyLen > memcacheMaxValueBytes+memcacheMaxLine {
+			return fmt.Errorf("binary request body too large: %d", req.bodyLen)
+		}
+		body := make([]byte, req.bodyLen)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return err
+		}
+		extras := body[:req.extLen]
+		key := string(body[req.extLen : uint32(req.extLen)+uint32(req.keyLen)])
+		value := body[uint32(req.extLen)+uint32(req.keyLen):]
+		if quit := c.execBinary(req, extras, key, value); quit {
+			return c.w.Flush()
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// writeBinary 写入一个二进制协议的响应
+func (c *memcacheConn) writeBinary(req memcacheHeader, status uint16, extras []byte, key string, value []byte, cas uint64) {
+	var buf [memcacheHeaderLen]byte
+	buf[0] = memcacheResMagic
+	buf[1] = req.opcode
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(key)))
+	buf[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(buf[6:], status)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(buf[12:], req.opaque)
+	binary.BigEndian.PutUint64(buf[16:], cas)
+	c.w.Write(buf[:])
+	c.w.Write(extras)
+	c.w.WriteString(key)
+	c.w.Write(value)
+}
+
+func (c *memcacheConn) writeBinaryError(req memcacheHeader, status uint16, msg string) {
+	c.writeBinary(req, status, nil, "", []byte(msg), 0)
+}
+
+// execBinary 执行一条二进制命令，带Q的命令成功时不返回响应
+func (c *memcacheConn) execBinary(req memcacheHeader, extras []byte, key string, value []byte) (quit bool) {
+	op := req.opcode
+	quiet := false
+	switch op {
+	case opGetQ, opGetKQ, opSetQ, opAddQ, opReplaceQ, opDeleteQ, opIncrementQ, opDecrementQ, opQuitQ:
+		quiet = true
+	}
+	ok := func(extras []byte, key string, value []byte, cas uint64) {
+		if !quiet {
+			c.writeBinary(req, statusOK, extras, key, value, cas)
+		}
+	}
+	needKey := false
+	switch op {
+	case opGet, opGetQ, opGetK, opGetKQ, opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ,
+		opDelete, opDeleteQ, opIncrement, opIncrementQ, opDecrement, opDecrementQ, opTouch:
+		needKey = true
+	}
+	if needKey && !validMemcacheKey(key) {
+		c.writeBinaryError(req, statusInvalidArgs, "Invalid arguments")
+		return false
+	}
+
+	switch op {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		view, found, err := c.get(key)
+		if err != nil {
+			c.writeBinaryError(req, statusInternalErr, err.Error())
+			return false
+		}
+		if !found {
+			//  GetQ和GetKQ未命中时不返回响应
+			if !quiet {
+				c.writeBinaryError(req, statusKeyNotFound, "Not found")
+			}
+			return false
+		}
+		var retKey string
+		if op == opGetK || op == opGetKQ {
+			retKey = key
+		}
+		c.writeBinary(req, statusOK, make([]byte, 4), retKey, view.b, casOf(view))
+	case opSet, opSetQ, opAdd, opAddQ, opReplace, opReplaceQ:
+		if len(extras) != 8 {
+			c.writeBinaryError(req, statusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		if len(value) > memcacheMaxValueBytes {
+			c.writeBinaryError(req, statusValueTooLarge, "Too large")
+			return false
+		}
+		cmd := map[byte]string{opSet: "set", opSetQ: "set", opAdd: "add", opAddQ: "add", opReplace: "replace", opReplaceQ: "replace"}[op]
+		exptime := int64(binary.BigEndian.Uint32(extras[4:]))
+		//  set和replace携带cas时只有版本号一致才写入
+		if req.cas != 0 && cmd != "add" {
+			result, err := c.cas(key, value, exptime, req.cas)
+			switch {
+			case err != nil:
+				c.writeBinaryError(req, statusInternalErr, err.Error())
+			case result == "STORED":
+				ok(nil, "", nil, 0)
+			case result == "EXISTS":
+				c.writeBinaryError(req, statusKeyExists, "Data exists for key.")
+			default:
+				c.writeBinaryError(req, statusKeyNotFound, "Not found")
+			}
+			return false
+		}
+		stored, err := c.store(cmd, key, value, exptime)
+		switch {
+		case err != nil:
+			c.writeBinaryError(req, statusInternalErr, err.Error())
+		case stored:
+			ok(nil, "", nil, 0)
+		case cmd == "add":
+			c.writeBinaryError(req, statusKeyExists, "Data exists for key.")
+		default:
+			c.writeBinaryError(req, statusKeyNotFound, "Not found")
+		}
+	case opDelete, opDeleteQ:
+		if err := c.delete(key); err != nil {
+			c.writeBinaryError(req, statusInternalErr, err.Error())
+			return false
+		}
+		ok(nil, "", nil, 0)
+	case opIncrement, opIncrementQ, opDecrement, opDecrementQ:
+		if len(extras) != 20 {
+			c.writeBinaryError(req, statusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		delta := binary.BigEndian.Uint64(extras[0:])
+		initial := binary.BigEndian.Uint64(extras[8:])
+		exptime := binary.BigEndian.Uint32(extras[16:])
+		n, found, err := c.incr(key, delta, op == opDecrement || op == opDecrementQ)
+		if err == nil && !found {
+			//  过期时间为0xffffffff时key不存在返回错误，否则写入初始值
+			if exptime == 0xffffffff {
+				c.writeBinaryError(req, statusKeyNotFound, "Not found")
+				return false
+			}
+			n = initial
+			_, err = c.store("set", key, []byte(strconv.FormatUint(n, 10)), int64(exptime))
+		}
+		switch {
+		case err == errNonNumeric:
+			c.writeBinaryError(req, statusNonNumeric, "Non-numeric server-side value for incr or decr")
+		case err != nil:
+			c.writeBinaryError(req, statusInternalErr, err.Error())
+		default:
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], n)
+			ok(nil, "", b[:], 0)
+		}
+	case opTouch:
+		if len(extras) != 4 {
+			c.writeBinaryError(req, statusInvalidArgs, "Invalid arguments")
+			return false
+		}
+		found, err := c.touch(key, int64(binary.BigEndian.Uint32(extras)))
+		switch {
+		case err != nil:
+			c.writeBinaryError(req, statusInternalErr, err.Error())
+		case !found:
+			c.writeBinaryError(req, statusKeyNotFound, "Not found")
+		default:
+			ok(nil, "", nil, 0)
+		}
+	case opNoop:
+		c.writeBinary(req, statusOK, nil, "", nil, 0)
+	case opVersion:
+		c.writeBinary(req, statusOK, nil, "", []byte(memcacheVersion), 0)
+	case opStat:
+		//  每个指标一个响应，最后以key为空的响应结束
+		for _, kv := range c.statList() {
+			c.writeBinary(req, statusOK, nil, kv[0], []byte(kv[1]), 0)
+		}
+		c.writeBinary(req, statusOK, nil, "", nil, 0)
+	case opQuit, opQuitQ:
+		ok(nil, "", nil, 0)
+		return true
+	default:
+		c.writeBinaryError(req, statusUnknownCmd, "Unknown command")
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+package DistributedCache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startMemcache(t *testing.T, group string) string {
+	newRESPGroup(group)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMemcacheServer(group)
+	go s.Serve(lis)
+	t.Cleanup(func() { s.Close() })
+	return lis.Addr().String()
+}
+
+func dialMemcache(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func TestMemcacheText(t *testing.T) {
+	conn, r := dialMemcache(t, startMemcache(t, "memcache-text"))
+	// expect 发送命令并读取len(lines)行回复
+	expect := func(cmd string, lines ...string) {
+		t.Helper()
+		if _, err := conn.Write([]byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range lines {
+			got, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: %v", cmd, err)
+			}
+			if got = strings.TrimSuffix(got, "\r\n"); got != want && !(strings.HasSuffix(want, "*") && strings.HasPrefix(got, strings.TrimSuffix(want, "*"))) {
+				t.Fatalf("%q: expect %q, got %q", cmd, want, got)
+			}
+		}
+	}
+
+	expect("get Sam\r\n", "VALUE Sam 0 "+strconv.Itoa(len(db1["Sam"])), db1["Sam"], "END")
+	expect("get unknown\r\n", "END")
+	expect("set k1 0 0 2\r\nv1\r\n", "STORED")
+	expect("get k1 unknown Sam\r\n", "VALUE k1 0 2", "v1", "VALUE Sam 0 *", db1["Sam"], "END")
+	expect("gets k1\r\n", "VALUE k1 0 2 *", "v1", "END")
+	expect("add k1 0 0 1\r\nx\r\n", "NOT_STORED")
+	expect("add k2 0 0 1\r\nx\r\n", "STORED")
+	expect("replace k3 0 0 1\r\nx\r\n", "NOT_STORED")
+	expect("replace k2 0 0 1\r\ny\r\n", "STORED")
+	expect("get k2\r\n", "VALUE k2 0 1", "y", "END")
+
+	expect("set n 0 0 2\r\n10\r\n", "STORED")
+	expect("incr n 5\r\n", "15")
+	expect("decr n 20\r\n", "0")
+	expect("incr k2 1\r\n", "CLIENT_ERROR *")
+	expect("incr unknown 1\r\n", "NOT_FOUND")
+
+	expect("touch k1 100\r\n", "TOUCHED")
+	expect("touch unknown 100\r\n", "NOT_FOUND")
+	expect("delete k1\r\n", "DELETED")
+	expect("get k1\r\n", "END")
+
+	//  负数exptime立即过期，绝对时间戳早于现在也立即过期
+	expect("set k4 0 -1 1\r\nx\r\n", "STORED")
+	expect("get k4\r\n", "END")
+	expect("set k5 0 "+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+" 1\r\nx\r\n", "STORED")
+	expect("get k5\r\n", "VALUE k5 0 1", "x", "END")
+
+	//  noreply不返回任何内容，用version确认连接仍然可用
+	expect("set k6 0 0 1 noreply\r\nz\r\nversion\r\n", "VERSION "+memcacheVersion)
+	expect("get k6\r\n", "VALUE k6 0 1", "z", "END")
+
+	expect("bogus\r\n", "ERROR")
+	expect("set k7 0 0 1\r\nabc\r\n", "CLIENT_ERROR bad data chunk")
+	expect("get "+strings.Repeat("a", memcacheMaxKeyLen+1)+"\r\n", "CLIENT_ERROR bad command line format")
+
+	if _, err := conn.Write([]byte("stats\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	stats := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		f := strings.Fields(line)
+		stats[f[1]] = f[2]
+	}
+	if stats["version"] != memcacheVersion || stats["cmd_set"] == "0" || stats["curr_connections"] != "1" {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+}
+
+func TestMemcacheExpiry(t *testing.T) {
+	if expir, expired := memcacheExpiry(0); !expir.IsZero() || expired {
+		t.Fatal("0 should never expire")
+	}
+	if expir, _ := memcacheExpiry(60); time.Until(expir) <= 59*time.Second || time.Until(expir) > 60*time.Second {
+		t.Fatalf("relative exptime: %v", expir)
+	}
+	abs := time.Now().Add(48 * time.Hour).Unix()
+	if expir, expired := memcacheExpiry(abs); expired || expir.Unix() != abs {
+		t.Fatalf("absolute exptime: %v", expir)
+	}
+	if _, expired := memcacheExpiry(memcacheRelativeExptime + 1); !expired {
+		t.Fatal("timestamp in the past should be expired")
+	}
+}
+
+// binaryRequest 编码一个二进制协议请求
+func binaryRequest(op byte, opaque uint32, extras []byte, key, value string) []byte {
+	buf := make([]byte, memcacheHeaderLen, memcacheHeaderLen+len(extras)+len(key)+len(value))
+	buf[0] = memcacheReqMagic
+	buf[1] = op
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(key)))
+	buf[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(buf[12:], opaque)
+	buf = append(buf, extras...)
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+type binaryResponse struct {
+	op     byte
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  string
+}
+
+func readBinaryResponse(t *testing.T, r io.Reader) binaryResponse {
+	t.Helper()
+	var h [memcacheHeaderLen]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	if h[0] != memcacheResMagic {
+		t.Fatalf("bad magic %x", h[0])
+	}
+	body := make([]byte, binary.BigEndian.Uint32(h[8:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	keyLen, extLen := int(binary.BigEndian.Uint16(h[2:])), int(h[4])
+	return binaryResponse{
+		op:     h[1],
+		status: binary.BigEndian.Uint16(h[6:]),
+		opaque: binary.BigEndian.Uint32(h[12:]),
+		cas:    binary.BigEndian.Uint64(h[16:]),
+		extras: body[:extLen],
+		key:    string(body[extLen : extLen+keyLen]),
+		value:  string(body[extLen+keyLen:]),
+	}
+}
+
+func TestMemcacheBinary(t *testing.T) {
+	conn, r := dialMemcache(t, startMemcache(t, "memcache-binary"))
+	send := func(reqs ...[]byte) {
+		for _, req := range reqs {
+			if _, err := conn.Write(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	setExtras := func(exptime uint32) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint32(b[4:], exptime)
+		return b
+	}
+
+	send(binaryRequest(opSet, 1, setExtras(0), "k1", "v1"))
+	if res := readBinaryResponse(t, r); res.status != statusOK || res.opaque != 1 {
+		t.Fatalf("set: %+v", res)
+	}
+	send(binaryRequest(opGetK, 2, nil, "k1", ""))
+	if res := readBinaryResponse(t, r); res.status != statusOK || res.key != "k1" || res.value != "v1" || len(res.extras) != 4 || res.cas == 0 {
+		t.Fatalf("getk: %+v", res)
+	}
+	send(binaryRequest(opGet, 3, nil, "unknown", ""))
+	if res := readBinaryResponse(t, r); res.status != statusKeyNotFound {
+		t.Fatalf("get unknown: %+v", res)
+	}
+	send(binaryRequest(opAdd, 4, setExtras(0), "k1", "x"))
+	if res := readBinaryResponse(t, r); res.status != statusKeyExists {
+		t.Fatalf("add existing: %+v", res)
+	}
+
+	//  带Q的命令：未命中和成功时不返回响应，最后的Noop会刷出之前的结果
+	send(
+		binaryRequest(opGetQ, 10, nil, "unknown", ""),
+		binaryRequest(opSetQ, 11, setExtras(100), "k2", "v2"),
+		binaryRequest(opGetKQ, 12, nil, "k2", ""),
+		binaryRequest(opNoop, 13, nil, "", ""),
+	)
+	if res := readBinaryResponse(t, r); res.opaque != 12 || res.value != "v2" {
+		t.Fatalf("getkq: %+v", res)
+	}
+	if res := readBinaryResponse(t, r); res.op != opNoop || res.opaque != 13 {
+		t.Fatalf("noop: %+v", res)
+	}
+
+	incr := make([]byte, 20)
+	binary.BigEndian.PutUint64(incr[0:], 5)
+	binary.BigEndian.PutUint64(incr[8:], 100)
+	send(binaryRequest(opIncrement, 20, incr, "counter", ""))
+	if res := readBinaryResponse(t, r); res.status != statusOK || binary.BigEndian.Uint64([]byte(res.value)) != 100 {
+		t.Fatalf("incr initial: %+v", res)
+	}
+	send(binaryRequest(opIncrement, 21, incr, "counter", ""))
+	if res := readBinaryResponse(t, r); binary.BigEndian.Uint64([]byte(res.value)) != 105 {
+		t.Fatalf("incr: %+v", res)
+	}
+	send(binaryRequest(opDecrement, 22, incr, "k1", ""))
+	if res := readBinaryResponse(t, r); res.status != statusNonNumeric {
+		t.Fatalf("decr non-numeric: %+v", res)
+	}
+
+	touch := make([]byte, 4)
+	binary.BigEndian.PutUint32(touch, 100)
+	send(binaryRequest(opTouch, 30, touch, "k1", ""), binaryRequest(opDelete, 31, nil, "k1", ""), binaryRequest(opGet, 32, nil, "k1", ""))
+	for _, status := range []uint16{statusOK, statusOK, statusKeyNotFound} {
+		if res := readBinaryResponse(t, r); res.status != status {
+			t.Fatalf("expect status %d, got %+v", status, res)
+		}
+	}
+
+	send(binaryRequest(opVersion, 40, nil, "", ""))
+	if res := readBinaryResponse(t, r); res.value != memcacheVersion {
+		t.Fatalf("version: %+v", res)
+	}
+	send(binaryRequest(opStat, 41, nil, "", ""))
+	for n := 0; ; n++ {
+		res := readBinaryResponse(t, r)
+		if res.key == "" {
+			if n == 0 {
+				t.Fatal("stat returned no values")
+			}
+			break
+		}
+	}
+	send(binaryRequest(0x30, 42, nil, "", ""))
+	if res := readBinaryResponse(t, r); res.status != statusUnknownCmd {
+		t.Fatalf("unknown command: %+v", res)
+	}
+}
+
+func TestMemcacheCas(t *testing.T) {
+	conn, r := dialMemcache(t, startMemcache(t, "memcache-cas"))
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := conn.Write([]byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSuffix(line, "\r\n")
+	}
+
+	if got := send("cas k 0 0 1 1\r\nx\r\n"); got != "NOT_FOUND" {
+		t.Fatalf("cas on missing key: %q", got)
+	}
+	send("set k 0 0 1\r\na\r\n")
+	f := strings.Fields(send("gets k\r\n"))
+	r.ReadString('\n')
+	r.ReadString('\n')
+	unique := f[len(f)-1]
+	if got := send("cas k 0 0 1 " + unique + "\r\nb\r\n"); got != "STORED" {
+		t.Fatalf("cas with current version: %q", got)
+	}
+	if got := send("cas k 0 0 1 " + unique + "\r\nc\r\n"); got != "EXISTS" {
+		t.Fatalf("cas with old version: %q", got)
+	}
+	if got := send("cas k 0 0 1\r\n"); got != "ERROR" {
+		t.Fatalf("cas without unique: %q", got)
+	}
+}
+
+// 测试add、replace、incr和touch只按缓存判断key是否存在，不调用Getter加载，并发的add只有一个成功
+func TestMemcacheAddReplaceNoLoad(t *testing.T) {
+	addr := startMemcache(t, "memcache-noload")
+	conn, r := dialMemcache(t, addr)
+	send := func(cmd string) string {
+		t.Helper()
+		if _, err := conn.Write([]byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSuffix(line, "\r\n")
+	}
+
+	//  Lam和Leslie只存在于Getter中
+	if got := send("replace Lam 0 0 1\r\nx\r\n"); got != "NOT_STORED" {
+		t.Fatalf("replace of a key only in the getter: %q", got)
+	}
+	if got := send("incr Lam 1\r\n"); got != "NOT_FOUND" {
+		t.Fatalf("incr of a key only in the getter: %q", got)
+	}
+	if got := send("touch Lam 100\r\n"); got != "NOT_FOUND" {
+		t.Fatalf("touch of a key only in the getter: %q", got)
+	}
+	if got := send("add Leslie 0 0 1\r\nx\r\n"); got != "STORED" {
+		t.Fatalf("add of a key only in the getter: %q", got)
+	}
+	g := GetGroup("memcache-noload")
+	if g.Stats.Loads.Get() != 0 {
+		t.Fatalf("existence checks should not load, loads %d", g.Stats.Loads.Get())
+	}
+	if v, _ := g.Peek(context.Background(), "Leslie"); v.String() != "x" {
+		t.Fatalf("add should write the new value, got %q", v.String())
+	}
+
+	results := make(chan string, 8)
+	for i := 0; i < cap(results); i++ {
+		go func(i int) {
+			c, r := dialMemcache(t, addr)
+			c.Write([]byte("add race 0 0 1\r\n" + strconv.Itoa(i) + "\r\n"))
+			line, _ := r.ReadString('\n')
+			results <- strings.TrimSuffix(line, "\r\n")
+		}(i)
+	}
+	stored := 0
+	for i := 0; i < cap(results); i++ {
+		if <-results == "STORED" {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Fatalf("exactly one concurrent add should succeed, got %d", stored)
+	}
+}
@@ -1,13 +1,37 @@
 package DistributedCache
 
+import (
+	"context"
+	"time"
+)
+
 //抽象出 2 个接口，PeerPicker 的 `PickPeer()` 方法用于根据传入的 key 选择相应节点 PeerGetter
 //接口 PeerGetter 的 `Get()` 方法用于从对应 group 查找缓存值。PeerGetter 就对应于上述流程中的 HTTP 客户端
 
 type PeerPicker interface {
 	PickPeer(key string) (Fetcher, bool)
+	//  GetAll 返回除本节点以外的所有节点，用于广播
+	GetAll() []Fetcher
 }
 
 type Fetcher interface {
 	//Get(group string, key string) ([]byte, error)
-	Fetch(group string, key string) ([]byte, error)
+	//  ctx 携带超时与追踪上下文
+	Fetch(ctx context.Context, group string, key string) (ByteView, error)
+	//  Set/Delete 在key所属的节点上写入或删除缓存
+	Set(ctx context.Context, group string, key string, value []byte, expir time.Time, tags ...string) error
+	Delete(ctx context.Context, group string, key string) error
+	//  Lease/SetWithLease 在key所属的节点上获取租约和带租约写入
+	Lease(ctx context.Context, group string, key string) (Lease, error)
+	SetWithLease(ctx context.Context, group string, key string, value []byte, expir time.Time, token uint64) error
+	//  CompareAndSet 在key所属的节点上比较版本号并写入，返回写入后的版本号
+	CompareAndSet(ctx context.Context, group string, key string, value []byte, expir time.Time, expected uint64) (uint64, error)
+	//  Incr 在key所属的节点上原子地累加计数器，expir只在创建key时生效
+	Incr(ctx context.Context, group string, key string, delta int64, expir time.Time) (int64, error)
+	//  Invalidate 删除节点上带有tag标签或以prefix开头的所有key，返回删除的数量
+	Invalidate(ctx context.Context, group string, tag string, prefix string) (int64, error)
+	//  Flush 让节点上的Group进入不小于gen的新的代，返回节点进入的代
+	Flush(ctx context.Context, group string, gen uint64) (uint64, error)
+	//  DropCopies 删除节点上key的热点副本
+	DropCopies(ctx context.Context, group string, key string) error
 }
@@ -1,22 +1,49 @@
 package registry
 
 import (
+	"context"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"go.etcd.io/etcd/client/v3/naming/resolver"
 	"google.golang.org/grpc"
+	"sort"
 )
 
 // EtcdDial  向grpc请求一个服务
 // 通过提供一个etcd client和service name即可Connection
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+func EtcdDial(c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return EtcdDialContext(context.Background(), c, service, opts...)
+}
+
+// EtcdDialContext 与EtcdDial相同，ctx用于控制阻塞等待连接的时间
+// opts 追加在默认选项之后，可以用来覆盖默认的非加密传输
+func EtcdDialContext(ctx context.Context, c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c)
 	if err != nil {
 		return nil, err
 	}
-	return grpc.Dial(
-		"etcd://"+service,
+	opts = append([]grpc.DialOption{
 		grpc.WithResolvers(etcdResolver),
 		grpc.WithInsecure(),
 		grpc.WithBlock(),
-	)
+	}, opts...)
+	return grpc.DialContext(ctx, "etcd://"+service, opts...)
+}
+
+// ListEndpoints 列出etcd中service下所有已注册的服务地址
+func ListEndpoints(ctx context.Context, c *clientv3.Client, service string) ([]string, error) {
+	em, err := endpoints.NewManager(c, service)
+	if err != nil {
+		return nil, err
+	}
+	eps, err := em.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(eps))
+	for _, ep := range eps {
+		addrs = append(addrs, ep.Addr)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
 }
@@ -31,8 +31,14 @@ func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr stri
 // 注意 Register将不会return 如果没有error的话
 
 func Register(service string, addr string, stop chan error) error {
+	return RegisterWithConfig(defaultEtcdConfig, service, addr, stop)
+}
+
+// RegisterWithConfig 与Register相同，但使用指定的etcd配置
+// 收到stop信号后会主动revoke租约，使服务立即从etcd中下线
+func RegisterWithConfig(cfg clientv3.Config, service string, addr string, stop chan error) error {
 	//  创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(cfg)
 	if err != nil {
 		return fmt.Errorf("create etcd client failed: %v", err)
 	}
@@ -40,7 +46,7 @@ func Register(service string, addr string, stop chan error) error {
 	//  创建一个租约 配置5S过期
 	resp, err := cli.Grant(context.Background(), 5)
 	if err != nil {
-		fmt.Errorf("create lease failed: %v", err)
+		return fmt.Errorf("create lease failed: %v", err)
 	}
 	leaseId := resp.ID
 	//  注册服务
@@ -61,6 +67,9 @@ func Register(service string, addr string, stop chan error) error {
 			if err != nil {
 				log.Println(err)
 			}
+			if _, rerr := cli.Revoke(context.Background(), leaseId); rerr != nil {
+				log.Printf("[%s] revoke lease failed: %v\n", addr, rerr)
+			}
 			return err
 		case <-cli.Ctx().Done():
 			log.Println("sevice closed")
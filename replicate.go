@@ -0,0 +1,155 @@
+package DistributedCache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+热点key复制：一致性哈希把同一个key的请求都交给所属节点，单个特别热的key会压垮这个节点
+所属节点通过server.Get统计每个key在整个集群的QPS，超过阈值的key在响应中带上hot标记和副本的有效期，
+请求方把值保存在hotCache中，有效期内直接在本地读取
+副本过期之后请求方重新向所属节点读取，同时报告这段时间在副本上读取的次数，
+所属节点据此判断key是否仍然是热点，冷却之后不再标记，请求方的副本过期后也不再续期
+所属节点记录标记过的key，它们被写入或删除时通知所有节点删除副本，副本的有效期是不一致时间的上限
+*/
+
+const (
+	//  请求方最多记录多少个key在副本上的读取次数，超过之后丢弃，只会让所属节点低估QPS
+	maxReplicaHits = 4096
+	//  通知删除副本的超时时间，失败的节点上的副本最晚在有效期之后过期
+	dropReplicasTimeout = time.Second
+)
+
+// WithHotReplication 作为所属节点时，QPS超过qps的key复制到所有请求方，请求方在本地保存ttl时长
+// 未设置WithHotKeys时按默认的个数统计
+func WithHotReplication(qps float64, ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if g.hotKeys == nil {
+			g.hotKeys = newHotKeyTracker(defaultHotKeys)
+		}
+		g.replicateQPS, g.replicateTTL = qps, ttl
+	}
+}
+
+// hitsFetcher 是可以同时报告副本读取次数的Fetcher，client实现了它
+type hitsFetcher interface {
+	fetchWithHits(ctx context.Context, group string, key string, hits int64) (ByteView, error)
+}
+
+// fetch 向所属节点读取key，并报告上次读取之后在副本上读取的次数
+func (g *Group) fetch(ctx context.Context, fetcher Fetcher, key string) (ByteView, error) {
+	if hf, ok := fetcher.(hitsFetcher); ok {
+		return hf.fetchWithHits(ctx, g.name, key, g.replicas.takeHits(key))
+	}
+	return fetcher.Fetch(ctx, g.name, key)
+}
+
+// serveRemote 所属节点处理远程节点的读取，hits是请求方在副本上读取的次数
+// 返回请求方应当保存副本的时长，为0表示不复制
+// 必须在读取值之前调用，读取之后发生的写入才能看到标记并通知副本失效
+func (g *Group) serveRemote(key string, hits int64) time.Duration {
+	if g.hotKeys == nil {
+		return 0
+	}
+	if hits > 0 {
+		if hits > int64(^uint32(0)) {
+			hits = int64(^uint32(0))
+		}
+		g.hotKeys.record(key, uint32(hits))
+	}
+	if g.replicateTTL <= 0 || g.hotKeys.qps(key) < g.replicateQPS {
+		return 0
+	}
+	g.replicas.mark(key, time.Now().Add(g.replicateTTL))
+	g.Stats.HotReplicated.Add(1)
+	return g.replicateTTL
+}
+
+// dropReplicas 所属节点上的key被写入或删除之后，在后台通知所有节点删除它的副本
+// 写入不等待通知完成；失败时只记录日志，副本最晚在有效期之后过期
+func (g *Group) dropReplicas(key string) {
+	if !g.replicas.unmark(key) || g.peers == nil {
+		return
+	}
+	peers := g.peers.GetAll()
+	timeout := dropReplicasTimeout
+	if g.replicateTTL < timeout {
+		timeout = g.replicateTTL
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, peer := range peers {
+			wg.Add(1)
+			go func(peer Fetcher) {
+				defer wg.Done()
+				if err := peer.DropCopies(ctx, g.name, key); err != nil {
+					log.Printf("[geecache] group %s: drop copies of %s: %v", g.name, key, err)
+				}
+			}(peer)
+		}
+		wg.Wait()
+	}()
+}
+
+// replicaTable 记录热点复制的状态
+type replicaTable struct {
+	mu     sync.Mutex
+	marked map[string]time.Time //  所属节点：标记过的key及其副本最晚的过期时间
+	sweep  int                  //  marked达到这个数量时清理已经过期的标记
+	hits   map[string]int64     //  请求方：在副本上读取的次数，下次向所属节点读取时报告
+}
+
+// mark 记录key被标记为热点，副本最晚在until过期
+func (t *replicaTable) mark(key string, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.marked == nil {
+		t.marked = make(map[string]time.Time)
+	}
+	if len(t.marked) >= t.sweep {
+		now := time.Now()
+		for k, u := range t.marked {
+			if !u.After(now) {
+				delete(t.marked, k)
+			}
+		}
+		t.sweep = 2*len(t.marked) + 64
+	}
+	t.marked[key] = until
+}
+
+// unmark 删除key的标记，返回是否可能还有未过期的副本
+func (t *replicaTable) unmark(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.marked[key]
+	delete(t.marked, key)
+	return ok && until.After(time.Now())
+}
+
+// hit 记录一次在副本上的读取，记录的key已经达到上限时不再记录新的key
+func (t *replicaTable) hit(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hits == nil {
+		t.hits = make(map[string]int64)
+	}
+	if _, ok := t.hits[key]; !ok && len(t.hits) >= maxReplicaHits {
+		return
+	}
+	t.hits[key]++
+}
+
+// takeHits 返回并清零key在副本上读取的次数
+func (t *replicaTable) takeHits(key string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.hits[key]
+	delete(t.hits, key)
+	return n
+}
@@ -0,0 +1,139 @@
+package DistributedCache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// routedClient 把请求转发到同一个进程中另一个名字的Group，用于在一个节点上模拟所属节点和请求方
+type routedClient struct {
+	*client
+	group string
+}
+
+func (r routedClient) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	return r.client.Fetch(ctx, r.group, key)
+}
+
+func (r routedClient) fetchWithHits(ctx context.Context, group string, key string, hits int64) (ByteView, error) {
+	return r.client.fetchWithHits(ctx, r.group, key, hits)
+}
+
+func (r routedClient) DropCopies(ctx context.Context, group string, key string) error {
+	return r.client.DropCopies(ctx, r.group, key)
+}
+
+// routedPeers owner为true时所有key都属于本节点，只用于广播
+type routedPeers struct {
+	peer  routedClient
+	owner bool
+}
+
+func (p routedPeers) PickPeer(key string) (Fetcher, bool) { return p.peer, !p.owner }
+
+func (p routedPeers) GetAll() []Fetcher { return []Fetcher{p.peer} }
+
+// 测试所属节点标记热点key，请求方在本地保存副本，所属节点上的写入删除副本
+func TestHotReplication(t *testing.T) {
+	var loads AtomicInt
+	owner := NewGroup("replicate-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("v-" + key), nil
+	}), WithHotReplication(3, time.Minute))
+	now := time.Now()
+	owner.hotKeys.now = func() time.Time { return now }
+	requester := NewGroup("replicate-requester", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}))
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	requester.RegisterPeers(routedPeers{peer: routedClient{client: c, group: "replicate-owner"}})
+	owner.RegisterPeers(routedPeers{peer: routedClient{client: c, group: "replicate-requester"}, owner: true})
+
+	for i := 0; i < 10; i++ {
+		if v, err := requester.Get("celeb", time.Time{}); err != nil || v.String() != "v-celeb" {
+			t.Fatalf("Get = %q, %v", v.String(), err)
+		}
+	}
+	//  所属节点在读取之前判断，前3次读取达到阈值，第4次读取时复制，之后都在本地读取
+	if n := owner.Stats.ServerRequests.Get(); n != 4 {
+		t.Fatalf("owner served %d requests", n)
+	}
+	if requester.Stats.HotCacheHits.Get() != 6 || owner.Stats.HotReplicated.Get() != 1 || loads.Get() != 1 {
+		t.Fatalf("unexpected stats: requester %+v owner %+v", requester.StatsSnapshot(), owner.StatsSnapshot())
+	}
+
+	if err := owner.Set(context.Background(), "celeb", []byte("new"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	//  通知在后台发送，写入不等待
+	for i := 0; ; i++ {
+		if _, ok := requester.hotCache.get("celeb"); !ok {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("copy should be dropped after the owner's write")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, _ := requester.Get("celeb", time.Time{}); v.String() != "new" {
+		t.Fatalf("stale copy %q after write", v.String())
+	}
+	//  副本上的6次读取随这次请求报告给了所属节点
+	if qps := owner.hotKeys.qps("celeb"); qps != 4+1+6 {
+		t.Fatalf("owner qps %f", qps)
+	}
+}
+
+// 测试所属节点在QPS下降之后不再标记，请求方报告的副本读取使key保持热点
+func TestHotReplicationCooldown(t *testing.T) {
+	g := NewGroup("replicate-cooldown", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithHotReplication(10, time.Second))
+	now := time.Now()
+	g.hotKeys.now = func() time.Time { return now }
+
+	for i := 1; i <= 10; i++ {
+		g.recordAccess("key")
+		if ttl := g.serveRemote("key", 0); (ttl != 0) != (i == 10) {
+			t.Fatalf("request %d: ttl %v", i, ttl)
+		}
+	}
+	if !g.replicas.unmark("key") || g.replicas.unmark("key") {
+		t.Fatalf("key should be marked once")
+	}
+
+	now = now.Add(3 * hotKeyWindow)
+	g.recordAccess("key")
+	if ttl := g.serveRemote("key", 0); ttl != 0 {
+		t.Fatalf("cooled key should not be replicated")
+	}
+	g.recordAccess("key")
+	if ttl := g.serveRemote("key", 20); ttl != time.Second {
+		t.Fatalf("reported hits should keep the key hot, ttl %v", ttl)
+	}
+}
+
+// 测试记录的key达到上限时只丢弃新的key，已经记录的读取次数不受影响
+func TestReplicaHitsLimit(t *testing.T) {
+	var table replicaTable
+	for i := 0; i < maxReplicaHits; i++ {
+		table.hit(fmt.Sprintf("key%d", i))
+	}
+	table.hit("key0")
+	table.hit("new")
+	if n := table.takeHits("key0"); n != 2 {
+		t.Fatalf("key0 hits = %d", n)
+	}
+	if n := table.takeHits("new"); n != 0 {
+		t.Fatalf("new key should be dropped, hits = %d", n)
+	}
+	table.hit("new")
+	if n := table.takeHits("new"); n != 1 {
+		t.Fatalf("new key should be recorded after a slot is freed, hits = %d", n)
+	}
+}
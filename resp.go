@@ -0,0 +1,609 @@
+package DistributedCache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+resp 模块实现了Redis的RESP2/RESP3协议，使已有的Redis客户端不改代码就能访问缓存
+支持的命令：GET、SET(EX/PX)、DEL、MGET、EXISTS、TTL、INCR、INCRBY、DECR、DECRBY、PING、INFO、SELECT、HELLO、QUIT
+
+Group的选择方式：
+  1. SELECT group 选择当前连接使用的Group
+  2. 未选择Group时使用默认Group
+  3. 都没有时按 group:key 的前缀约定解析key
+*/
+
+const (
+	// respKeySeparator 前缀约定中分隔group与key的字符
+	respKeySeparator = ":"
+	// respMaxBulkBytes 单个参数的最大长度，与Redis的proto-max-bulk-len默认值一致
+	respMaxBulkBytes = 512 << 20
+	// respMaxArgs 单条命令的最大参数个数
+	respMaxArgs = 1 << 20
+	// respReadChunk 预先分配的上限，声明的长度更大时随着数据到达逐步扩容，
+	// 避免客户端只发送长度就占用大量内存
+	respReadChunk = 64 << 10
+	// respCompatVersion INFO中声明兼容的Redis版本，部分客户端会检查它
+	respCompatVersion = "7.0.0"
+)
+
+// RESPServer Redis协议前端，命令经过Group处理，包括转发到key所属的节点
+type RESPServer struct {
+	group string //  默认Group，为空时使用前缀约定
+	srv   connServer
+}
+
+// NewRESPServer 创建Redis协议前端，defaultGroup 为未执行SELECT时使用的Group
+func NewRESPServer(defaultGroup string) *RESPServer {
+	return &RESPServer{group: defaultGroup}
+}
+
+// ListenAndServe 监听addr并处理连接
+func (s *RESPServer) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve 在lis上接受连接，直到Close后返回ErrServerClosed
+func (s *RESPServer) Serve(lis net.Listener) error {
+	return s.srv.serve(lis, s.serveConn)
+}
+
+// Close 停止监听并关闭所有连接
+func (s *RESPServer) Close() error {
+	return s.srv.close()
+}
+
+// respConn 单个客户端连接的状态
+type respConn struct {
+	ctx   context.Context
+	r     *bufio.Reader
+	w     *respWriter
+	group string //  SELECT选择的Group
+	quit  bool
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &respConn{
+		ctx:   ctx,
+		r:     bufio.NewReader(conn),
+		w:     &respWriter{Writer: bufio.NewWriter(conn), proto: 2},
+		group: s.group,
+	}
+	for !c.quit {
+		args, err := readRESPCommand(c.r)
+		if err != nil {
+			var perr respProtocolError
+			if errors.As(err, &perr) {
+				c.w.errorf("ERR Protocol error: %s", perr)
+				c.w.Flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[geecache_resp] %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) > 0 {
+			c.exec(args)
+		}
+		//  管道中还有未处理的命令时先不flush，攒到一起写回
+		if c.r.Buffered() == 0 || c.quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec 执行一条命令并写入回复
+func (c *respConn) exec(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch name {
+	case "PING":
+		c.ping(args)
+	case "GET":
+		c.get(args)
+	case "SET":
+		c.set(args)
+	case "DEL":
+		c.del(args)
+	case "MGET":
+		c.mget(args)
+	case "EXISTS":
+		c.exists(args)
+	case "TTL":
+		c.ttl(args)
+	case "INCR", "INCRBY", "DECR", "DECRBY":
+		c.incr(name, args)
+	case "INFO":
+		c.info(args)
+	case "SELECT":
+		c.selectGroup(args)
+	case "FLUSHDB":
+		c.flushdb(args)
+	case "HELLO":
+		c.hello(args)
+	case "COMMAND":
+		//  redis-cli等客户端连接时会发送COMMAND DOCS，返回空列表即可
+		c.w.array(0)
+	case "QUIT":
+		c.w.simple("OK")
+		c.quit = true
+	default:
+		c.w.errorf("ERR unknown command '%s'", name)
+	}
+}
+
+func (c *respConn) wrongArgs(cmd string) {
+	c.w.errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+// resolve 找到key对应的Group，返回Group以及去掉前缀后的key
+func (c *respConn) resolve(key string) (*Group, string, error) {
+	name := c.group
+	if name == "" {
+		i := strings.Index(key, respKeySeparator)
+		if i <= 0 {
+			return nil, "", fmt.Errorf("ERR no group selected, use SELECT or the group%skey form", respKeySeparator)
+		}
+		name, key = key[:i], key[i+len(respKeySeparator):]
+	}
+	g := GetGroup(name)
+	if g == nil {
+		return nil, "", fmt.Errorf("ERR no such group '%s'", name)
+	}
+	if key == "" {
+		return nil, "", fmt.Errorf("ERR empty key")
+	}
+	return g, key, nil
+}
+
+// lookup 通过Group读取key，不存在时ok为false
+func (c *respConn) lookup(key string) (view ByteView, ok bool, err error) {
+	g, key, err := c.resolve(key)
+	if err != nil {
+		return ByteView{}, false, err
+	}
+	view, err = g.GetContext(c.ctx, key, time.Time{})
+	if errors.Is(err, ErrNotFound) {
+		return ByteView{}, false, nil
+	}
+	if err != nil {
+		return ByteView{}, false, fmt.Errorf("ERR %v", err)
+	}
+	return view, true, nil
+}
+
+func (c *respConn) ping(args [][]byte) {
+	switch len(args) {
+	case 0:
+		c.w.simple("PONG")
+	case 1:
+		c.w.bulk(args[0])
+	default:
+		c.wrongArgs("ping")
+	}
+}
+
+func (c *respConn) get(args [][]byte) {
+	if len(args) != 1 {
+		c.wrongArgs("get")
+		return
+	}
+	view, ok, err := c.lookup(string(args[0]))
+	switch {
+	case err != nil:
+		c.w.err(err)
+	case !ok:
+		c.w.null()
+	default:
+		c.w.bulk(view.b)
+	}
+}
+
+// set SET key value [EX seconds | PX milliseconds]
+func (c *respConn) set(args [][]byte) {
+	if len(args) < 2 {
+		c.wrongArgs("set")
+		return
+	}
+	var expir time.Time
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		if (opt != "EX" && opt != "PX") || i+1 >= len(args) || !expir.IsZero() {
+			c.w.errorf("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil || n <= 0 {
+			c.w.errorf("ERR invalid expire time in 'set' command")
+			return
+		}
+		unit := time.Second
+		if opt == "PX" {
+			unit = time.Millisecond
+		}
+		expir = time.Now().Add(time.Duration(n) * unit)
+		i++
+	}
+	g, key, err := c.resolve(string(args[0]))
+	if err != nil {
+		c.w.err(err)
+		return
+	}
+	if err := g.Set(c.ctx, key, args[1], expir); err != nil {
+		c.w.errorf("ERR %v", err)
+		return
+	}
+	c.w.simple("OK")
+}
+
+// del 返回发出删除的key数量，key可能在其它节点上，无法确认删除前是否存在
+func (c *respConn) del(args [][]byte) {
+	if len(args) == 0 {
+		c.wrongArgs("del")
+		return
+	}
+	var n int64
+	for _, arg := range args {
+		g, key, err := c.resolve(string(arg))
+		if err != nil {
+			c.w.err(err)
+			return
+		}
+		if err := g.Delete(c.ctx, key); err != nil {
+			c.w.errorf("ERR %v", err)
+			return
+		}
+		n++
+	}
+	c.w.integer(n)
+}
+
+// incr INCR/DECR key 与 INCRBY/DECRBY key delta，由Group.Incr在所属节点上原子地完成
+func (c *respConn) incr(cmd string, args [][]byte) {
+	by := strings.HasSuffix(cmd, "BY")
+	if by && len(args) != 2 || !by && len(args) != 1 {
+		c.wrongArgs(cmd)
+		return
+	}
+	delta := int64(1)
+	if by {
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			c.w.errorf("ERR value is not an integer or out of range")
+			return
+		}
+		delta = n
+	}
+	g, key, err := c.resolve(string(args[0]))
+	if err != nil {
+		c.w.err(err)
+		return
+	}
+	var n int64
+	if strings.HasPrefix(cmd, "DECR") {
+		n, err = g.Decr(c.ctx, key, delta, 0)
+	} else {
+		n, err = g.Incr(c.ctx, key, delta, 0)
+	}
+	switch {
+	case errors.Is(err, ErrNotInteger):
+		c.w.errorf("ERR value is not an integer or out of range")
+	case errors.Is(err, ErrIncrOverflow):
+		c.w.errorf("ERR increment or decrement would overflow")
+	case err != nil:
+		c.w.errorf("ERR %v", err)
+	default:
+		c.w.integer(n)
+	}
+}
+
+func (c *respConn) mget(args [][]byte) {
+	if len(args) == 0 {
+		c.wrongArgs("mget")
+		return
+	}
+	//  与Redis一致，单个key出错时对应位置返回nil
+	c.w.array(len(args))
+	for _, arg := range args {
+		if view, ok, err := c.lookup(string(arg)); err == nil && ok {
+			c.w.bulk(view.b)
+		} else {
+			c.w.null()
+		}
+	}
+}
+
+func (c *respConn) exists(args [][]byte) {
+	if len(args) == 0 {
+		c.wrongArgs("exists")
+		return
+	}
+	var n int64
+	for _, arg := range args {
+		_, ok, err := c.lookup(string(arg))
+		if err != nil {
+			c.w.err(err)
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	c.w.integer(n)
+}
+
+// ttl 不存在返回-2，永不过期返回-1
+func (c *respConn) ttl(args [][]byte) {
+	if len(args) != 1 {
+		c.wrongArgs("ttl")
+		return
+	}
+	view, ok, err := c.lookup(string(args[0]))
+	switch {
+	case err != nil:
+		c.w.err(err)
+	case !ok:
+		c.w.integer(-2)
+	case view.Expire().IsZero():
+		c.w.integer(-1)
+	default:
+		//  与Redis一致向上取整，刚写入EX 10的key返回10
+		d := time.Until(view.Expire())
+		if d < 0 {
+			//  陈旧的值仍然可以读到，此时剩余时间为0
+			d = 0
+		}
+		c.w.integer(int64((d + time.Second - 1) / time.Second))
+	}
+}
+
+func (c *respConn) info(args [][]byte) {
+	if len(args) > 1 {
+		c.wrongArgs("info")
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Server\r\n")
+	fmt.Fprintf(&buf, "redis_version:%s\r\n", respCompatVersion)
+	buf.WriteString("server_name:geecache\r\n")
+	fmt.Fprintf(&buf, "proto:%d\r\n", c.w.proto)
+	fmt.Fprintf(&buf, "selected_group:%s\r\n", c.group)
+	buf.WriteString("\r\n# Keyspace\r\n")
+	names := GroupNames()
+	sort.Strings(names)
+	for _, name := range names {
+		g := GetGroup(name)
+		if g == nil {
+			continue
+		}
+		st := g.StatsSnapshot()
+		fmt.Fprintf(&buf, "%s:keys=%d,bytes=%d,gets=%d,hits=%d,loads=%d,peer_loads=%d\r\n",
+			name, st.CacheItems, st.CacheBytes, st.Gets, st.CacheHits, st.Loads, st.PeerLoads)
+	}
+	c.w.bulk(buf.Bytes())
+}
+
+// selectGroup SELECT group，Redis客户端通常用数字，这里直接使用Group名
+func (c *respConn) selectGroup(args [][]byte) {
+	if len(args) != 1 {
+		c.wrongArgs("select")
+		return
+	}
+	name := string(args[0])
+	if GetGroup(name) == nil {
+		c.w.errorf("ERR no such group '%s'", name)
+		return
+	}
+	c.group = name
+	c.w.simple("OK")
+}
+
+// flushdb FLUSHDB [ASYNC|SYNC] 通过Group.Flush清空SELECT选中的Group，清空总是立即生效
+func (c *respConn) flushdb(args [][]byte) {
+	if len(args) > 1 {
+		c.wrongArgs("flushdb")
+		return
+	}
+	if len(args) == 1 {
+		if mode := strings.ToUpper(string(args[0])); mode != "ASYNC" && mode != "SYNC" {
+			c.w.errorf("ERR syntax error")
+			return
+		}
+	}
+	g := GetGroup(c.group)
+	if g == nil {
+		c.w.errorf("ERR no group selected, use SELECT")
+		return
+	}
+	if _, err := g.Flush(c.ctx); err != nil {
+		c.w.errorf("ERR %v", err)
+		return
+	}
+	c.w.simple("OK")
+}
+
+// hello HELLO [protover] 协商协议版本，忽略AUTH和SETNAME
+func (c *respConn) hello(args [][]byte) {
+	if len(args) > 0 {
+		v, err := strconv.Atoi(string(args[0]))
+		if err != nil || (v != 2 && v != 3) {
+			c.w.errorf("NOPROTO unsupported protocol version")
+			return
+		}
+		c.w.proto = v
+	}
+	c.w.mapHeader(4)
+	c.w.bulkString("server")
+	c.w.bulkString("geecache")
+	c.w.bulkString("version")
+	c.w.bulkString(respCompatVersion)
+	c.w.bulkString("proto")
+	c.w.integer(int64(c.w.proto))
+	c.w.bulkString("mode")
+	c.w.bulkString("cluster")
+}
+
+// respProtocolError 客户端发送了无法解析的数据
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return string(e)
+}
+
+// readRESPCommand 读取一条命令，支持RESP数组和inline两种格式
+func readRESPCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		//  inline命令，例如telnet中直接输入 PING
+		fields := bytes.Fields(line)
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = cloneBytes(f)
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > respMaxArgs {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+	//  参数个数同样只是客户端的声明，预先分配的容量有上限
+	capacity := n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	args := make([][]byte, 0, capacity)
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, respProtocolError(fmt.Sprintf("expected '$', got '%s'", line))
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > respMaxBulkBytes {
+			return nil, respProtocolError("invalid bulk length")
+		}
+		buf, err := readRESPBulk(r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, respProtocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+// readRESPBulk 读取n个字节，超过respReadChunk的部分随着数据到达分配
+func readRESPBulk(r *bufio.Reader, n int) ([]byte, error) {
+	if n <= respReadChunk {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(respReadChunk)
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readRESPLine 读取一行并去掉结尾的 \r\n
+func readRESPLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, respProtocolError("line too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// respWriter 按连接协商的协议版本编码回复
+type respWriter struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *respWriter) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) errorf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	//  错误信息中不能出现换行
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func (w *respWriter) err(err error) {
+	w.errorf("%s", err.Error())
+}
+
+func (w *respWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+// null RESP3使用独立的null类型，RESP2使用长度为-1的bulk string
+func (w *respWriter) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// mapHeader RESP3使用map类型，RESP2退化为 2n 个元素的数组
+func (w *respWriter) mapHeader(n int) {
+	if w.proto == 3 {
+		w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.array(2 * n)
+}
@@ -0,0 +1,300 @@
+package DistributedCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respClient 测试用的RESP客户端，直接读写协议
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func encodeRESP(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return b.String()
+}
+
+func (c *respClient) send(t *testing.T, raw string) {
+	if _, err := c.conn.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *respClient) do(t *testing.T, args ...string) interface{} {
+	c.send(t, encodeRESP(args...))
+	return c.read(t)
+}
+
+// read 读取一个回复，错误以 error 返回，null 以 nil 返回，map 以 map[string]interface{} 返回
+func (c *respClient) read(t *testing.T) interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		arr := make([]interface{}, n)
+		for i := range arr {
+			arr[i] = c.read(t)
+		}
+		return arr
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k := c.read(t).(string)
+			m[k] = c.read(t)
+		}
+		return m
+	}
+	t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func startRESP(t *testing.T, defaultGroup string) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRESPServer(defaultGroup)
+	go s.Serve(lis)
+	t.Cleanup(func() { s.Close() })
+	return lis.Addr().String()
+}
+
+func newRESPGroup(name string) {
+	NewGroup(name, 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db1[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}))
+}
+
+func TestRESPServer(t *testing.T) {
+	newRESPGroup("resp")
+	c := dialRESP(t, startRESP(t, "resp"))
+
+	cases := []struct {
+		args   []string
+		expect interface{}
+	}{
+		{[]string{"PING"}, "PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"GET", "Sam"}, db1["Sam"]},
+		{[]string{"GET", "unknown"}, nil},
+		{[]string{"SET", "k1", "v1"}, "OK"},
+		{[]string{"GET", "k1"}, "v1"},
+		{[]string{"TTL", "k1"}, int64(-1)},
+		{[]string{"SET", "k2", "v2", "EX", "100"}, "OK"},
+		{[]string{"TTL", "k2"}, int64(100)},
+		{[]string{"SET", "k3", "v3", "PX", "100000"}, "OK"},
+		{[]string{"TTL", "k3"}, int64(100)},
+		{[]string{"TTL", "unknown"}, int64(-2)},
+		{[]string{"MGET", "k1", "unknown", "k2"}, []interface{}{"v1", nil, "v2"}},
+		{[]string{"EXISTS", "k1", "k2", "unknown"}, int64(2)},
+		{[]string{"DEL", "k1", "k2"}, int64(2)},
+		{[]string{"EXISTS", "k1"}, int64(0)},
+		{[]string{"INCR", "counter"}, int64(1)},
+		{[]string{"INCRBY", "counter", "10"}, int64(11)},
+		{[]string{"DECR", "counter"}, int64(10)},
+		{[]string{"DECRBY", "counter", "15"}, int64(-5)},
+		{[]string{"GET", "counter"}, "-5"},
+	}
+	for _, tc := range cases {
+		if got := c.do(t, tc.args...); !reflect.DeepEqual(got, tc.expect) {
+			t.Fatalf("%v: expect %#v, got %#v", tc.args, tc.expect, got)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"SET", "k"},
+		{"SET", "k", "v", "EX", "abc"},
+		{"SET", "k", "v", "NX"},
+		{"INCR", "Sam"},
+		{"INCRBY", "counter", "x"},
+		{"SELECT", "nogroup"},
+		{"NOSUCHCMD"},
+	} {
+		if _, ok := c.do(t, args...).(error); !ok {
+			t.Fatalf("%v: expected an error reply", args)
+		}
+	}
+
+	info, _ := c.do(t, "INFO").(string)
+	if !strings.Contains(info, "redis_version:") || !strings.Contains(info, "resp:keys=") {
+		t.Fatalf("unexpected INFO:\n%s", info)
+	}
+}
+
+// 测试管道：一次写入多条命令，按顺序读取回复
+func TestRESPPipeline(t *testing.T) {
+	newRESPGroup("resp-pipeline")
+	c := dialRESP(t, startRESP(t, "resp-pipeline"))
+
+	var raw strings.Builder
+	for i := 0; i < 100; i++ {
+		raw.WriteString(encodeRESP("SET", fmt.Sprintf("key%d", i), strconv.Itoa(i)))
+	}
+	for i := 0; i < 100; i++ {
+		raw.WriteString(encodeRESP("GET", fmt.Sprintf("key%d", i)))
+	}
+	//  inline命令也可以出现在管道中
+	raw.WriteString("PING\r\n")
+	c.send(t, raw.String())
+
+	for i := 0; i < 100; i++ {
+		if got := c.read(t); got != "OK" {
+			t.Fatalf("SET key%d: %#v", i, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got := c.read(t); got != strconv.Itoa(i) {
+			t.Fatalf("GET key%d: %#v", i, got)
+		}
+	}
+	if got := c.read(t); got != "PONG" {
+		t.Fatalf("inline PING: %#v", got)
+	}
+}
+
+// 测试通过SELECT或key前缀选择Group，以及RESP3协商
+func TestRESPGroupSelection(t *testing.T) {
+	newRESPGroup("resp-a")
+	newRESPGroup("resp-b")
+	c := dialRESP(t, startRESP(t, ""))
+
+	if _, ok := c.do(t, "GET", "Sam").(error); !ok {
+		t.Fatal("expected an error without group")
+	}
+	if got := c.do(t, "SET", "resp-a:k", "a"); got != "OK" {
+		t.Fatalf("prefixed SET: %#v", got)
+	}
+	if got := c.do(t, "SELECT", "resp-b"); got != "OK" {
+		t.Fatalf("SELECT: %#v", got)
+	}
+	if got := c.do(t, "GET", "k"); got != nil {
+		t.Fatalf("k should not exist in resp-b, got %#v", got)
+	}
+	c.do(t, "SELECT", "resp-a")
+	if got := c.do(t, "GET", "k"); got != "a" {
+		t.Fatalf("GET k in resp-a: %#v", got)
+	}
+	if got := c.do(t, "FLUSHDB"); got != "OK" {
+		t.Fatalf("FLUSHDB: %#v", got)
+	}
+	if got := c.do(t, "GET", "k"); got != nil {
+		t.Fatalf("k should be flushed, got %#v", got)
+	}
+
+	hello, ok := c.do(t, "HELLO", "3").(map[string]interface{})
+	if !ok || hello["proto"] != int64(3) {
+		t.Fatalf("unexpected HELLO reply %#v", hello)
+	}
+	//  RESP3中null有独立的类型
+	c.send(t, encodeRESP("GET", "unknown"))
+	if line, _ := c.r.ReadString('\n'); line != "_\r\n" {
+		t.Fatalf("expected RESP3 null, got %q", line)
+	}
+	if got := c.do(t, "QUIT"); got != "OK" {
+		t.Fatalf("QUIT: %#v", got)
+	}
+}
+
+// 测试非法的参数个数和只声明长度的bulk不会让节点崩溃或预先分配大量内存
+func TestRESPMalformed(t *testing.T) {
+	newRESPGroup("resp-malformed")
+	addr := startRESP(t, "resp-malformed")
+
+	c := dialRESP(t, addr)
+	c.send(t, "*-1\r\n")
+	if err, ok := c.read(t).(error); !ok || !strings.Contains(err.Error(), "invalid multibulk length") {
+		t.Fatalf("expected a protocol error, got %#v", err)
+	}
+
+	if _, err := readRESPCommand(bufio.NewReader(strings.NewReader("*1\r\n$100000000\r\nabc"))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated bulk: %v", err)
+	}
+	args, err := readRESPCommand(bufio.NewReader(strings.NewReader(encodeRESP("SET", "k", strings.Repeat("v", 3*respReadChunk)))))
+	if err != nil || len(args) != 3 || len(args[2]) != 3*respReadChunk {
+		t.Fatalf("large bulk: %d args, %v", len(args), err)
+	}
+
+	//  连接出错之后节点仍然可以服务新的连接
+	if got := dialRESP(t, addr).do(t, "PING"); got != "PONG" {
+		t.Fatalf("PING after protocol error: %#v", got)
+	}
+}
+
+// 测试处理连接时的panic只关闭这个连接
+func TestConnServerRecover(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s connServer
+	go s.serve(lis, func(conn net.Conn) {
+		var buf [1]byte
+		conn.Read(buf[:])
+		if buf[0] == 'p' {
+			panic("boom")
+		}
+		conn.Write(buf[:])
+	})
+	defer s.close()
+
+	c := dialRESP(t, lis.Addr().String())
+	c.send(t, "p")
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("panicking connection should be closed")
+	}
+	c = dialRESP(t, lis.Addr().String())
+	c.send(t, "x")
+	if b, err := c.r.ReadByte(); err != nil || b != 'x' {
+		t.Fatalf("server should keep serving: %q, %v", b, err)
+	}
+}
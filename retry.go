@@ -0,0 +1,278 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+向其它节点的请求失败后按RetryPolicy重试，等待时间指数增长并带有随机抖动，
+下一次重试会超过调用方的截止时间时不再等待，直接返回最后一次的错误
+只有幂等的请求会重试：Fetch、Set、Delete；CompareAndSet、SetWithLease、Lease和Incr
+重复执行会得到不同的结果，只请求一次
+
+对冲请求：向所属节点读取的耗时超过最近读取耗时的p95后，向一致性哈希环上的下一个节点发送第二个请求，
+使用先返回的结果，另一个请求随即取消
+只有成功的对冲请求会取代所属节点的结果，对冲请求失败或者key不存在时继续等待所属节点
+后备节点收到对冲请求时不再转发给所属节点，未命中时直接调用getter，加载的值不写入本节点的缓存
+*/
+
+const (
+	//  统计读取耗时的样本数量
+	latencySamples = 256
+	//  样本少于这个数量时p95不可靠，不发送对冲请求
+	minHedgeSamples = 20
+)
+
+// RetryPolicy 向其它节点请求失败后的重试策略
+type RetryPolicy struct {
+	Attempts   int           //  最多请求的次数，包括第一次，小于等于1表示不重试
+	Backoff    time.Duration //  第一次重试之前的等待时间，之后每次翻倍
+	MaxBackoff time.Duration //  等待时间的上限，为0表示不限制
+	Jitter     float64       //  等待时间随机减少的最大比例，在[0, 1]之间，避免多个节点同时重试
+}
+
+// backoff 返回第attempt次请求失败之后的等待时间
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// WithRetry 设置向其它节点请求失败后的重试策略
+func WithRetry(policy RetryPolicy) GroupOption {
+	return func(g *Group) {
+		g.retryPolicy = policy
+	}
+}
+
+// WithHedging 开启对冲请求，等待时间为最近读取耗时的p95，且不少于minDelay
+func WithHedging(minDelay time.Duration) GroupOption {
+	return func(g *Group) {
+		g.hedgeMinDelay = minDelay
+		g.fetchLatency = &latencyTracker{}
+	}
+}
+
+// retryable 返回失败的请求是否值得重试，只有暂时性的错误才重试：
+// 节点不可用、超时和资源不足，其它错误（key不存在、熔断器打开、参数错误等）重试也会得到同样的结果
+func retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// withRetry 执行幂等的请求fn，失败后按重试策略重试
+// deadline 为调用方的截止时间，为零值表示不限制
+func (g *Group) withRetry(ctx context.Context, deadline time.Time, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= g.retryPolicy.Attempts {
+			return err
+		}
+		wait := g.retryPolicy.backoff(attempt)
+		if !deadline.IsZero() && time.Until(deadline) <= wait {
+			return err
+		}
+		g.Stats.Retries.Add(1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// deadlineOf 返回ctx的截止时间，没有时为零值
+func deadlineOf(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	return deadline
+}
+
+// ReplicaPicker 是可以选出key的后备节点的PeerPicker，用于对冲请求
+type ReplicaPicker interface {
+	// PickReplica 返回一致性哈希环上所属节点之后的下一个节点，它是本节点时返回false
+	PickReplica(key string) (Fetcher, bool)
+}
+
+// replicaFetcher 是可以发送对冲请求的Fetcher，client实现了它
+type replicaFetcher interface {
+	fetchReplica(ctx context.Context, group string, key string) (ByteView, error)
+}
+
+// fetchFromPeers 向所属节点读取key，开启对冲时超过p95之后再向后备节点读取
+func (g *Group) fetchFromPeers(ctx context.Context, owner Fetcher, key string) (ByteView, error) {
+	delay, ok := g.hedgeDelay()
+	var replica replicaFetcher
+	if ok {
+		ok = false
+		if rp, isRP := g.peers.(ReplicaPicker); isRP {
+			if peer, found := rp.PickReplica(key); found {
+				replica, ok = peer.(replicaFetcher)
+			}
+		}
+	}
+	if !ok {
+		start := time.Now()
+		value, err := g.fetchFromOwner(ctx, owner, key)
+		if err == nil && g.fetchLatency != nil {
+			g.fetchLatency.observe(time.Since(start))
+		}
+		return value, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		value ByteView
+		err   error
+		hedge bool
+	}
+	results := make(chan result, 2)
+	start := time.Now()
+	go func() {
+		value, err := g.fetchFromOwner(ctx, owner, key)
+		//  被对冲请求取消的耗时也要计入，否则p95会偏小
+		if err == nil || ctx.Err() != nil {
+			g.fetchLatency.observe(time.Since(start))
+		}
+		results <- result{value: value, err: err}
+	}()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var ownerErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			switch {
+			case r.hedge && r.err == nil:
+				g.Stats.HedgeWins.Add(1)
+				return r.value, nil
+			case !r.hedge && (r.err == nil || errors.Is(r.err, ErrNotFound)):
+				return r.value, r.err
+			case !r.hedge:
+				//  所属节点失败时等待进行中的对冲请求
+				ownerErr = r.err
+			}
+			//  后备节点不是所属节点，它的错误和未命中都不作为结果
+			if pending == 0 {
+				return ByteView{}, ownerErr
+			}
+		case <-timer.C:
+			g.Stats.Hedges.Add(1)
+			pending++
+			go func() {
+				value, err := replica.fetchReplica(ctx, g.name, key)
+				results <- result{value: value, err: err, hedge: true}
+			}()
+		}
+	}
+}
+
+// hedgeDelay 返回发送对冲请求之前的等待时间，样本不足或未开启时返回false
+func (g *Group) hedgeDelay() (time.Duration, bool) {
+	if g.fetchLatency == nil {
+		return 0, false
+	}
+	p95, ok := g.fetchLatency.percentile(0.95)
+	if !ok {
+		return 0, false
+	}
+	if p95 < g.hedgeMinDelay {
+		p95 = g.hedgeMinDelay
+	}
+	return p95, true
+}
+
+// serveReplica 作为后备节点处理对冲请求，不再转发给所属节点
+// 命中本节点的缓存时直接返回，否则直接调用getter加载
+// 本节点不是所属节点，之后的写入不会通知到这里，加载的值不写入缓存，也不使用所属节点的填充租约
+func (g *Group) serveReplica(ctx context.Context, key string) (ByteView, error) {
+	if item, ok := g.mainCache.getItem(key); ok && !item.stale {
+		g.Stats.CacheHits.Add(1)
+		return item.value, nil
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		g.Stats.HotCacheHits.Add(1)
+		return v, nil
+	}
+	return g.loadUncached(ctx, key)
+}
+
+// loadUncached 不经过所属节点直接调用getter加载key，加载的值不写入缓存
+func (g *Group) loadUncached(ctx context.Context, key string) (ByteView, error) {
+	//  不与本节点转发给所属节点的加载合并，否则又要等待所属节点
+	view, err, _ := g.replicaLoader.DoContext(ctx, key, func() (interface{}, error) {
+		g.Stats.LoadsDeduped.Add(1)
+		bytes, _, err := g.callGetter(key)
+		if err != nil {
+			g.Stats.LocalLoadErrs.Add(1)
+			return ByteView{}, err
+		}
+		g.Stats.LocalLoads.Add(1)
+		var expir time.Time
+		if g.ttl > 0 {
+			expir = time.Now().Add(g.ttl)
+		}
+		return ByteView{b: cloneBytes(bytes), t: expir}, nil
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return view.(ByteView), nil
+}
+
+// latencyTracker 保存最近的读取耗时，用于估计分位数
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	n       int //  已经记录的样本数量
+}
+
+func (l *latencyTracker) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.n%latencySamples] = d
+	l.n++
+}
+
+// percentile 返回最近样本的p分位数，样本不足时返回false
+func (l *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	n := l.n
+	if n > latencySamples {
+		n = latencySamples
+	}
+	if n < minHedgeSamples {
+		l.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	l.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(n-1))], true
+}
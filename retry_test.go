@@ -0,0 +1,258 @@
+package DistributedCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// flakyFetcher 前failures次Fetch、Set和Incr返回节点不可用的错误
+type flakyFetcher struct {
+	fakeFetcher
+	failures int64
+	fetches  AtomicInt
+	sets     AtomicInt
+	incrs    AtomicInt
+}
+
+func (f *flakyFetcher) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	if f.fetches.Add(1); f.fetches.Get() <= f.failures {
+		return ByteView{}, fmt.Errorf("could not get %s/%s: %w", group, key, errUnavailable)
+	}
+	return f.fakeFetcher.Fetch(ctx, group, key)
+}
+
+func (f *flakyFetcher) Set(ctx context.Context, group string, key string, value []byte, expir time.Time, tags ...string) error {
+	if f.sets.Add(1); f.sets.Get() <= f.failures {
+		return fmt.Errorf("could not set %s/%s: %w", group, key, errUnavailable)
+	}
+	return nil
+}
+
+func (f *flakyFetcher) Incr(ctx context.Context, group string, key string, delta int64, expir time.Time) (int64, error) {
+	f.incrs.Add(1)
+	return 0, fmt.Errorf("could not incr %s/%s: %w", group, key, errUnavailable)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Fatalf("backoff(%d) = %v, want %v", i+1, d, w*time.Millisecond)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("jittered backoff %v out of range", d)
+		}
+	}
+}
+
+// 测试读取和Set失败后重试，Incr不是幂等的，只请求一次
+func TestRetry(t *testing.T) {
+	g := NewGroup("retry", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}), WithOwnerLoad(FallbackFail, 0), WithRetry(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}))
+	f := &flakyFetcher{failures: 2}
+	g.RegisterPeers(fakePeers{fetcher: f})
+
+	if v, err := g.Get("k", time.Time{}); err != nil || v.String() != "remote-k" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if err := g.Set(context.Background(), "k", []byte("v"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Incr(context.Background(), "n", 1, 0); err == nil {
+		t.Fatalf("Incr should fail")
+	}
+	if f.fetches.Get() != 3 || f.sets.Get() != 3 || f.incrs.Get() != 1 || g.Stats.Retries.Get() != 4 {
+		t.Fatalf("fetches %d sets %d incrs %d retries %d", f.fetches.Get(), f.sets.Get(), f.incrs.Get(), g.Stats.Retries.Get())
+	}
+
+	//  次数用完之后按回退方式处理
+	f.failures = 10
+	if _, err := g.Get("other", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("Get after exhausted retries: %v", err)
+	}
+	if f.fetches.Get() != 6 {
+		t.Fatalf("fetched %d times", f.fetches.Get())
+	}
+}
+
+// 测试只有暂时性的错误才重试
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{errUnavailable, true},
+		{fmt.Errorf("could not get k: %w", status.Error(codes.DeadlineExceeded, "timeout")), true},
+		{status.Error(codes.ResourceExhausted, "too many requests"), true},
+		{fmt.Errorf("dial: %w", context.DeadlineExceeded), true},
+		{fmt.Errorf("k: %w", ErrNotFound), false},
+		{ErrBreakerOpen, false},
+		{context.Canceled, false},
+		{status.Error(codes.FailedPrecondition, "not the owner"), false},
+		{status.Error(codes.InvalidArgument, "bad request"), false},
+		{fmt.Errorf("unknown"), false},
+	}
+	for _, c := range cases {
+		if got := retryable(c.err); got != c.want {
+			t.Errorf("retryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// 测试等待时间超过调用方的截止时间时不再重试
+func TestRetryDeadline(t *testing.T) {
+	g := NewGroup("retry-deadline", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}), WithOwnerLoad(FallbackFail, 0), WithRetry(RetryPolicy{Attempts: 5, Backoff: time.Second}))
+	f := &flakyFetcher{failures: 10}
+	g.RegisterPeers(fakePeers{fetcher: f})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.GetContext(ctx, "k", time.Time{}); !errors.Is(err, ErrOwnerUnavailable) {
+		t.Fatalf("Get = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond || f.fetches.Get() != 1 {
+		t.Fatalf("should give up at once, took %v with %d fetches", elapsed, f.fetches.Get())
+	}
+}
+
+// slowFetcher 作为所属节点，每次读取等待delay
+type slowFetcher struct {
+	fakeFetcher
+	delay time.Duration
+}
+
+func (f slowFetcher) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	select {
+	case <-time.After(f.delay):
+		return ByteView{b: []byte("owner-" + key)}, nil
+	case <-ctx.Done():
+		return ByteView{}, ctx.Err()
+	}
+}
+
+// replicaStub 作为后备节点，立即返回，err不为nil时返回err
+type replicaStub struct {
+	fakeFetcher
+	hedges *AtomicInt
+	err    error
+}
+
+func (r replicaStub) fetchReplica(ctx context.Context, group string, key string) (ByteView, error) {
+	r.hedges.Add(1)
+	if r.err != nil {
+		return ByteView{}, r.err
+	}
+	return ByteView{b: []byte("replica-" + key)}, nil
+}
+
+type hedgePeers struct {
+	owner   Fetcher
+	replica Fetcher
+}
+
+func (p hedgePeers) PickPeer(key string) (Fetcher, bool) { return p.owner, true }
+
+func (p hedgePeers) PickReplica(key string) (Fetcher, bool) { return p.replica, true }
+
+func (p hedgePeers) GetAll() []Fetcher { return []Fetcher{p.owner, p.replica} }
+
+// 测试所属节点超过p95仍未返回时向后备节点发送对冲请求
+func TestHedgedFetch(t *testing.T) {
+	g := NewGroup("hedge", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}), WithHedging(10*time.Millisecond))
+	var hedges AtomicInt
+	owner := &slowFetcher{delay: time.Millisecond}
+	g.RegisterPeers(hedgePeers{owner: owner, replica: replicaStub{hedges: &hedges}})
+
+	//  样本不足时不对冲
+	for i := 0; i < minHedgeSamples; i++ {
+		if v, _ := g.Get(fmt.Sprintf("k%d", i), time.Time{}); v.String() != fmt.Sprintf("owner-k%d", i) {
+			t.Fatalf("Get = %q", v.String())
+		}
+	}
+	if hedges.Get() != 0 {
+		t.Fatalf("fast owner should not be hedged")
+	}
+
+	owner.delay = time.Second
+	start := time.Now()
+	if v, err := g.Get("slow", time.Time{}); err != nil || v.String() != "replica-slow" {
+		t.Fatalf("Get = %q, %v", v.String(), err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged request took %v", elapsed)
+	}
+	if hedges.Get() != 1 || g.Stats.Hedges.Get() != 1 || g.Stats.HedgeWins.Get() != 1 {
+		t.Fatalf("unexpected stats %+v", g.StatsSnapshot())
+	}
+}
+
+// 测试对冲请求失败或者key不存在时继续等待所属节点的结果
+func TestHedgeFailureWaitsForOwner(t *testing.T) {
+	for i, err := range []error{ErrNotFound, fmt.Errorf("replica down")} {
+		g := NewGroup(fmt.Sprintf("hedge-fail-%d", i), 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("should not be called")
+		}), WithHedging(10*time.Millisecond))
+		var hedges AtomicInt
+		owner := &slowFetcher{delay: time.Millisecond}
+		g.RegisterPeers(hedgePeers{owner: owner, replica: replicaStub{hedges: &hedges, err: err}})
+		for j := 0; j < minHedgeSamples; j++ {
+			g.Get(fmt.Sprintf("k%d", j), time.Time{})
+		}
+
+		owner.delay = 100 * time.Millisecond
+		if v, err := g.Get("slow", time.Time{}); err != nil || v.String() != "owner-slow" {
+			t.Fatalf("Get = %q, %v", v.String(), err)
+		}
+		if hedges.Get() != 1 || g.Stats.HedgeWins.Get() != 0 {
+			t.Fatalf("unexpected stats %+v", g.StatsSnapshot())
+		}
+	}
+}
+
+// 测试后备节点处理对冲请求时在本节点加载，不再转发给所属节点
+func TestServeReplica(t *testing.T) {
+	g := NewGroup("hedge-replica", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	}))
+	f := &countingFetcher{}
+	g.RegisterPeers(fakePeers{fetcher: f})
+	svr, _ := startServer(t)
+	defer svr.Stop()
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+
+	if v, err := c.fetchReplica(context.Background(), "hedge-replica", "k"); err != nil || v.String() != "local-k" {
+		t.Fatalf("fetchReplica = %q, %v", v.String(), err)
+	}
+	//  后备节点不是所属节点，加载的值不写入缓存，也不占用填充租约
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Fatalf("replica load should not be cached")
+	}
+	g.leases.mu.Lock()
+	fills := len(g.leases.fills)
+	g.leases.mu.Unlock()
+	if fills != 0 {
+		t.Fatalf("replica load should not take a fill lease")
+	}
+	if v, err := c.Fetch(context.Background(), "hedge-replica", "other"); err != nil || v.String() != "remote-other" {
+		t.Fatalf("Fetch = %q, %v", v.String(), err)
+	}
+	if f.fetches.Get() != 1 {
+		t.Fatalf("only the plain request should be forwarded, fetched %d times", f.fetches.Get())
+	}
+}
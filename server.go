@@ -1,15 +1,22 @@
 package DistributedCache
 
 import (
-	"GeeCache/consistenthash"
-	pb "GeeCache/geecachepb"
-	"GeeCache/registry"
+	"DistributedCache/consistenthash"
+	pb "DistributedCache/geecachepb"
+	"DistributedCache/registry"
 	"context"
+	"errors"
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"log"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -38,9 +45,17 @@ var (
 type server struct {
 	pb.UnimplementedGroupCacheServer
 
-	addr       string     // format: ip:port
-	status     bool       // true: running false: stop
-	stopSignal chan error // 通知registry revoke服务
+	addr       string        // format: ip:port
+	status     bool          // true: running false: stop
+	stopSignal chan error    // 通知registry revoke服务
+	revoked    chan struct{} // registry完成revoke后关闭
+	stopping   chan struct{} // Stop时关闭，结束进行中的Watch，否则GracefulStop会一直等待
+
+	etcdConfig    *clientv3.Config // 为nil时不使用etcd，节点之间直连
+	grpcServer    *grpc.Server
+	serverOpts    []grpc.ServerOption
+	dialOpts      []grpc.DialOption // 访问远程节点时使用
+	breakerConfig *BreakerConfig    // 为nil时不熔断
 
 	//self     string //  记录自己的地址，IP和端口
 	//basePath string //  作为节点间通讯地址的前缀，默认是/_geecache/
@@ -50,8 +65,34 @@ type server struct {
 	clients map[string]*client
 }
 
+// ServerOption 配置server的可选项
+type ServerOption func(*server)
+
+// WithEtcdConfig 使用指定的etcd集群做服务注册与发现
+func WithEtcdConfig(cfg clientv3.Config) ServerOption {
+	return func(h *server) {
+		h.etcdConfig = &cfg
+	}
+}
+
+// WithoutRegistry 不向etcd注册，节点之间按Set传入的地址直连
+func WithoutRegistry() ServerOption {
+	return func(h *server) {
+		h.etcdConfig = nil
+	}
+}
+
+// WithTransportCredentials 为节点间通信开启TLS
+// serverCreds 用于本节点的gRPC服务，clientCreds 用于访问远程节点
+func WithTransportCredentials(serverCreds, clientCreds credentials.TransportCredentials) ServerOption {
+	return func(h *server) {
+		h.serverOpts = append(h.serverOpts, grpc.Creds(serverCreds))
+		h.dialOpts = append(h.dialOpts, grpc.WithTransportCredentials(clientCreds))
+	}
+}
+
 // NewServer 创建cache的svr 若addr为空 则使用defaultAddr
-func NewServer(addr string) (*server, error) {
+func NewServer(addr string, opts ...ServerOption) (*server, error) {
 	//return &HTTPPool{
 	//	self:     self,
 	//	basePath: defaultBasePath,
@@ -62,7 +103,12 @@ func NewServer(addr string) (*server, error) {
 	if !validPeerAddr(addr) {
 		return nil, fmt.Errorf("invalid addr %s, it should be x.x.x.x:port", addr)
 	}
-	return &server{addr: addr}, nil
+	cfg := defaultEtcdConfig
+	h := &server{addr: addr, etcdConfig: &cfg}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h, nil
 }
 
 // Log info with server name
@@ -87,42 +133,41 @@ func (h *server) Start() error {
 	//    获取服务Host地址 从而进行通信。这样的好处是client只需知道服务名
 	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中
 	// ----------------------------------------------
-	// 2. 初始化stop channal,这用于通知registry stop keep alive
-	h.status = true
-	h.stopSignal = make(chan error)
-
 	port := strings.Split(h.addr, ":")[1]
 	// 3. 初始化tcp socket并开始监听
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
+		h.mu.Unlock()
 		return fmt.Errorf("failed to listen: %v", err)
 	}
+	h.status = true
+	h.stopping = make(chan struct{})
 	// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(h.serverOpts...)
+	h.grpcServer = grpcServer
 	// 5. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
 	//    获取服务Host地址 从而进行通信。这样的好处是client只需知道服务名
 	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中
 	pb.RegisterGroupCacheServer(grpcServer, h)
 
 	//  注册服务到etcd
-	go func() {
-		// Register never return unless stop singnal received
-		err := registry.Register("geecache", h.addr, h.stopSignal)
-		if err != nil {
-			log.Fatalf(err.Error())
-		}
-		//  Close channel
-		close(h.stopSignal)
-		//  Close tcp listen
-		err = lis.Close()
-		if err != nil {
-			log.Fatalf(err.Error())
-		}
-		log.Printf("[%s] Revoke service and close tcp socket ok.", h.addr)
-	}()
+	if h.etcdConfig != nil {
+		// 2. 初始化stop channal,这用于通知registry stop keep alive
+		h.stopSignal = make(chan error)
+		h.revoked = make(chan struct{})
+		go func(cfg clientv3.Config, stop chan error, revoked chan struct{}) {
+			// Register never return unless stop singnal received
+			err := registry.RegisterWithConfig(cfg, "geecache", h.addr, stop)
+			if err != nil {
+				log.Printf("[%s] register service failed: %v", h.addr, err)
+			}
+			close(revoked)
+			log.Printf("[%s] Revoke service ok.", h.addr)
+		}(*h.etcdConfig, h.stopSignal, h.revoked)
+	}
 
 	h.mu.Unlock()
-	if err := grpcServer.Serve(lis); h.status && err != nil {
+	if err := grpcServer.Serve(lis); err != nil {
 		return fmt.Errorf("failed to serve: %v", err)
 	}
 	return nil
@@ -207,8 +252,12 @@ func (h *server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	//
 	//return nil
 	//-------------------以上使用 proto.Unmarshal()解码 HTTP 响应
+	ctx, span := startSpan(extractTrace(ctx), "server.Get")
+	defer span.End()
 	group, key := in.GetGroup(), in.GetKey()
 	resp := &pb.Response{}
+	span.SetAttribute("group", group)
+	span.SetAttribute("key", key)
 
 	log.Printf("[peanutcache_svr %s] Recv RPC Request - (%s)/(%s)", h.addr, group, key)
 	if key == "" {
@@ -219,15 +268,337 @@ func (h *server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	if g == nil {
 		return resp, fmt.Errorf("group not found")
 	}
-	expir := time.Time{}
-	view, err := g.Get(key, expir)
+	g.Stats.ServerRequests.Add(1)
+	var (
+		view ByteView
+		ttl  time.Duration
+		err  error
+	)
+	switch {
+	case in.GetPeek():
+		span.SetAttribute("peek", true)
+		view, err = g.peekLocally(key)
+	case in.GetReplica():
+		span.SetAttribute("replica", true)
+		view, err = g.serveReplica(ctx, key)
+	default:
+		//  在读取之前标记热点，读取之后的写入会通知请求方删除这次返回的副本
+		ttl = g.serveRemote(key, in.GetHits())
+		view, err = g.GetContext(ctx, key, time.Time{})
+	}
 	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrNotFound) {
+			return resp, status.Error(codes.NotFound, err.Error())
+		}
 		return resp, err
 	}
 	resp.Value = view.ByteSlice()
+	resp.Expire = toUnixNano(view.Expire())
+	resp.Version = view.Version()
+	//  后备节点不是所属节点，写入时不会通知副本失效，不能要求请求方保存副本
+	//  peek只判断key是否存在，不是读取，这两种请求的ttl都为0
+	if ttl > 0 {
+		resp.Hot, resp.HotTtl = true, int64(ttl)
+	}
+	return resp, nil
+}
+
+// Put 实现geeCache service的Put接口，写入本节点的缓存
+// 调用方已经通过一致性哈希选中了本节点，因此这里不再转发；本节点不是所属节点时拒绝写入
+func (h *server) Put(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Put")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC Put - (%s)/(%s)", h.addr, in.GetGroup(), in.GetKey())
+	if err := h.checkOwner(in.GetKey()); err != nil {
+		return resp, err
+	}
+	if in.GetLease() != 0 {
+		err := g.setWithLeaseLocally(in.GetKey(), in.GetValue(), fromUnixNano(in.GetExpire()), in.GetLease())
+		if errors.Is(err, ErrLeaseInvalid) {
+			return resp, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return resp, err
+	}
+	return resp, g.setLocally(in.GetKey(), in.GetValue(), fromUnixNano(in.GetExpire()), in.GetTags())
+}
+
+// Delete 实现geeCache service的Delete接口，删除本节点的缓存
+func (h *server) Delete(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Delete")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC Delete - (%s)/(%s)", h.addr, in.GetGroup(), in.GetKey())
+	if err := h.checkOwner(in.GetKey()); err != nil {
+		return resp, err
+	}
+	return resp, g.deleteLocally(in.GetKey())
+}
+
+// checkOwner 本节点不是key所属的节点时返回FailedPrecondition
+// 调用方的哈希环与本节点不一致时，写入保存在本节点上，所属节点永远读不到，也不会被之后的写入覆盖
+func (h *server) checkOwner(key string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.peers == nil {
+		return nil
+	}
+	if owner := h.peers.Get(key); owner != "" && owner != h.addr {
+		return status.Errorf(codes.FailedPrecondition, "%s is not the owner of key %s, owner is %s", h.addr, key, owner)
+	}
+	return nil
+}
+
+// Lease 实现geeCache service的Lease接口，在本节点读取缓存或发放租约
+func (h *server) Lease(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Lease")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	lease, err := g.leaseLocally(in.GetKey())
+	if errors.Is(err, ErrLeaseWait) {
+		return resp, status.Error(codes.Aborted, err.Error())
+	}
+	resp.Value = lease.Value.ByteSlice()
+	resp.Expire = toUnixNano(lease.Value.Expire())
+	resp.Version = lease.Value.Version()
+	resp.Lease = lease.Token
+	resp.Stale = lease.Stale
+	return resp, nil
+}
+
+// CompareAndSet 实现geeCache service的CompareAndSet接口，在本节点比较版本号并写入
+// 版本号不一致时返回Aborted，details中携带当前的版本号
+func (h *server) CompareAndSet(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.CompareAndSet")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC CompareAndSet - (%s)/(%s)", h.addr, in.GetGroup(), in.GetKey())
+	version, err := g.compareAndSetLocally(in.GetKey(), in.GetValue(), fromUnixNano(in.GetExpire()), in.GetVersion())
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		st, derr := status.New(codes.Aborted, err.Error()).WithDetails(wrapperspb.UInt64(conflict.Current))
+		if derr != nil {
+			return resp, status.Error(codes.Aborted, err.Error())
+		}
+		return resp, st.Err()
+	}
+	resp.Version = version
+	return resp, err
+}
+
+// Incr 实现geeCache service的Incr接口，在本节点原子地累加计数器
+func (h *server) Incr(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Incr")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	n, err := g.incrLocally(in.GetKey(), in.GetDelta(), fromUnixNano(in.GetExpire()))
+	switch {
+	case errors.Is(err, ErrNotInteger):
+		return resp, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrIncrOverflow):
+		return resp, status.Error(codes.OutOfRange, err.Error())
+	case err != nil:
+		span.RecordError(err)
+		return resp, err
+	}
+	resp.Count = n
+	return resp, nil
+}
+
+// Invalidate 实现geeCache service的Invalidate接口，只删除本节点上的key，不再广播
+func (h *server) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Invalidate")
+	defer span.End()
+	resp := &pb.InvalidateResponse{}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC Invalidate - (%s) tag=%q prefix=%q", h.addr, in.GetGroup(), in.GetTag(), in.GetPrefix())
+	n, err := g.invalidateLocally(in.GetTag(), in.GetPrefix())
+	resp.Removed = int64(n)
+	return resp, err
+}
+
+// Flush 实现geeCache service的Flush接口，让本节点的Group进入新的代
+func (h *server) Flush(ctx context.Context, in *pb.FlushRequest) (*pb.FlushResponse, error) {
+	_, span := startSpan(extractTrace(ctx), "server.Flush")
+	defer span.End()
+	resp := &pb.FlushResponse{}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC Flush - (%s) generation=%d", h.addr, in.GetGroup(), in.GetGeneration())
+	gen, err := g.flushLocally(in.GetGeneration())
+	resp.Generation = gen
+	return resp, err
+}
+
+// DropCopies 实现geeCache service的DropCopies接口，删除本节点上key的热点副本
+func (h *server) DropCopies(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	_, span := startSpan(extractTrace(ctx), "server.DropCopies")
+	defer span.End()
+	resp := &pb.Response{}
+	if in.GetKey() == "" {
+		return resp, fmt.Errorf("key required")
+	}
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC DropCopies - (%s)/(%s)", h.addr, in.GetGroup(), in.GetKey())
+	g.dropCopies(in.GetKey())
+	return resp, nil
+}
+
+// Watch 实现geeCache service的Watch接口，把本节点上Group的键空间事件推送给调用方
+// 调用方接收得慢时gRPC的流控会阻塞发送，订阅的缓冲区满了之后事件被丢弃，丢弃的数量随下一个事件返回
+func (h *server) Watch(in *pb.WatchRequest, stream pb.GroupCache_WatchServer) error {
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return fmt.Errorf("group not found")
+	}
+	filter := EventFilter{Prefix: in.GetPrefix()}
+	for _, name := range in.GetTypes() {
+		t, ok := ParseEventType(name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "unknown event type %q", name)
+		}
+		filter.Types = append(filter.Types, t)
+	}
+	log.Printf("[peanutcache_svr %s] Recv RPC Watch - (%s) prefix=%q types=%v", h.addr, in.GetGroup(), in.GetPrefix(), in.GetTypes())
+	h.mu.Lock()
+	stopping := h.stopping
+	h.mu.Unlock()
+
+	sub := g.Subscribe(filter)
+	defer sub.Close()
+	//  订阅之后再发送header，调用方收到header之后的事件都不会错过
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	var reported int64
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-stopping:
+			return status.Error(codes.Unavailable, "server stopping")
+		case e := <-sub.C:
+			dropped := sub.Dropped()
+			err := stream.Send(&pb.WatchEvent{
+				Type:    e.Type.String(),
+				Key:     e.Key,
+				Version: e.Version,
+				Time:    e.Time.UnixNano(),
+				Dropped: dropped - reported,
+			})
+			if err != nil {
+				return err
+			}
+			reported = dropped
+		}
+	}
+}
+
+// Stats 实现geeCache service的Stats接口，返回本节点Group的指标
+func (h *server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsResponse, error) {
+	names := GroupNames()
+	if in.GetGroup() != "" {
+		names = []string{in.GetGroup()}
+	}
+	sort.Strings(names)
+	resp := &pb.StatsResponse{}
+	for _, name := range names {
+		g := GetGroup(name)
+		if g == nil {
+			return resp, fmt.Errorf("group not found")
+		}
+		st := g.StatsSnapshot()
+		resp.Groups = append(resp.Groups, &pb.GroupStats{
+			Name:           st.Name,
+			Gets:           st.Gets,
+			CacheHits:      st.CacheHits,
+			Loads:          st.Loads,
+			LoadsDeduped:   st.LoadsDeduped,
+			PeerLoads:      st.PeerLoads,
+			PeerErrors:     st.PeerErrors,
+			LocalLoads:     st.LocalLoads,
+			LocalLoadErrs:  st.LocalLoadErrs,
+			ServerRequests: st.ServerRequests,
+			StaleHits:      st.StaleHits,
+			StaleErrors:    st.StaleErrors,
+			Refreshes:      st.Refreshes,
+			EarlyRefreshes: st.EarlyRefreshes,
+			CacheBytes:     st.CacheBytes,
+			CacheItems:     st.CacheItems,
+			Generation:     st.Generation,
+			HotCacheHits:   st.HotCacheHits,
+			HotPromotions:  st.HotPromotions,
+			HotKeys:        hotKeysToPB(st.HotKeys),
+			HotReplicated:  st.HotReplicated,
+			Retries:        st.Retries,
+			Hedges:         st.Hedges,
+			HedgeWins:      st.HedgeWins,
+		})
+	}
 	return resp, nil
 }
 
+func hotKeysToPB(keys []HotKey) []*pb.HotKey {
+	var out []*pb.HotKey
+	for _, k := range keys {
+		out = append(out, &pb.HotKey{Key: k.Key, Qps: k.QPS})
+	}
+	return out
+}
+
+// Keys 实现geeCache service的Keys接口，列出本节点缓存的key
+func (h *server) Keys(ctx context.Context, in *pb.KeysRequest) (*pb.KeysResponse, error) {
+	g := GetGroup(in.GetGroup())
+	if g == nil {
+		return &pb.KeysResponse{}, fmt.Errorf("group not found")
+	}
+	return &pb.KeysResponse{Keys: g.Keys()}, nil
+}
+
 // Set 将各个远端主机IP配置到HTTPPool里
 // 这样HTTPPool就可以Pick他们了
 // 注意: 此操作是*覆写*操作！
@@ -245,13 +616,17 @@ func (h *server) Set(peers ...string) {
 	//	h.httpGetter[peer] = &httpGetter{baseURL: peer + h.basePath}
 	//}
 	h.peers.Add(peers...)
+	h.closeClients()
 	h.clients = make(map[string]*client)
 	for _, peerAddr := range peers {
 		if !validPeerAddr(peerAddr) {
 			panic(fmt.Sprintf("[peer %s] invalid address format, it should be x.x.x.x:port", peerAddr))
 		}
-		service := fmt.Sprintf("geecache/%s", peerAddr)
-		h.clients[peerAddr] = NewClient(service)
+		c := newPeerClient(peerAddr, h.etcdConfig, h.dialOpts)
+		if h.breakerConfig != nil {
+			c.breaker = newBreaker(*h.breakerConfig)
+		}
+		h.clients[peerAddr] = c
 	}
 }
 
@@ -262,14 +637,18 @@ func (h *server) Set(peers ...string) {
 //		实现peerPicker接口
 func (h *server) PickPeer(key string) (Fetcher, bool) {
 	h.mu.Lock()
-	defer h.mu.Lock()
+	defer h.mu.Unlock()
 	//if peer := h.peers.Get(key); peer != "" && peer != h.self {
 	//	h.Log("Pick peer %s", peer)
 	//	return h.httpGetter[peer], true
 	//}
 	//return nil, false
+	if h.peers == nil {
+		return nil, false
+	}
+	//  写入、租约、CompareAndSet和Incr必须由所属节点完成，熔断器打开时由client直接返回ErrBreakerOpen
 	peerAddr := h.peers.Get(key)
-	if peerAddr == h.addr {
+	if peerAddr == "" || peerAddr == h.addr {
 		log.Printf("ooh! pick myself, I am %s\n", h.addr)
 		return nil, false
 	}
@@ -277,16 +656,123 @@ func (h *server) PickPeer(key string) (Fetcher, bool) {
 	return h.clients[peerAddr], true
 }
 
+// PickReadPeer 返回读取key时访问的节点，所属节点的熔断器打开时顺着哈希环选择下一个可用的节点
+// 选中的是本节点时ok为false，选中的不是所属节点时diverted为true
+func (h *server) PickReadPeer(key string) (peer Fetcher, ok bool, diverted bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.peers == nil {
+		return nil, false, false
+	}
+	peerAddr := h.peers.Get(key)
+	if peerAddr != "" && peerAddr != h.addr && !h.clients[peerAddr].breaker.ready() {
+		//  所属节点的熔断器打开，顺着哈希环交给下一个可用的节点，可能是本节点
+		if available := h.availableLocked(key); len(available) > 0 {
+			log.Printf("[cache %s] circuit of %s is open, read from %s instead\n", h.addr, peerAddr, available[0])
+			peerAddr, diverted = available[0], true
+		}
+	}
+	if peerAddr == "" || peerAddr == h.addr {
+		return nil, false, diverted
+	}
+	return h.clients[peerAddr], true, diverted
+}
+
+// PickReplica 返回PickReadPeer选中的节点在一致性哈希环上之后的下一个可用节点，用于对冲请求
+// 它是本节点时返回false
+func (h *server) PickReplica(key string) (Fetcher, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.peers == nil {
+		return nil, false
+	}
+	available := h.availableLocked(key)
+	if len(available) < 2 || available[1] == h.addr {
+		return nil, false
+	}
+	return h.clients[available[1]], true
+}
+
+// availableLocked 按一致性哈希环的顺序返回key可以访问的节点，跳过熔断器打开的节点
+func (h *server) availableLocked(key string) []string {
+	var available []string
+	for _, peer := range h.peers.GetN(key, len(h.clients)) {
+		if c, ok := h.clients[peer]; peer == h.addr || ok && c.breaker.ready() {
+			available = append(available, peer)
+		}
+	}
+	return available
+}
+
+// Breakers 返回各远程节点熔断器的状态，按地址排序，未开启熔断时返回nil
+func (h *server) Breakers() []PeerBreaker {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.breakerConfig == nil {
+		return nil
+	}
+	breakers := make([]PeerBreaker, 0, len(h.clients))
+	for addr, c := range h.clients {
+		if addr != h.addr {
+			breakers = append(breakers, PeerBreaker{Peer: addr, State: c.breaker.State()})
+		}
+	}
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Peer < breakers[j].Peer })
+	return breakers
+}
+
+// GetAll 返回除本节点以外的所有节点，用于广播失效等需要所有节点参与的操作
+func (h *server) GetAll() []Fetcher {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	addrs := make([]string, 0, len(h.clients))
+	for addr := range h.clients {
+		if addr != h.addr {
+			addrs = append(addrs, addr)
+		}
+	}
+	//  顺序固定，便于排查问题
+	sort.Strings(addrs)
+	peers := make([]Fetcher, 0, len(addrs))
+	for _, addr := range addrs {
+		peers = append(peers, h.clients[addr])
+	}
+	return peers
+}
+
 // Stop停止server运行 如果server没有运行 这将是一个no-op
+// 先从etcd注销，再等待进行中的请求处理完毕后关闭服务
 func (h *server) Stop() {
 	h.mu.Lock()
 	if h.status == false {
 		h.mu.Unlock()
 		return
 	}
-	h.stopSignal <- nil //  发送停止keepalive信号
-	h.status = false    //  设置server运行状态为stop
-	h.clients = nil     //  清空一致性哈希信息 有助于垃圾回收
+	h.status = false //  设置server运行状态为stop
+	stop, revoked, grpcServer := h.stopSignal, h.revoked, h.grpcServer
+	close(h.stopping)
+	h.mu.Unlock()
+
+	if stop != nil {
+		select {
+		case stop <- nil: //  发送停止keepalive信号
+		case <-revoked: //  registry已经提前退出
+		}
+		<-revoked
+	}
+	//  不再接受新请求，等待进行中的请求结束
+	grpcServer.GracefulStop()
+
+	h.mu.Lock()
+	h.closeClients() //  清空一致性哈希信息 有助于垃圾回收
+	h.clients = nil
 	h.peers = nil
 	h.mu.Unlock()
 }
+
+// closeClients 关闭所有远程节点的连接，调用方需持有h.mu
+func (h *server) closeClients() {
+	for _, c := range h.clients {
+		c.Close()
+	}
+}
@@ -0,0 +1,170 @@
+package DistributedCache
+
+import (
+	pb "DistributedCache/geecachepb"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// freeAddr 返回一个当前可用的本地地址
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// startServer 启动一个不依赖etcd的server，返回后即可接受请求
+func startServer(t *testing.T, opts ...ServerOption) (*server, chan error) {
+	addr := freeAddr(t)
+	svr, err := NewServer(addr, append([]ServerOption{WithoutRegistry()}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- svr.Start() }()
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return svr, errc
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("server %s did not start", addr)
+	return nil, nil
+}
+
+// 测试不经过etcd直连远程节点，以及Stop后Start正常返回
+func TestServerFetchAndStop(t *testing.T) {
+	NewGroup("server-fetch", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		if key == "unknown" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte("v-" + key), nil
+	}))
+	svr, errc := startServer(t)
+
+	c := newPeerClient(svr.addr, nil, nil)
+	defer c.Close()
+	v, err := c.Fetch(context.Background(), "server-fetch", "k")
+	if err != nil || v.String() != "v-k" {
+		t.Fatalf("expected v-k, got %q, %v", v.String(), err)
+	}
+	if _, err := c.Fetch(context.Background(), "server-fetch", "unknown"); err == nil {
+		t.Fatalf("fetch unknown key should fail")
+	}
+
+	svr.Stop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Start returned %v after Stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start did not return after Stop")
+	}
+	//  重复Stop是no-op
+	svr.Stop()
+}
+
+// 测试PickPeer不会选中自己
+func TestPickPeer(t *testing.T) {
+	svr, _ := NewServer("127.0.0.1:8001", WithoutRegistry())
+	if _, ok := svr.PickPeer("k"); ok {
+		t.Fatalf("server without peers should not pick remote peer")
+	}
+	svr.Set("127.0.0.1:8001")
+	if _, ok := svr.PickPeer("k"); ok {
+		t.Fatalf("single node should always pick itself")
+	}
+	svr.Set("127.0.0.1:8001", "127.0.0.1:8002")
+	remote := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := svr.PickPeer(fmt.Sprintf("key%d", i)); ok {
+			remote++
+		}
+	}
+	if remote == 0 || remote == 100 {
+		t.Fatalf("keys should be spread over both peers, %d remote", remote)
+	}
+}
+
+// 测试一个请求在连接缓慢的节点上等待时，其它请求不会在client的锁上排队
+func TestClientSlowDial(t *testing.T) {
+	//  接受TCP连接但不完成gRPC握手
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	c := newPeerClient(lis.Addr().String(), nil, nil)
+	defer c.Close()
+
+	slow, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go c.Fetch(slow, "g", "k")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	start := time.Now()
+	if _, err := c.Fetch(ctx, "g", "k"); err == nil {
+		t.Fatalf("Fetch should fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Fetch waited %v for another request's dial", elapsed)
+	}
+}
+
+// 测试写入发给不是所属节点的节点时被拒绝，不会保存在这个节点上
+func TestPutRejectsNonOwner(t *testing.T) {
+	g := NewGroup("put-owner", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+	self := "127.0.0.1:8101"
+	svr, _ := NewServer(self, WithoutRegistry())
+	svr.Set(self, "127.0.0.1:8102")
+	defer svr.closeClients()
+	var mine, other string
+	for i := 0; mine == "" || other == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if svr.peers.Get(key) == self {
+			mine = key
+		} else {
+			other = key
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := svr.Put(ctx, &pb.Request{Group: "put-owner", Key: other, Value: []byte("v")}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Put to a non-owner = %v", err)
+	}
+	if _, err := svr.Delete(ctx, &pb.Request{Group: "put-owner", Key: other}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Delete on a non-owner = %v", err)
+	}
+	if _, ok := g.mainCache.get(other); ok {
+		t.Fatalf("misrouted write should not be stored")
+	}
+	if _, err := svr.Put(ctx, &pb.Request{Group: "put-owner", Key: mine, Value: []byte("v")}); err != nil {
+		t.Fatalf("Put to the owner = %v", err)
+	}
+	if _, ok := g.mainCache.get(mine); !ok {
+		t.Fatalf("write to the owner should be stored")
+	}
+}
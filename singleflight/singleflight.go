@@ -1,6 +1,12 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
 
 // singlefilght 为GeeCache提供缓存击穿的保护
 // 当cache并发访问peer获取缓存时 如果peer未缓存该值
@@ -14,6 +20,29 @@ type call struct {
 	wg  sync.WaitGroup
 	val interface{}
 	err error
+
+	dups  int             //  共享这次调用的其它请求数
+	chans []chan<- Result //  通过DoChan等待结果的请求
+}
+
+// ErrGoexit fn调用了runtime.Goexit，等待者收到这个错误
+var ErrGoexit = errors.New("singleflight: fn called runtime.Goexit")
+
+// PanicError fn发生panic时，等待者收到的错误
+type PanicError struct {
+	Value interface{} //  recover得到的值
+	Stack []byte      //  发生panic时的调用栈
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v", p.Value)
+}
+
+// Result 是DoChan返回的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool //  结果是否被多个请求共享
 }
 
 // singleflight 的主数据结构，管理不同 key 的请求(call)
@@ -31,8 +60,11 @@ Do 方法，接收 2 个参数，第一个参数是 `key`，
 - wg.Add(1) 锁加1。
 - wg.Wait() 阻塞，直到锁被释放。
 - wg.Done() 锁减1。
+shared 表示结果是否同时返回给了多个调用方
+fn发生panic时，执行fn的调用方重新panic，其它等待者收到 *PanicError
+fn调用runtime.Goexit时，其它等待者收到 ErrGoexit
 */
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
@@ -40,9 +72,10 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 
 	//  第一个get(key)请求到来时，如果call存在则返回数据
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
-		c.wg.Wait()         //后续的请求只需要等待第一个请求处理完成
-		return c.val, c.err //  请求结束返回结果
+		c.wg.Wait()               //后续的请求只需要等待第一个请求处理完成
+		return c.val, c.err, true //  请求结束返回结果
 	}
 	//  不存在,则去创建
 	c := new(call)
@@ -50,12 +83,98 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	g.m[key] = c //  添加到g.m 表明唯一的call的key已经有对应的请求在处理
 	g.mu.Unlock()
 
-	c.val, c.err = fn() //  调用fn发起请求，执行传入的函数，将结果存储到call结构体的字段中
-	c.wg.Done()         //  请求结束
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.dups > 0 //  返回结果
+}
 
+// DoChan 与Do相同，但不阻塞，结果就绪后从返回的channel中读取
+// fn在单独的goroutine中执行，发生panic时不会重新panic，所有等待者都收到 *PanicError
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.m, key) //  已完成,更新g.m
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
 	g.mu.Unlock()
 
-	return c.val, c.err //  返回结果
+	go func() {
+		defer func() {
+			//  没有调用方可以recover这个goroutine中的panic，错误已经交给了等待者
+			recover()
+		}()
+		g.doCall(c, key, fn)
+	}()
+	return ch
+}
+
+// DoContext 与Do相同，但ctx结束时调用方可以提前放弃等待并返回ctx.Err()
+// 放弃等待不会取消fn，fn的结果仍然会交给其它等待者，因此fn不应该依赖调用方的ctx
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	select {
+	case res := <-g.DoChan(key, fn):
+		return res.Val, res.Err, res.Shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
+}
+
+// Forget 忘记正在进行中的key，之后对该key的调用会重新执行fn而不是等待旧的结果
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
+
+// doCall 执行fn并把结果交给所有等待者
+// 无论fn正常返回、panic还是调用runtime.Goexit，call都会被清理，等待者不会永远阻塞
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	defer func() {
+		//  既没有正常返回也没有recover到panic，说明fn调用了runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = ErrGoexit
+		}
+		c.wg.Done() //  请求结束
+
+		g.mu.Lock()
+		//  Forget之后可能已经有新的call，不能删除它
+		if g.m[key] == c {
+			delete(g.m, key) //  已完成,更新g.m
+		}
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+		}
+		g.mu.Unlock()
+
+		if e, ok := c.err.(*PanicError); ok {
+			panic(e)
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				//  runtime.Goexit时也会执行到这里，recover返回nil，外层会把错误改为ErrGoexit
+				r := recover()
+				c.err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		c.val, c.err = fn() //  调用fn发起请求，执行传入的函数，将结果存储到call结构体的字段中
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
 }
@@ -0,0 +1,260 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr || v != nil {
+		t.Fatalf("Do = %v, %v", v, err)
+	}
+}
+
+// 测试并发的请求只执行一次fn，并且都被标记为shared
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg, started sync.WaitGroup
+	var sharedCount int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			v, err, shared := g.Do("key", fn)
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+		}()
+	}
+	started.Wait()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+	if sharedCount != n {
+		t.Fatalf("%d results marked shared, want %d", sharedCount, n)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ch1 := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	ch2 := g.DoChan("key", func() (interface{}, error) {
+		t.Error("second fn should not be called")
+		return nil, nil
+	})
+	close(release)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		if res := <-ch; res.Val != "bar" || res.Err != nil || !res.Shared {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+}
+
+// 测试等待者放弃等待不会影响正在执行的fn
+func TestDoContextAbandon(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	done := make(chan struct{})
+	leader := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		defer close(done)
+		if _, err, _ := g.DoContext(ctx, "key", nil); err != context.DeadlineExceeded {
+			t.Errorf("expected DeadlineExceeded, got %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("DoContext did not return after ctx deadline")
+	}
+
+	close(release)
+	if res := <-leader; res.Val != "bar" {
+		t.Fatalf("leader result %+v", res)
+	}
+}
+
+// 测试Forget之后的请求会重新执行fn
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if v != 2 || shared {
+		t.Fatalf("Do after Forget = %v, shared %v", v, shared)
+	}
+
+	close(release)
+	if res := <-first; res.Val != 1 {
+		t.Fatalf("first result %+v", res)
+	}
+}
+
+// 测试fn发生panic时Do重新panic，并且call被清理
+func TestDoPanic(t *testing.T) {
+	var g Group
+	func() {
+		defer func() {
+			r := recover()
+			if e, ok := r.(*PanicError); !ok || e.Value != "boom" || len(e.Stack) == 0 {
+				t.Fatalf("expected *PanicError, got %#v", r)
+			}
+		}()
+		g.Do("key", func() (interface{}, error) {
+			panic("boom")
+		})
+	}()
+
+	//  之后的调用不会死锁
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
+
+// 测试fn发生panic时所有等待者都收到 *PanicError
+func TestDoChanPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	const n = 5
+	chans := make([]<-chan Result, n)
+	for i := range chans {
+		chans[i] = g.DoChan("key", fn)
+	}
+	var waiterErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, waiterErr, _ = g.Do("key", fn)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for _, ch := range chans {
+		select {
+		case res := <-ch:
+			var pe *PanicError
+			if !errors.As(res.Err, &pe) || pe.Value != "boom" {
+				t.Fatalf("expected *PanicError, got %v", res.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter blocked after panic")
+		}
+	}
+	<-done
+	var pe *PanicError
+	if !errors.As(waiterErr, &pe) {
+		t.Fatalf("Do waiter expected *PanicError, got %v", waiterErr)
+	}
+}
+
+// 测试panic(nil)也被当作panic处理
+func TestDoChanPanicNil(t *testing.T) {
+	var g Group
+	res := <-g.DoChan("key", func() (interface{}, error) {
+		panic(nil)
+	})
+	var pe *PanicError
+	if !errors.As(res.Err, &pe) {
+		t.Fatalf("expected *PanicError, got %v", res.Err)
+	}
+}
+
+// 测试fn调用runtime.Goexit时等待者收到ErrGoexit
+func TestDoGoexit(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		runtime.Goexit()
+		return nil, nil
+	}
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		g.Do("key", fn)
+		t.Error("Do should not return after Goexit")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	waiter := g.DoChan("key", fn)
+	close(release)
+
+	select {
+	case res := <-waiter:
+		if res.Err != ErrGoexit {
+			t.Fatalf("expected ErrGoexit, got %v", res.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after Goexit")
+	}
+	<-leaderDone
+
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Fatalf("Do after Goexit = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,349 @@
+package DistributedCache
+
+import (
+	"DistributedCache/lru"
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+快照把mainCache保存为一个流，节点重启之后加载快照预热缓存，避免大量的key同时回源：
+	header  : "GEESNAP" 版本号(1字节) uvarint(代)
+	record  : uvarint(len(body)) body crc32c(body)
+	trailer : uvarint(0) uvarint(记录数) crc32c(之前的所有字节)
+body依次是key、value、过期时间、陈旧截止时间、过期方式、滑动过期时长和标签
+记录按LRU从新到旧的顺序写入，加载时依次插入LRU的队尾，恢复了原来的顺序，并且排在缓存中已有的条目之后，
+超过cacheBytes时放弃的是快照中最旧的条目，不会淘汰已有的条目
+加载时Group进入不小于快照的代，之后重放AOF时早于快照的Flush不再清空快照的条目
+每条记录有自己的校验和，加载时边读边写入缓存；trailer校验整个流，可以发现截断或者丢失的记录
+*/
+
+var (
+	// ErrSnapshotFormat 不是快照，或者是不支持的版本
+	ErrSnapshotFormat = errors.New("geecache: unsupported snapshot format")
+	// ErrSnapshotCorrupt 快照的校验和不一致或者被截断，之前已经校验通过的记录仍然会被加载
+	ErrSnapshotCorrupt = errors.New("geecache: snapshot corrupt")
+)
+
+const (
+	snapshotMagic   = "GEESNAP"
+	snapshotVersion = 1
+	//  单条记录的最大长度，防止损坏的长度字段导致分配过多内存
+	maxSnapshotRecord = 1 << 30
+)
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SaveSnapshot 把本节点mainCache中未过期的条目写入w，返回写入的条目数量
+func (g *Group) SaveSnapshot(w io.Writer) (int, error) {
+	entries, gen := g.mainCache.snapshot()
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	sw.write(append([]byte(snapshotMagic), snapshotVersion))
+	sw.write(binary.AppendUvarint(nil, gen))
+	for _, e := range entries {
+		body := encodeSnapshotEntry(e)
+		sw.write(binary.AppendUvarint(nil, uint64(len(body))))
+		sw.write(body)
+		sw.write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(body, snapshotTable)))
+	}
+	trailer := binary.AppendUvarint([]byte{0}, uint64(len(entries)))
+	sw.write(trailer)
+	sw.write(binary.BigEndian.AppendUint32(nil, sw.crc))
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	return len(entries), sw.w.Flush()
+}
+
+// LoadSnapshot 从r加载SaveSnapshot写入的快照，返回写入缓存的条目数量
+// 已经过期的条目和缓存中已经存在的key会被跳过，加载过程中超过cacheBytes时按LRU淘汰
+func (g *Group) LoadSnapshot(r io.Reader) (int, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	header := make([]byte, len(snapshotMagic)+1)
+	if err := sr.readFull(header); err != nil {
+		return 0, ErrSnapshotFormat
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic || header[len(snapshotMagic)] != snapshotVersion {
+		return 0, ErrSnapshotFormat
+	}
+	gen, err := sr.readUvarint()
+	if err != nil {
+		return 0, corrupt(err)
+	}
+	g.mainCache.advance(gen)
+	loaded, records := 0, uint64(0)
+	for {
+		size, err := sr.readUvarint()
+		if err != nil {
+			return loaded, corrupt(err)
+		}
+		if size == 0 {
+			break
+		}
+		if size > maxSnapshotRecord {
+			return loaded, fmt.Errorf("%w: record of %d bytes", ErrSnapshotCorrupt, size)
+		}
+		body := make([]byte, size+4)
+		if err := sr.readFull(body); err != nil {
+			return loaded, corrupt(err)
+		}
+		body, sum := body[:size], binary.BigEndian.Uint32(body[size:])
+		if crc32.Checksum(body, snapshotTable) != sum {
+			return loaded, fmt.Errorf("%w: checksum mismatch in record %d", ErrSnapshotCorrupt, records)
+		}
+		e, err := decodeSnapshotEntry(body)
+		if err != nil {
+			return loaded, fmt.Errorf("%w: record %d: %v", ErrSnapshotCorrupt, records, err)
+		}
+		records++
+		if hardExpired(e.it, time.Now()) {
+			continue
+		}
+		if g.mainCache.restore(e) {
+			loaded++
+		}
+	}
+	count, err := sr.readUvarint()
+	if err != nil {
+		return loaded, corrupt(err)
+	}
+	want := sr.crc
+	sum := make([]byte, 4)
+	if err := sr.readFull(sum); err != nil {
+		return loaded, corrupt(err)
+	}
+	if count != records || binary.BigEndian.Uint32(sum) != want {
+		return loaded, fmt.Errorf("%w: trailer mismatch", ErrSnapshotCorrupt)
+	}
+	return loaded, nil
+}
+
+// corrupt 把读取时遇到的EOF转换为ErrSnapshotCorrupt
+func corrupt(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of snapshot", ErrSnapshotCorrupt)
+	}
+	return err
+}
+
+// SaveSnapshotFile 把快照写入path，先写临时文件再重命名，写入失败时原有的快照不受影响
+func (g *Group) SaveSnapshotFile(path string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	n, err := g.SaveSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// LoadSnapshotFile 从path加载快照，文件不存在时什么都不做
+func (g *Group) LoadSnapshotFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return g.LoadSnapshot(f)
+}
+
+// RunSnapshots 每隔interval把快照写入path，ctx结束时再写入一次并返回
+// interval为0时只在ctx结束时写入
+func (g *Group) RunSnapshots(ctx context.Context, path string, interval time.Duration) error {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if _, err := g.SaveSnapshotFile(path); err != nil {
+				log.Printf("[geecache] group %s: save snapshot %s: %v", g.name, path, err)
+			}
+		case <-ctx.Done():
+			_, err := g.SaveSnapshotFile(path)
+			return err
+		}
+	}
+}
+
+// snapshotWriter 写入时计算整个流的校验和，出错之后不再写入
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc uint32
+	err error
+}
+
+func (w *snapshotWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc = crc32.Update(w.crc, snapshotTable, p)
+	_, w.err = w.w.Write(p)
+}
+
+// snapshotReader 读取时计算整个流的校验和
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc uint32
+}
+
+func (r *snapshotReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return err
+	}
+	r.crc = crc32.Update(r.crc, snapshotTable, p)
+	return nil
+}
+
+func (r *snapshotReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc = crc32.Update(r.crc, snapshotTable, []byte{b})
+	}
+	return b, err
+}
+
+func (r *snapshotReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+// encodeSnapshotEntry 编码一条记录的body
+func encodeSnapshotEntry(e snapshotEntry) []byte {
+	v := e.it.Value.(ByteView)
+	b := make([]byte, 0, len(e.key)+v.Len()+32)
+	b = appendBytes(b, []byte(e.key))
+	b = appendBytes(b, v.b)
+	b = binary.AppendVarint(b, toUnixNano(e.it.Expire))
+	b = binary.AppendVarint(b, toUnixNano(e.it.StaleUntil))
+	b = append(b, byte(e.it.Mode))
+	b = binary.AppendVarint(b, int64(e.it.TTL))
+	b = binary.AppendUvarint(b, uint64(len(e.tags)))
+	for _, tag := range e.tags {
+		b = appendBytes(b, []byte(tag))
+	}
+	return b
+}
+
+// decodeSnapshotEntry 解码encodeSnapshotEntry编码的body
+func decodeSnapshotEntry(b []byte) (snapshotEntry, error) {
+	d := snapshotDecoder{b: b}
+	key := string(d.bytes())
+	value := cloneBytes(d.bytes())
+	expire := fromUnixNano(d.varint())
+	stale := fromUnixNano(d.varint())
+	mode := lru.ExpireMode(d.byte())
+	ttl := time.Duration(d.varint())
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		//  每个标签至少占用一个字节
+		d.fail()
+		n = 0
+	}
+	tags := make([]string, n)
+	for i := range tags {
+		tags[i] = string(d.bytes())
+	}
+	if d.err != nil {
+		return snapshotEntry{}, d.err
+	}
+	if len(d.b) != 0 {
+		return snapshotEntry{}, fmt.Errorf("%d trailing bytes", len(d.b))
+	}
+	if key == "" {
+		return snapshotEntry{}, fmt.Errorf("empty key")
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	it := lru.Item{
+		Value:      ByteView{b: value, t: expire},
+		Expire:     expire,
+		StaleUntil: stale,
+		Mode:       mode,
+		TTL:        ttl,
+	}
+	return snapshotEntry{key: key, it: it, tags: tags}, nil
+}
+
+func appendBytes(b, p []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// snapshotDecoder 依次读取body中的字段，出错之后的读取都返回零值
+type snapshotDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *snapshotDecoder) fail() {
+	if d.err == nil {
+		d.err = errors.New("truncated record")
+	}
+	d.b = nil
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	n, size := binary.Uvarint(d.b)
+	if size <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[size:]
+	return n
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	n, size := binary.Varint(d.b)
+	if size <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[size:]
+	return n
+}
+
+func (d *snapshotDecoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail()
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *snapshotDecoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
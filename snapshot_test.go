@@ -0,0 +1,132 @@
+package DistributedCache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newSnapshotGroup(name string, cacheBytes int64) *Group {
+	return NewGroup(name, cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}))
+}
+
+// 测试快照保存键、值、过期时间、标签和LRU顺序，加载时跳过已经过期的条目
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := newSnapshotGroup("snapshot-src", 2<<10)
+	ctx := context.Background()
+	expire := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	src.Set(ctx, "a", []byte("1"), time.Time{}, "t1")
+	src.Set(ctx, "b", []byte("2"), expire)
+	src.Set(ctx, "expired", []byte("x"), time.Now().Add(-time.Second))
+	src.Set(ctx, "c", []byte("3"), time.Time{})
+	src.Get("a", time.Time{}) //  a变为最新
+
+	var buf bytes.Buffer
+	if n, err := src.SaveSnapshot(&buf); err != nil || n != 3 {
+		t.Fatalf("SaveSnapshot = %d, %v", n, err)
+	}
+	dst := newSnapshotGroup("snapshot-dst", 2<<10)
+	if n, err := dst.LoadSnapshot(&buf); err != nil || n != 3 {
+		t.Fatalf("LoadSnapshot = %d, %v", n, err)
+	}
+	if keys := dst.Keys(); !reflect.DeepEqual(keys, []string{"a", "c", "b"}) {
+		t.Fatalf("LRU order not restored: %v", keys)
+	}
+	if v, ok := dst.mainCache.get("b"); !ok || v.String() != "2" || !v.Expire().Equal(expire) {
+		t.Fatalf("unexpected b %v %v", v, ok)
+	}
+	if n, _ := dst.InvalidateTag(ctx, "t1"); n != 1 {
+		t.Fatalf("tags not restored, removed %d", n)
+	}
+}
+
+// 测试加载时遵守cacheBytes，已有的条目排在快照之前，保留快照中最新的条目，并且不覆盖已经存在的key
+func TestSnapshotLimits(t *testing.T) {
+	src := newSnapshotGroup("snapshot-limit-src", 2<<10)
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		src.Set(ctx, fmt.Sprintf("key%d", i), []byte("value"), time.Time{})
+	}
+	var buf bytes.Buffer
+	src.SaveSnapshot(&buf)
+
+	dst := newSnapshotGroup("snapshot-limit-dst", int64(len("key0value")*3))
+	dst.Set(ctx, "key9", []byte("fresh"), time.Time{})
+	if _, err := dst.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if keys := dst.Keys(); !reflect.DeepEqual(keys, []string{"key9", "key8", "key7"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if v, _ := dst.mainCache.get("key9"); v.String() != "fresh" {
+		t.Fatalf("existing key overwritten with %q", v.String())
+	}
+}
+
+// 测试损坏、截断和格式不对的快照
+func TestSnapshotCorrupt(t *testing.T) {
+	src := newSnapshotGroup("snapshot-corrupt-src", 2<<10)
+	ctx := context.Background()
+	src.Set(ctx, "first", []byte("1"), time.Time{})
+	src.Set(ctx, "second", []byte("2"), time.Time{})
+	var buf bytes.Buffer
+	src.SaveSnapshot(&buf)
+	data := buf.Bytes()
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-12] ^= 0xff //  最后一条记录的内容
+	dst := newSnapshotGroup("snapshot-corrupt-flip", 2<<10)
+	n, err := dst.LoadSnapshot(bytes.NewReader(flipped))
+	if !errors.Is(err, ErrSnapshotCorrupt) || n != 1 {
+		t.Fatalf("flipped byte: LoadSnapshot = %d, %v", n, err)
+	}
+	//  校验通过的记录仍然加载
+	if _, ok := dst.mainCache.get("second"); !ok {
+		t.Fatalf("records before the corruption should be loaded")
+	}
+
+	for i := len(snapshotMagic) + 1; i < len(data); i++ {
+		g := newSnapshotGroup(fmt.Sprintf("snapshot-corrupt-%d", i), 2<<10)
+		if _, err := g.LoadSnapshot(bytes.NewReader(data[:i])); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Fatalf("truncated at %d: %v", i, err)
+		}
+	}
+	if _, err := dst.LoadSnapshot(bytes.NewReader([]byte("GEESNAP\x09"))); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("unknown version: %v", err)
+	}
+	if _, err := dst.LoadSnapshot(bytes.NewReader(nil)); !errors.Is(err, ErrSnapshotFormat) {
+		t.Fatalf("empty input: %v", err)
+	}
+}
+
+// 测试写入快照文件，RunSnapshots在ctx结束时写入最后一次
+func TestSnapshotFile(t *testing.T) {
+	g := newSnapshotGroup("snapshot-file", 2<<10)
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	if n, err := g.LoadSnapshotFile(path); err != nil || n != 0 {
+		t.Fatalf("missing file: LoadSnapshotFile = %d, %v", n, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- g.RunSnapshots(ctx, path, time.Hour) }()
+	g.Set(context.Background(), "key", []byte("v"), time.Time{})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newSnapshotGroup("snapshot-file-restored", 2<<10)
+	if n, err := restored.LoadSnapshotFile(path); err != nil || n != 1 {
+		t.Fatalf("LoadSnapshotFile = %d, %v", n, err)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,111 @@
+package DistributedCache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 是可以并发读写的int64计数器
+type AtomicInt int64
+
+// Add 原子地加上n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取当前值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats 是Group的运行指标
+type Stats struct {
+	Gets           AtomicInt // 所有Get请求，包括来自远程节点的
+	CacheHits      AtomicInt // 命中mainCache的次数
+	Loads          AtomicInt // 未命中缓存，进入load的次数 (gets - cacheHits)
+	LoadsDeduped   AtomicInt // 经过singleflight去重后真正执行加载的次数
+	PeerLoads      AtomicInt // 从远程节点获取成功的次数
+	PeerErrors     AtomicInt // 从远程节点获取失败的次数
+	LocalLoads     AtomicInt // 本地调用getter成功的次数
+	LocalLoadErrs  AtomicInt // 本地调用getter失败的次数
+	ServerRequests AtomicInt // 收到远程节点请求的次数
+	StaleHits      AtomicInt // 过期后在stale-while-revalidate窗口内直接返回旧值的次数
+	StaleErrors    AtomicInt // 加载失败后返回旧值的次数
+	Refreshes      AtomicInt // 后台刷新的次数
+	EarlyRefreshes AtomicInt // 过期前触发提前刷新的次数
+	HotCacheHits   AtomicInt // 命中hotCache的次数
+	HotPromotions  AtomicInt // 热点key写入hotCache的次数
+	HotReplicated  AtomicInt // 作为所属节点要求请求方保存副本的次数
+	Retries        AtomicInt // 向其它节点的请求失败后重试的次数
+	Hedges         AtomicInt // 向后备节点发送对冲请求的次数
+	HedgeWins      AtomicInt // 对冲请求先于所属节点返回的次数
+}
+
+// CacheStats 是mainCache的容量信息
+type CacheStats struct {
+	Bytes int64 // 已使用的字节数
+	Items int64 // 条目数量
+}
+
+// GroupStats 是某一时刻Group指标的快照，便于序列化输出
+type GroupStats struct {
+	Name           string   `json:"name"`
+	Gets           int64    `json:"gets"`
+	CacheHits      int64    `json:"cache_hits"`
+	Loads          int64    `json:"loads"`
+	LoadsDeduped   int64    `json:"loads_deduped"`
+	PeerLoads      int64    `json:"peer_loads"`
+	PeerErrors     int64    `json:"peer_errors"`
+	LocalLoads     int64    `json:"local_loads"`
+	LocalLoadErrs  int64    `json:"local_load_errs"`
+	ServerRequests int64    `json:"server_requests"`
+	StaleHits      int64    `json:"stale_hits"`
+	StaleErrors    int64    `json:"stale_errors"`
+	Refreshes      int64    `json:"refreshes"`
+	EarlyRefreshes int64    `json:"early_refreshes"`
+	CacheBytes     int64    `json:"cache_bytes"`
+	CacheItems     int64    `json:"cache_items"`
+	Generation     uint64   `json:"generation"`
+	HotCacheHits   int64    `json:"hot_cache_hits"`
+	HotPromotions  int64    `json:"hot_promotions"`
+	HotKeys        []HotKey `json:"hot_keys,omitempty"`
+	HotReplicated  int64    `json:"hot_replicated"`
+	Retries        int64    `json:"retries"`
+	Hedges         int64    `json:"hedges"`
+	HedgeWins      int64    `json:"hedge_wins"`
+}
+
+// StatsSnapshot 返回Group当前的指标快照
+func (g *Group) StatsSnapshot() GroupStats {
+	cs := g.CacheStats()
+	return GroupStats{
+		Name:           g.name,
+		Gets:           g.Stats.Gets.Get(),
+		CacheHits:      g.Stats.CacheHits.Get(),
+		Loads:          g.Stats.Loads.Get(),
+		LoadsDeduped:   g.Stats.LoadsDeduped.Get(),
+		PeerLoads:      g.Stats.PeerLoads.Get(),
+		PeerErrors:     g.Stats.PeerErrors.Get(),
+		LocalLoads:     g.Stats.LocalLoads.Get(),
+		LocalLoadErrs:  g.Stats.LocalLoadErrs.Get(),
+		ServerRequests: g.Stats.ServerRequests.Get(),
+		StaleHits:      g.Stats.StaleHits.Get(),
+		StaleErrors:    g.Stats.StaleErrors.Get(),
+		Refreshes:      g.Stats.Refreshes.Get(),
+		EarlyRefreshes: g.Stats.EarlyRefreshes.Get(),
+		CacheBytes:     cs.Bytes,
+		CacheItems:     cs.Items,
+		Generation:     g.Generation(),
+		HotCacheHits:   g.Stats.HotCacheHits.Get(),
+		HotPromotions:  g.Stats.HotPromotions.Get(),
+		HotKeys:        g.HotKeys(),
+		HotReplicated:  g.Stats.HotReplicated.Get(),
+		Retries:        g.Stats.Retries.Get(),
+		Hedges:         g.Stats.Hedges.Get(),
+		HedgeWins:      g.Stats.HedgeWins.Get(),
+	}
+}
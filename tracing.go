@@ -0,0 +1,67 @@
+package DistributedCache
+
+import (
+	"DistributedCache/tracing"
+	"context"
+	"google.golang.org/grpc/metadata"
+	"sync"
+)
+
+// tracer 是整个geecache使用的Tracer，默认是no-op
+var (
+	tracerMu sync.RWMutex
+	tracer   = tracing.NoopTracer()
+)
+
+// SetTracer 替换全局Tracer，传入nil则恢复为no-op
+func SetTracer(t tracing.Tracer) {
+	if t == nil {
+		t = tracing.NoopTracer()
+	}
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+}
+
+// startSpan 使用全局Tracer开启一个Span
+func startSpan(ctx context.Context, name string) (context.Context, tracing.Span) {
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+	return t.Start(ctx, name)
+}
+
+// mdCarrier 让gRPC metadata实现tracing.Carrier
+type mdCarrier metadata.MD
+
+func (c mdCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c mdCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// injectTrace 将追踪上下文写入出站的gRPC metadata
+func injectTrace(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	tracing.Inject(ctx, mdCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// extractTrace 从入站的gRPC metadata中恢复远端的追踪上下文
+func extractTrace(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return tracing.Extract(ctx, mdCarrier(md))
+}
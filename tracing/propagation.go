@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// 跨进程传播追踪上下文，格式兼容W3C Trace Context:
+// traceparent: 00-<trace-id>-<span-id>-01
+
+// TraceParentKey 在载体(例如gRPC metadata)中使用的键名
+const TraceParentKey = "traceparent"
+
+// Carrier 抽象了可以读写键值的传输载体
+type Carrier interface {
+	Get(key string) string
+	Set(key string, value string)
+}
+
+// Inject 将ctx中的SpanContext写入carrier，ctx中没有有效上下文时什么都不做
+func Inject(ctx context.Context, carrier Carrier) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	carrier.Set(TraceParentKey, fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID))
+}
+
+// Extract 从carrier读取远端的SpanContext并放入ctx
+// 解析失败时原样返回ctx，不影响正常请求
+func Extract(ctx context.Context, carrier Carrier) context.Context {
+	sc, ok := parseTraceParent(carrier.Get(TraceParentKey))
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+func parseTraceParent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+/**
+tracing 模块为geecache提供轻量的分布式链路追踪能力
+接口风格参考OpenTelemetry，但不依赖任何SDK：
+Tracer 负责创建 Span，Span 结束后交给 Exporter 导出。
+默认使用 no-op 实现，不产生任何开销；测试时可以使用 InMemoryExporter 收集 Span。
+*/
+
+// TraceID 一条调用链的唯一标识
+type TraceID [16]byte
+
+// SpanID 调用链中一个区间的唯一标识
+type SpanID [8]byte
+
+// IsValid 全零的ID视为无效
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String 返回十六进制表示
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid 全零的ID视为无效
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String 返回十六进制表示
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext 是需要跨进程传播的追踪上下文
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid TraceID和SpanID都有效时才认为上下文有效
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Span 表示一次操作的区间
+type Span interface {
+	SpanContext() SpanContext
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer 根据ctx中的父Span创建子Span，并返回携带新Span的ctx
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type spanKey struct{}
+
+// ContextWithSpanContext 将SpanContext放入ctx，作为后续Span的父节点
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// SpanContextFromContext 取出ctx中当前的SpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// ---------------------------- no-op 实现 ----------------------------
+
+type noopTracer struct{}
+
+type noopSpan struct{ sc SpanContext }
+
+// NoopTracer 返回一个什么都不做的Tracer
+// 它不会生成新的ID，但会保留ctx中已有的上下文，这样即使本节点不采集，调用链也不会断
+func NoopTracer() Tracer { return noopTracer{} }
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{sc: SpanContextFromContext(ctx)}
+}
+
+func (s noopSpan) SpanContext() SpanContext                 { return s.sc }
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+
+// ---------------------------- 基础实现 ----------------------------
+
+// SpanData 是已结束Span的只读快照，交给Exporter导出
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Err        error
+}
+
+// Exporter 接收已结束的Span
+type Exporter interface {
+	Export(span SpanData)
+}
+
+type tracer struct {
+	exporter Exporter
+}
+
+// NewTracer 创建一个真正记录Span的Tracer，Span结束时交给exporter
+func NewTracer(exporter Exporter) Tracer {
+	return &tracer{exporter: exporter}
+}
+
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent := SpanContextFromContext(ctx)
+	s := &span{
+		tracer: t,
+		data: SpanData{
+			Name:   name,
+			Parent: parent.SpanID,
+			Start:  time.Now(),
+		},
+	}
+	//  有父节点则沿用TraceID，否则开启一条新的调用链
+	if parent.TraceID.IsValid() {
+		s.data.Context.TraceID = parent.TraceID
+	} else {
+		rand.Read(s.data.Context.TraceID[:])
+	}
+	rand.Read(s.data.Context.SpanID[:])
+	return ContextWithSpanContext(ctx, s.data.Context), s
+}
+
+type span struct {
+	tracer *tracer
+	mu     sync.Mutex
+	ended  bool
+	data   SpanData
+}
+
+func (s *span) SpanContext() SpanContext { return s.data.Context }
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+func (s *span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Err = err
+	s.mu.Unlock()
+}
+
+// End 结束Span，重复调用是no-op
+func (s *span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+// InMemoryExporter 把Span保存在内存中，主要用于测试
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans 按结束顺序返回已导出的Span
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make([]SpanData, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// Reset 清空已收集的Span
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mapCarrier map[string]string
+
+func (m mapCarrier) Get(key string) string        { return m[key] }
+func (m mapCarrier) Set(key string, value string) { m[key] = value }
+
+// 测试子Span继承父Span的TraceID，并记录父SpanID
+func TestParentChild(t *testing.T) {
+	exp := NewInMemoryExporter()
+	tr := NewTracer(exp)
+
+	ctx, parent := tr.Start(context.Background(), "parent")
+	_, child := tr.Start(ctx, "child")
+	child.SetAttribute("key", "k1")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.End()
+	parent.End() //  重复End不应该重复导出
+
+	spans := exp.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+	if c.Name != "child" || p.Name != "parent" {
+		t.Fatalf("unexpected span order %s, %s", c.Name, p.Name)
+	}
+	if c.Context.TraceID != p.Context.TraceID || c.Parent != p.Context.SpanID {
+		t.Fatalf("child span is not linked to parent")
+	}
+	if c.Attributes["key"] != "k1" || c.Err == nil {
+		t.Fatalf("attributes or error not recorded")
+	}
+}
+
+// 测试traceparent的注入与提取
+func TestInjectExtract(t *testing.T) {
+	tr := NewTracer(NewInMemoryExporter())
+	ctx, span := tr.Start(context.Background(), "client")
+	carrier := mapCarrier{}
+	Inject(ctx, carrier)
+	if carrier[TraceParentKey] == "" {
+		t.Fatalf("traceparent not injected")
+	}
+
+	remote := Extract(context.Background(), carrier)
+	if got := SpanContextFromContext(remote); got != span.SpanContext() {
+		t.Fatalf("expected %v, got %v", span.SpanContext(), got)
+	}
+
+	//  非法的traceparent应该被忽略
+	bad := Extract(context.Background(), mapCarrier{TraceParentKey: "00-xyz-01"})
+	if SpanContextFromContext(bad).IsValid() {
+		t.Fatalf("invalid traceparent should be ignored")
+	}
+}
+
+// 测试no-op Tracer不会生成上下文，但会透传已有的上下文
+func TestNoopTracer(t *testing.T) {
+	ctx, span := NoopTracer().Start(context.Background(), "noop")
+	if span.SpanContext().IsValid() {
+		t.Fatalf("noop tracer should not create span context")
+	}
+	Inject(ctx, mapCarrier{})
+
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}
+	_, span = NoopTracer().Start(ContextWithSpanContext(context.Background(), sc), "noop")
+	if span.SpanContext() != sc {
+		t.Fatalf("noop tracer should keep parent span context")
+	}
+}
@@ -0,0 +1,130 @@
+package DistributedCache
+
+import (
+	pb "DistributedCache/geecachepb"
+	"DistributedCache/tracing"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"testing"
+	"time"
+)
+
+// fakePeers 把所有key都路由到一个本地的fakeFetcher，用于模拟远程节点
+type fakePeers struct{ fetcher Fetcher }
+
+func (p fakePeers) PickPeer(key string) (Fetcher, bool) { return p.fetcher, true }
+
+func (p fakePeers) GetAll() []Fetcher { return []Fetcher{p.fetcher} }
+
+type fakeFetcher struct{}
+
+func (fakeFetcher) Fetch(ctx context.Context, group string, key string) (ByteView, error) {
+	_, span := startSpan(ctx, "fakeFetcher.Fetch")
+	defer span.End()
+	return ByteView{b: []byte("remote-" + key)}, nil
+}
+
+func (fakeFetcher) Set(ctx context.Context, group string, key string, value []byte, expir time.Time, tags ...string) error {
+	return nil
+}
+
+func (fakeFetcher) Delete(ctx context.Context, group string, key string) error {
+	return nil
+}
+
+func (fakeFetcher) Lease(ctx context.Context, group string, key string) (Lease, error) {
+	return Lease{}, ErrLeaseWait
+}
+
+func (fakeFetcher) SetWithLease(ctx context.Context, group string, key string, value []byte, expir time.Time, token uint64) error {
+	return ErrLeaseInvalid
+}
+
+func (fakeFetcher) CompareAndSet(ctx context.Context, group string, key string, value []byte, expir time.Time, expected uint64) (uint64, error) {
+	return 0, &VersionConflictError{Key: key, Expected: expected}
+}
+
+func (fakeFetcher) Incr(ctx context.Context, group string, key string, delta int64, expir time.Time) (int64, error) {
+	return delta, nil
+}
+
+func (fakeFetcher) Invalidate(ctx context.Context, group string, tag string, prefix string) (int64, error) {
+	return 0, nil
+}
+
+func (fakeFetcher) Flush(ctx context.Context, group string, gen uint64) (uint64, error) {
+	return gen, nil
+}
+
+func (fakeFetcher) DropCopies(ctx context.Context, group string, key string) error {
+	return nil
+}
+
+func spanByName(spans []tracing.SpanData, name string) (tracing.SpanData, bool) {
+	for _, s := range spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return tracing.SpanData{}, false
+}
+
+// 测试Group.Get经过load、singleflight到远程节点的Span链路
+func TestGroupGetSpans(t *testing.T) {
+	exp := tracing.NewInMemoryExporter()
+	SetTracer(tracing.NewTracer(exp))
+	defer SetTracer(nil)
+
+	g := NewGroup("trace-peer", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("should not be called")
+	}))
+	g.RegisterPeers(fakePeers{fetcher: fakeFetcher{}})
+
+	if v, err := g.Get("k", time.Time{}); err != nil || v.String() != "remote-k" {
+		t.Fatalf("unexpected result %q, %v", v.String(), err)
+	}
+
+	spans := exp.Spans()
+	chain := []string{"fakeFetcher.Fetch", "singleflight.Do", "Group.load", "Group.Get"}
+	for i := 0; i < len(chain)-1; i++ {
+		child, ok1 := spanByName(spans, chain[i])
+		parent, ok2 := spanByName(spans, chain[i+1])
+		if !ok1 || !ok2 {
+			t.Fatalf("missing span %s or %s", chain[i], chain[i+1])
+		}
+		if child.Parent != parent.Context.SpanID || child.Context.TraceID != parent.Context.TraceID {
+			t.Fatalf("%s is not a child of %s", chain[i], chain[i+1])
+		}
+	}
+}
+
+// 测试追踪上下文经过gRPC metadata传递后，server.Get与调用方属于同一条调用链
+func TestTracePropagation(t *testing.T) {
+	exp := tracing.NewInMemoryExporter()
+	SetTracer(tracing.NewTracer(exp))
+	defer SetTracer(nil)
+
+	NewGroup("trace-server", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	ctx, span := startSpan(context.Background(), "caller")
+	out := injectTrace(ctx)
+	md, _ := metadata.FromOutgoingContext(out)
+	in := metadata.NewIncomingContext(context.Background(), md)
+
+	svr := &server{addr: "127.0.0.1:0"}
+	if _, err := svr.Get(in, &pb.Request{Group: "trace-server", Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+
+	got, ok := spanByName(exp.Spans(), "server.Get")
+	if !ok {
+		t.Fatalf("server.Get span not exported")
+	}
+	if got.Context.TraceID != span.SpanContext().TraceID || got.Parent != span.SpanContext().SpanID {
+		t.Fatalf("server.Get span is not linked to remote caller")
+	}
+}
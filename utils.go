@@ -1,9 +1,11 @@
 package DistributedCache
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // 显示错误时运行堆栈
@@ -34,3 +36,37 @@ func validPeerAddr(addr string) bool {
 	}
 	return true
 }
+
+// toUnixNano 将过期时间转换为unix纳秒，零值表示永不过期
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano 是toUnixNano的逆操作
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// detachedContext 保留父context中的值，但不会随父context取消或超时
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// detach 返回一个不会被取消的ctx，用于多个请求共享的后台操作
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}